@@ -0,0 +1,470 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package alerts matches incoming text messages and stream transcripts
+// against configured rules, takes geofence events from the location worker, connection
+// state changes from the network worker, channel condition changes from
+// the status worker and private messages, and raises alerts to the log,
+// gRPC watchers, webhooks and a journal file.
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmurray/monitor/channelstatus"
+	"github.com/jcmurray/monitor/locations"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	watcherQueueLength = 16
+)
+
+// Alert raised when a rule matches
+type Alert struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Rule     string    `json:"rule"`
+	Severity Severity  `json:"severity"`
+	Source   Source    `json:"source"`
+	Channel  string    `json:"channel,omitempty"`
+	From     string    `json:"from,omitempty"`
+	For      string    `json:"for,omitempty"`
+	Text     string    `json:"text"`
+	Matched  string    `json:"matched"`
+}
+
+// SubscriptionTypeTranscript carries a Transcript for each stream
+// transcribed by a speech to text worker
+const SubscriptionTypeTranscript = "stream_transcript"
+
+// Transcript of a voice stream
+type Transcript struct {
+	StreamID int       `json:"stream_id"`
+	Channel  string    `json:"channel,omitempty"`
+	From     string    `json:"from,omitempty"`
+	For      string    `json:"for,omitempty"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
+}
+
+// AlertWorker alert worker
+type AlertWorker struct {
+	sync.Mutex
+	command         chan int
+	log             *log.Entry
+	id              int
+	label           string
+	workers         *worker.Workers
+	rules           []*Rule
+	window          time.Duration
+	privateAlerts   bool // raise an alert for each private message received
+	privateSeverity Severity
+	suppress        map[string]time.Time
+	journal         *Journal
+	webhooks        []*Webhook
+	watchers        map[int]*watcher
+	nextWatcher     int
+	nextID          uint64
+}
+
+// NewAlertWorker create a new AlertWorker
+func NewAlertWorker(workers *worker.Workers, id int, label string) *AlertWorker {
+	return &AlertWorker{
+		command:  make(chan int, 10),
+		id:       id,
+		label:    label,
+		log:      log.WithFields(log.Fields{"Label": label, "ID": id}),
+		workers:  workers,
+		suppress: make(map[string]time.Time),
+		watchers: make(map[int]*watcher),
+		nextID:   1,
+	}
+}
+
+// Run is main function of this worker
+func (w *AlertWorker) Run(wg *sync.WaitGroup, term *chan int) {
+	defer wg.Done()
+	w.log.Debugf("Worker Started")
+
+	if err := w.configure(); err != nil {
+		w.log.Errorf("Alert configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+
+	nw := w.findNetWorker()
+	textMessageChannel := nw.Subscribe(w.id, protocolapp.OnTextMessageEvent, w.label).Channel
+	transcriptChannel := nw.Subscribe(w.id, SubscriptionTypeTranscript, w.label).Channel
+	geofenceChannel := nw.Subscribe(w.id, locations.SubscriptionTypeGeofence, w.label).Channel
+	stateChannel := nw.Subscribe(w.id, network.SubscriptionTypeState, w.label).Channel
+	channelStateChannel := nw.Subscribe(w.id, channelstatus.SubscriptionTypeChannelState, w.label).Channel
+	privateChannel := nw.Subscribe(w.id, private.SubscriptionTypePrivateMessage, w.label).Channel
+	for _, h := range w.webhooks {
+		h.Start()
+	}
+	w.log.Infof("Watching for %d alert rules", len(w.rules))
+
+waitloop:
+	for {
+		w.log.Debugf("Entering Select")
+		select {
+		case textMessage := <-textMessageChannel:
+			c := protocolapp.NewOnTextMessage()
+			if err := json.Unmarshal(textMessage.([]byte), c); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.evaluate(Input{Source: SourceText, Channel: c.Channel, From: c.From, For: c.For, ID: c.MessageID, Text: c.Text})
+
+		case transcript := <-transcriptChannel:
+			t := &Transcript{}
+			if err := json.Unmarshal(transcript.([]byte), t); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.evaluate(Input{Source: SourceTranscript, Channel: t.Channel, From: t.From, For: t.For, ID: t.StreamID, Text: t.Text})
+
+		case geofence := <-geofenceChannel:
+			e := &locations.GeofenceEvent{}
+			if err := json.Unmarshal(geofence.([]byte), e); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.geofence(*e)
+
+		case state := <-stateChannel:
+			t := &network.StateTransition{}
+			if err := json.Unmarshal(state.([]byte), t); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.connection(*t)
+
+		case channelState := <-channelStateChannel:
+			e := &channelstatus.ChannelEvent{}
+			if err := json.Unmarshal(channelState.([]byte), e); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.channel(*e)
+
+		case privateMessage := <-privateChannel:
+			m := &private.Message{}
+			if err := json.Unmarshal(privateMessage.([]byte), m); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.privateMessage(*m)
+
+		case alertCommand, more := <-w.command:
+			if more {
+				w.log.Debugf("Received command %d", alertCommand)
+				switch alertCommand {
+				case worker.Terminate:
+					w.log.Debugf("Terminating")
+					break waitloop
+				default:
+					continue
+				}
+			} else {
+				w.log.Info("Channel closed")
+				break waitloop
+			}
+		}
+	}
+
+	nw.UnSubscribe(w.id, protocolapp.OnTextMessageEvent)
+	nw.UnSubscribe(w.id, SubscriptionTypeTranscript)
+	nw.UnSubscribe(w.id, locations.SubscriptionTypeGeofence)
+	nw.UnSubscribe(w.id, network.SubscriptionTypeState)
+	nw.UnSubscribe(w.id, channelstatus.SubscriptionTypeChannelState)
+	nw.UnSubscribe(w.id, private.SubscriptionTypePrivateMessage)
+
+	for _, h := range w.webhooks {
+		h.Stop()
+	}
+	if w.journal != nil {
+		w.journal.Close()
+	}
+	w.Lock()
+	for id, watch := range w.watchers {
+		close(watch.alerts)
+		delete(w.watchers, id)
+	}
+	w.Unlock()
+
+	w.log.Debug("Finished")
+}
+
+// configure reads the 'alerts' config section
+func (w *AlertWorker) configure() error {
+	rules, err := NewRules()
+	if err != nil {
+		return err
+	}
+	webhooks, err := NewWebhooks(w.log)
+	if err != nil {
+		return err
+	}
+	if name := viper.GetString("alerts.journal"); name != "" {
+		if w.journal, err = OpenJournal(name); err != nil {
+			return err
+		}
+	}
+	w.rules = rules
+	w.webhooks = webhooks
+	w.window = viper.GetDuration("alerts.dedup_window")
+	if name := viper.GetString("private.alert_severity"); !strings.EqualFold(name, "none") {
+		if w.privateSeverity, err = ParseSeverity(name); err != nil {
+			return errors.Annotate(err, "private.alert_severity")
+		}
+		w.privateAlerts = true
+	}
+	return nil
+}
+
+// evaluate checks in against every rule and raises an alert for each
+// match not seen within its de-duplication window
+func (w *AlertWorker) evaluate(in Input) {
+	now := time.Now().UTC()
+	expire(w.suppress, now)
+
+	for _, r := range w.rules {
+		matched, ok := r.Match(in)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(r.Name + "\x00" + in.From + "\x00" + matched)
+		if _, seen := w.suppress[key]; seen {
+			w.log.Debugf("Alert '%s' from '%s' suppressed as a duplicate", r.Name, in.From)
+			continue
+		}
+		window := r.Dedup
+		if window == 0 {
+			window = w.window
+		}
+		if window > 0 {
+			w.suppress[key] = now.Add(window)
+		}
+
+		a := Alert{
+			ID:       w.nextID,
+			Time:     now,
+			Rule:     r.Name,
+			Severity: r.Severity,
+			Source:   in.Source,
+			Channel:  in.Channel,
+			From:     in.From,
+			For:      in.For,
+			Text:     in.Text,
+			Matched:  matched,
+		}
+		w.nextID++
+		w.raise(a)
+	}
+}
+
+// geofence raises an alert for a user entering, leaving or dwelling in a
+// geofence. The location worker's hysteresis stands in for
+// de-duplication.
+func (w *AlertWorker) geofence(e locations.GeofenceEvent) {
+	severity, err := ParseSeverity(e.Severity)
+	if err != nil {
+		w.log.Errorf("Geofence '%s': %s", e.Fence, err)
+	}
+	a := Alert{
+		ID:       w.nextID,
+		Time:     e.Time,
+		Rule:     e.Fence,
+		Severity: severity,
+		Source:   SourceGeofence,
+		Channel:  e.Channel,
+		From:     e.User,
+		Text:     e.String(),
+		Matched:  e.Kind,
+	}
+	w.nextID++
+	w.raise(a)
+}
+
+// connection raises an alert when the connection to Zello backs off,
+// fails for good or comes online. Other transitions are passing steps.
+func (w *AlertWorker) connection(t network.StateTransition) {
+	var severity Severity
+	switch t.To {
+	case network.StateOnline:
+		severity = SeverityInfo
+	case network.StateBackoff:
+		severity = SeverityWarning
+	case network.StateFatal:
+		severity = SeverityCritical
+	default:
+		return
+	}
+	a := Alert{
+		ID:       w.nextID,
+		Time:     t.At,
+		Rule:     "connection",
+		Severity: severity,
+		Source:   SourceConnection,
+		Text:     fmt.Sprintf("%s -> %s: %s", t.From, t.To, t.Reason),
+		Matched:  t.To.String(),
+	}
+	w.nextID++
+	w.raise(a)
+}
+
+// channel raises an alert when the channel goes offline, is closed, we
+// are blocked or it reports an error, and when it comes back online
+func (w *AlertWorker) channel(e channelstatus.ChannelEvent) {
+	severity := SeverityWarning
+	text := fmt.Sprintf("%s -> %s: %s", e.From, e.To, e.Reason)
+	if e.To == channelstatus.ConditionOnline {
+		if e.From == channelstatus.ConditionUnknown {
+			return
+		}
+		severity = SeverityInfo
+		text = fmt.Sprintf("back online after %s", e.Down.Round(time.Second))
+	}
+	a := Alert{
+		ID:       w.nextID,
+		Time:     e.At,
+		Rule:     "channel",
+		Severity: severity,
+		Source:   SourceChannel,
+		Channel:  e.Channel,
+		Text:     text,
+		Matched:  string(e.To),
+	}
+	w.nextID++
+	w.raise(a)
+}
+
+// privateMessage raises an alert for a private message received, so one-to-one
+// traffic stands out from the channel
+func (w *AlertWorker) privateMessage(m private.Message) {
+	if !w.privateAlerts || m.Outgoing {
+		return
+	}
+	text := m.Text
+	if text == "" {
+		text = fmt.Sprintf("private %s", m.Kind)
+	}
+	a := Alert{
+		ID:       w.nextID,
+		Time:     m.Time,
+		Rule:     "private",
+		Severity: w.privateSeverity,
+		Source:   SourcePrivate,
+		Channel:  m.Channel,
+		From:     m.From,
+		For:      m.For,
+		Text:     text,
+		Matched:  string(m.Kind),
+	}
+	w.nextID++
+	w.raise(a)
+}
+
+// expire removes dedup entries whose window has passed
+func expire(until map[string]time.Time, now time.Time) {
+	for k, t := range until {
+		if !now.Before(t) {
+			delete(until, k)
+		}
+	}
+}
+
+// raise sends an alert to every sink
+func (w *AlertWorker) raise(a Alert) {
+	w.log.Logf(logLevel(a.Severity), "Alert %d %s '%s' - '%s' from '%s' on '%s': %s", a.ID, a.Severity, a.Rule, a.Matched, a.From, a.Channel, a.Text)
+	if w.journal != nil {
+		if err := w.journal.Write(a); err != nil {
+			w.log.Errorf("Alert journal error: %s", err)
+		}
+	}
+	for _, h := range w.webhooks {
+		h.Send(a)
+	}
+	w.Lock()
+	defer w.Unlock()
+	for id, watch := range w.watchers {
+		if a.Severity < watch.minSeverity {
+			continue
+		}
+		select {
+		case watch.alerts <- a:
+		default:
+			w.log.Warnf("Alert %d dropped for watcher %d, it is not keeping up", a.ID, id)
+		}
+	}
+}
+
+// Watch returns a channel receiving alerts of at least minSeverity, and
+// an id to stop watching with. The channel is closed when the worker
+// stops.
+func (w *AlertWorker) Watch(minSeverity Severity) (int, <-chan Alert) {
+	w.Lock()
+	defer w.Unlock()
+	w.nextWatcher++
+	watch := &watcher{minSeverity: minSeverity, alerts: make(chan Alert, watcherQueueLength)}
+	w.watchers[w.nextWatcher] = watch
+	return w.nextWatcher, watch.alerts
+}
+
+// Unwatch stops sending alerts to a watcher
+func (w *AlertWorker) Unwatch(id int) {
+	w.Lock()
+	defer w.Unlock()
+	if watch, ok := w.watchers[id]; ok {
+		close(watch.alerts)
+		delete(w.watchers, id)
+	}
+}
+
+// Command sent to this worker
+func (w *AlertWorker) Command(c int) {
+	w.command <- c
+}
+
+// Terminate the worker
+func (w *AlertWorker) Terminate() {
+	w.Command(worker.Terminate)
+}
+
+// FindNetWorker find Net worker
+func (w *AlertWorker) findNetWorker() *network.Networker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *network.Networker:
+			return (*w.workers)[i].(*network.Networker)
+		}
+	}
+	return nil
+}
+
+// Label return label of worker
+func (w *AlertWorker) Label() string {
+	return w.label
+}
+
+// ID return label of worker
+func (w *AlertWorker) ID() int {
+	return w.id
+}
+
+// Subscriptions return a copy of current scubscriptions
+func (w *AlertWorker) Subscriptions() []*worker.Subscription {
+	return make([]*worker.Subscription, 0)
+}
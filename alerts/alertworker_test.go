@@ -0,0 +1,195 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package alerts
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/channelstatus"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/spf13/viper"
+)
+
+// startAlertWorker runs an alert worker with the given rules and no
+// sinks, and returns the network worker it subscribes to and a watcher of
+// every alert
+func startAlertWorker(t *testing.T, rules ...map[string]interface{}) (*network.Networker, <-chan Alert) {
+	t.Helper()
+	viper.Set("alerts.journal", "")
+	viper.Set("alerts.rules", rules)
+	viper.Set("alerts.webhooks", nil)
+
+	var (
+		workers worker.Workers
+		wg      sync.WaitGroup
+	)
+	term := make(chan int, 1)
+	nw := network.NewNetworker(&workers, 1, "Network Worker")
+	aw := NewAlertWorker(&workers, 2, "Alert Worker")
+	workers = append(workers, nw, aw)
+	_, watch := aw.Watch(SeverityInfo)
+	wg.Add(1)
+	go aw.Run(&wg, &term)
+	t.Cleanup(func() {
+		aw.Terminate()
+		wg.Wait()
+	})
+	return nw, watch
+}
+
+// publish an event once the alert worker has subscribed to its type
+func publish(t *testing.T, nw *network.Networker, sType string, event interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !subscribed(nw, sType) {
+		if time.Now().After(deadline) {
+			t.Fatalf("alert worker did not subscribe to %s", sType)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	buff, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nw.Publish(sType, buff)
+}
+
+func subscribed(nw *network.Networker, sType string) bool {
+	for _, s := range nw.Subscriptions() {
+		if s.Type == sType {
+			return true
+		}
+	}
+	return false
+}
+
+func expectAlert(t *testing.T, watch <-chan Alert, source Source, severity Severity, matched string) {
+	t.Helper()
+	select {
+	case a := <-watch:
+		if a.Source != source || a.Severity != severity || a.Matched != matched {
+			t.Errorf("alert %+v, want %s %s '%s'", a, source, severity, matched)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no %s alert raised", source)
+	}
+}
+
+func TestConnectionAlerts(t *testing.T) {
+	nw, watch := startAlertWorker(t)
+	for _, c := range []struct {
+		from, to network.ConnectionState
+		severity Severity
+	}{
+		{network.StateConnected, network.StateAuthenticating, -1},
+		{network.StateAuthenticating, network.StateOnline, SeverityInfo},
+		{network.StateOnline, network.StateBackoff, SeverityWarning},
+		{network.StateBackoff, network.StateFatal, SeverityCritical},
+	} {
+		publish(t, nw, network.SubscriptionTypeState, network.StateTransition{From: c.from, To: c.to, Reason: "test", At: time.Now()})
+		if c.severity < 0 {
+			continue
+		}
+		expectAlert(t, watch, SourceConnection, c.severity, c.to.String())
+	}
+}
+
+func TestChannelAlerts(t *testing.T) {
+	nw, watch := startAlertWorker(t)
+	for _, c := range []struct {
+		from, to channelstatus.Condition
+		severity Severity
+	}{
+		{channelstatus.ConditionUnknown, channelstatus.ConditionOnline, -1},
+		{channelstatus.ConditionOnline, channelstatus.ConditionClosed, SeverityWarning},
+		{channelstatus.ConditionClosed, channelstatus.ConditionOnline, SeverityInfo},
+	} {
+		publish(t, nw, channelstatus.SubscriptionTypeChannelState, channelstatus.ChannelEvent{Channel: "test", From: c.from, To: c.to, At: time.Now(), Down: time.Minute})
+		if c.severity < 0 {
+			continue
+		}
+		expectAlert(t, watch, SourceChannel, c.severity, string(c.to))
+	}
+}
+
+func TestPrivateMessageAlerts(t *testing.T) {
+	viper.Set("private.alert_severity", "warning")
+	nw, watch := startAlertWorker(t)
+	publish(t, nw, private.SubscriptionTypePrivateMessage, private.Sent(private.KindText, "test", "alice", 1, "sent by us"))
+	publish(t, nw, private.SubscriptionTypePrivateMessage, private.Received(private.KindVoice, "test", "alice", "monitor", 2, ""))
+	expectAlert(t, watch, SourcePrivate, SeverityWarning, string(private.KindVoice))
+}
+
+func TestTranscriptAlerts(t *testing.T) {
+	nw, watch := startAlertWorker(t, map[string]interface{}{"name": "mayday", "severity": "critical", "keywords": []string{"mayday"}})
+	publish(t, nw, SubscriptionTypeTranscript, Transcript{StreamID: 7, Channel: "test", From: "alice", Text: "mayday mayday", Time: time.Now()})
+	expectAlert(t, watch, SourceTranscript, SeverityCritical, "mayday")
+}
+
+func TestDedupWindow(t *testing.T) {
+	w := NewAlertWorker(nil, 1, "Alert Worker")
+	w.window = time.Minute
+	w.rules = []*Rule{
+		mustRule(t, RuleConfig{Name: "mayday", Keywords: []string{"mayday", "injured"}}),
+		mustRule(t, RuleConfig{Name: "control", Dedup: "1h", Senders: []string{"bob"}}),
+	}
+	_, watch := w.Watch(SeverityInfo)
+	raised := func(in Input) []string {
+		w.evaluate(in)
+		var rules []string
+		for len(watch) > 0 {
+			a := <-watch
+			rules = append(rules, a.Rule+" "+a.Matched)
+		}
+		return rules
+	}
+	mayday := Input{Source: SourceText, From: "alice", Text: "mayday"}
+
+	for i, c := range []struct {
+		in   Input
+		want int
+	}{
+		{mayday, 1},
+		{mayday, 0}, // a repeat is suppressed
+		{Input{From: "ALICE", Text: "MAYDAY"}, 0},       // in any case
+		{Input{From: "alice", Text: "one injured"}, 1},  // other words match again
+		{Input{From: "carol", Text: "mayday"}, 1},       // as do other users
+		{Input{From: "bob", Text: "anything"}, 1},       // a rule with no keywords matches the sender
+		{Input{From: "bob", Text: "anything again"}, 0}, // so any message repeats it
+	} {
+		if got := raised(c.in); len(got) != c.want {
+			t.Errorf("%d: %+v raised %v", i, c.in, got)
+		}
+	}
+
+	// A rule's own window overrides the default
+	if until := w.suppress["control\x00bob\x00bob"]; until.Sub(time.Now()) < 59*time.Minute {
+		t.Errorf("control suppressed until %s", until)
+	}
+
+	// Once the window has passed the match is raised again
+	for k := range w.suppress {
+		w.suppress[k] = time.Now().Add(-time.Second)
+	}
+	if got := raised(mayday); len(got) != 1 {
+		t.Errorf("after the window raised %v", got)
+	}
+	if got := raised(mayday); len(got) != 0 {
+		t.Errorf("repeat after the window raised %v", got)
+	}
+
+	// With no window every match is raised
+	w.window = 0
+	carol := Input{From: "carol", Text: "injured"}
+	for i := 0; i < 2; i++ {
+		if got := raised(carol); len(got) != 1 {
+			t.Errorf("%d with no window raised %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package alerts
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// Severity of an alert
+type Severity int
+
+// Alert severities, lowest first
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the severity by name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParseSeverity converts a severity name. An empty name is info.
+func ParseSeverity(name string) (Severity, error) {
+	if name == "" {
+		return SeverityInfo, nil
+	}
+	for s, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return s, nil
+		}
+	}
+	return SeverityInfo, errors.Errorf("unknown alert severity '%s'", name)
+}
+
+// Source of the text a rule is matched against
+type Source string
+
+// Sources
+const (
+	SourceText       Source = "text"
+	SourceTranscript Source = "transcript"
+	SourceGeofence   Source = "geofence"
+	SourceConnection Source = "connection"
+	SourceChannel    Source = "channel"
+	SourcePrivate    Source = "private"
+)
+
+// Input is text to check against the rules
+type Input struct {
+	Source  Source
+	Channel string
+	From    string
+	For     string
+	ID      int // message or stream id
+	Text    string
+}
+
+// nonWord separates keywords. \W would treat every letter outside ASCII
+// as a separator.
+const nonWord = `[^\p{L}\p{N}_]`
+
+// RuleConfig is one entry of the 'alerts.rules' config list
+type RuleConfig struct {
+	Name     string   `mapstructure:"name"`
+	Severity string   `mapstructure:"severity"`
+	Keywords []string `mapstructure:"keywords"`
+	Patterns []string `mapstructure:"patterns"`
+	Senders  []string `mapstructure:"senders"`
+	Channels []string `mapstructure:"channels"`
+	Dedup    string   `mapstructure:"dedup"`
+}
+
+// Rule matches text from some senders and channels against
+// keywords and regular expressions. A rule with no keywords or patterns
+// matches every message its other conditions allow.
+type Rule struct {
+	Name     string
+	Severity Severity
+	Dedup    time.Duration // 0 uses the alerts.dedup_window
+	keywords []*regexp.Regexp
+	patterns []*regexp.Regexp
+	senders  []string
+	channels []string
+}
+
+// NewRules compiles the 'alerts.rules' config list
+func NewRules() ([]*Rule, error) {
+	var configs []RuleConfig
+	if err := viper.UnmarshalKey("alerts.rules", &configs); err != nil {
+		return nil, errors.Annotate(err, "alerts.rules")
+	}
+	var rules []*Rule
+	for i, c := range configs {
+		r, err := NewRule(c)
+		if err != nil {
+			return nil, errors.Annotatef(err, "alerts.rules[%d]", i)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// NewRule compiles one rule
+func NewRule(c RuleConfig) (*Rule, error) {
+	if c.Name == "" {
+		return nil, errors.New("rule has no name")
+	}
+	severity, err := ParseSeverity(c.Severity)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rule{
+		Name:     c.Name,
+		Severity: severity,
+		senders:  c.Senders,
+		channels: c.Channels,
+	}
+	if c.Dedup != "" {
+		if r.Dedup, err = time.ParseDuration(c.Dedup); err != nil {
+			return nil, errors.Annotate(err, "dedup")
+		}
+	}
+	for _, k := range c.Keywords {
+		if k = strings.TrimSpace(k); k != "" {
+			r.keywords = append(r.keywords, regexp.MustCompile(`(?i)(?:^|`+nonWord+`)(`+regexp.QuoteMeta(k)+`)(?:$|`+nonWord+`)`))
+		}
+	}
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Annotatef(err, "pattern '%s'", p)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Match returns the text that matched, or false if the rule does not
+// match
+func (r *Rule) Match(in Input) (string, bool) {
+	if len(r.senders) > 0 && !containsFold(r.senders, in.From) {
+		return "", false
+	}
+	if len(r.channels) > 0 && !containsFold(r.channels, in.Channel) {
+		return "", false
+	}
+	if len(r.keywords) == 0 && len(r.patterns) == 0 {
+		return in.From, true
+	}
+	for _, k := range r.keywords {
+		if m := k.FindStringSubmatchIndex(in.Text); m != nil {
+			return in.Text[m[2]:m[3]], true
+		}
+	}
+	for _, p := range r.patterns {
+		if m := p.FindString(in.Text); m != "" {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package alerts
+
+import (
+	"testing"
+	"time"
+)
+
+func mustRule(t *testing.T, c RuleConfig) *Rule {
+	t.Helper()
+	r, err := NewRule(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		rule    RuleConfig
+		in      Input
+		matched string
+		ok      bool
+	}{
+		{"keyword", RuleConfig{Keywords: []string{"mayday"}}, Input{Text: "Mayday, mayday"}, "Mayday", true},
+		{"keyword inside a word", RuleConfig{Keywords: []string{"mayday"}}, Input{Text: "maydays"}, "", false},
+		{"second keyword", RuleConfig{Keywords: []string{"mayday", "injured"}}, Input{Text: "one injured"}, "injured", true},
+		{"keyword with punctuation", RuleConfig{Keywords: []string{"c.q."}}, Input{Text: "cq c.q. cq"}, "c.q.", true},
+		{"pattern", RuleConfig{Patterns: []string{`\b[A-R]{2}[0-9]{2}\b`}}, Input{Text: "at IO91 now"}, "IO91", true},
+		{"pattern no match", RuleConfig{Patterns: []string{`\b[A-R]{2}[0-9]{2}\b`}}, Input{Text: "nothing"}, "", false},
+		{"sender", RuleConfig{Senders: []string{"NR1000"}}, Input{From: "nr1000", Text: "anything"}, "nr1000", true},
+		{"other sender", RuleConfig{Senders: []string{"NR1000"}}, Input{From: "alice", Text: "anything"}, "", false},
+		{"channel narrows keyword", RuleConfig{Keywords: []string{"mayday"}, Channels: []string{"Net"}}, Input{Channel: "Other", Text: "mayday"}, "", false},
+		{"channel", RuleConfig{Keywords: []string{"mayday"}, Channels: []string{"Net"}}, Input{Channel: "net", Text: "mayday"}, "mayday", true},
+	} {
+		c.rule.Name = c.name
+		matched, ok := mustRule(t, c.rule).Match(c.in)
+		if matched != c.matched || ok != c.ok {
+			t.Errorf("%s: matched '%s' %t, want '%s' %t", c.name, matched, ok, c.matched, c.ok)
+		}
+	}
+}
+
+func TestKeywordBoundaries(t *testing.T) {
+	for _, c := range []struct {
+		keyword string
+		text    string
+		ok      bool
+	}{
+		{"blessé", "un blessé grave", true},
+		{"blessé", "BLESSÉ", true},
+		{"blessé", "blessés", false},
+		{"secours", "auxsecours", false},
+		{"secours", "¡secours!", true},
+		{"помощь", "нужна помощь.", true},
+		{"помощь", "помощью", false},
+		{"mayday", "émayday", false},
+		{"mayday", "mayday_1", false},
+		{"mayday", "mayday1", false},
+		{"mayday", "(mayday)", true},
+	} {
+		_, ok := mustRule(t, RuleConfig{Name: "test", Keywords: []string{c.keyword}}).Match(Input{Text: c.text})
+		if ok != c.ok {
+			t.Errorf("'%s' in '%s' matched %t", c.keyword, c.text, ok)
+		}
+	}
+}
+
+func TestNewRuleErrors(t *testing.T) {
+	for _, c := range []RuleConfig{
+		{Keywords: []string{"mayday"}},
+		{Name: "test", Severity: "urgent"},
+		{Name: "test", Dedup: "soon"},
+		{Name: "test", Patterns: []string{"("}},
+	} {
+		if _, err := NewRule(c); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+	r := mustRule(t, RuleConfig{Name: "test", Severity: "Critical", Dedup: "1h", Keywords: []string{" ", "mayday"}})
+	if r.Severity != SeverityCritical || r.Dedup != time.Hour || len(r.keywords) != 1 {
+		t.Errorf("rule %+v", r)
+	}
+}
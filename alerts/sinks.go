@@ -0,0 +1,174 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	webhookQueueLength = 100
+)
+
+// Journal appends alerts to a file, one JSON object per line
+type Journal struct {
+	sync.Mutex
+	file *os.File
+}
+
+// OpenJournal opens, creating if needed, the journal file
+func OpenJournal(name string) (*Journal, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Annotate(err, "open alert journal")
+	}
+	return &Journal{file: f}, nil
+}
+
+// Write an alert to the journal
+func (j *Journal) Write(a Alert) error {
+	buff, err := json.Marshal(a)
+	if err != nil {
+		return errors.Annotate(err, "marshal alert")
+	}
+	j.Lock()
+	defer j.Unlock()
+	_, err = j.file.Write(append(buff, '\n'))
+	return errors.Annotate(err, "write alert journal")
+}
+
+// Close the journal file
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+	return j.file.Close()
+}
+
+// WebhookConfig is one entry of the 'alerts.webhooks' config list
+type WebhookConfig struct {
+	URL         string            `mapstructure:"url"`
+	MinSeverity string            `mapstructure:"min_severity"`
+	Headers     map[string]string `mapstructure:"headers"`
+}
+
+// Webhook posts alerts as JSON to a URL. Alerts are queued and sent by
+// their own goroutine so a slow endpoint does not hold up the worker.
+type Webhook struct {
+	url         string
+	minSeverity Severity
+	headers     map[string]string
+	client      *http.Client
+	queue       chan Alert
+	log         *log.Entry
+	done        chan struct{}
+}
+
+// NewWebhooks creates the webhooks in the 'alerts.webhooks' config list
+func NewWebhooks(entry *log.Entry) ([]*Webhook, error) {
+	var configs []WebhookConfig
+	if err := viper.UnmarshalKey("alerts.webhooks", &configs); err != nil {
+		return nil, errors.Annotate(err, "alerts.webhooks")
+	}
+	timeout := viper.GetDuration("alerts.webhook_timeout")
+	var webhooks []*Webhook
+	for i, c := range configs {
+		if c.URL == "" {
+			return nil, errors.Errorf("alerts.webhooks[%d] has no url", i)
+		}
+		severity, err := ParseSeverity(c.MinSeverity)
+		if err != nil {
+			return nil, errors.Annotatef(err, "alerts.webhooks[%d]", i)
+		}
+		webhooks = append(webhooks, &Webhook{
+			url:         c.URL,
+			minSeverity: severity,
+			headers:     c.Headers,
+			client:      &http.Client{Timeout: timeout},
+			queue:       make(chan Alert, webhookQueueLength),
+			log:         entry.WithField("Webhook", c.URL),
+			done:        make(chan struct{}),
+		})
+	}
+	return webhooks, nil
+}
+
+// Start sending queued alerts
+func (h *Webhook) Start() {
+	go func() {
+		defer close(h.done)
+		for a := range h.queue {
+			if err := h.post(a); err != nil {
+				h.log.Errorf("Alert %d not delivered: %s", a.ID, err)
+			}
+		}
+	}()
+}
+
+// Send queues an alert, dropping it if the queue is full
+func (h *Webhook) Send(a Alert) {
+	if a.Severity < h.minSeverity {
+		return
+	}
+	select {
+	case h.queue <- a:
+	default:
+		h.log.Warnf("Alert %d dropped, webhook queue is full", a.ID)
+	}
+}
+
+// Stop sends the alerts already queued and waits, up to the client
+// timeout for each, for them to be delivered
+func (h *Webhook) Stop() {
+	close(h.queue)
+	<-h.done
+}
+
+func (h *Webhook) post(a Alert) error {
+	buff, err := json.Marshal(a)
+	if err != nil {
+		return errors.Annotate(err, "marshal alert")
+	}
+	request, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(buff))
+	if err != nil {
+		return errors.Annotate(err, "webhook request")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range h.headers {
+		request.Header.Set(k, v)
+	}
+	response, err := h.client.Do(request)
+	if err != nil {
+		return errors.Annotate(err, "webhook")
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// logLevel alerts of each severity are logged at
+func logLevel(s Severity) log.Level {
+	switch s {
+	case SeverityCritical:
+		return log.ErrorLevel
+	case SeverityWarning:
+		return log.WarnLevel
+	}
+	return log.InfoLevel
+}
+
+// watcher is a gRPC client waiting for alerts
+type watcher struct {
+	minSeverity Severity
+	alerts      chan Alert
+}
@@ -5,11 +5,16 @@ package authenticate
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/errorcodes"
 	"github.com/jcmurray/monitor/network"
 	"github.com/jcmurray/monitor/protocolapp"
-	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/secrets"
 	"github.com/jcmurray/monitor/sequence"
 	"github.com/jcmurray/monitor/worker"
 	"github.com/juju/errors"
@@ -22,13 +27,25 @@ const ()
 // AuthWorker logon worker
 type AuthWorker struct {
 	sync.Mutex
-	command      chan int
-	log          *log.Entry
-	id           int
-	label        string
-	workers      *worker.Workers
-	loggedOn     bool
-	refreshToken string
+	command           chan int
+	log               *log.Entry
+	id                int
+	label             string
+	workers           *worker.Workers
+	loggedOn          bool
+	refreshToken      string
+	refreshIssuedAt   time.Time
+	usingRefreshToken bool
+	stateFile         string
+	tokenSource       TokenSource
+	policy            *network.ReconnectPolicy
+	logonAttempts     int
+	logonRetry        <-chan time.Time
+	channels          []string
+	listenOnly        bool
+	switches          chan *SwitchRequest
+	pendingSwitch     *SwitchRequest
+	previousLogon     *logonSettings
 }
 
 // NewAuthWorker create a new Logworker
@@ -40,6 +57,7 @@ func NewAuthWorker(workers *worker.Workers, id int, label string) *AuthWorker {
 		log:      log.WithFields(log.Fields{"Label": label, "ID": id}),
 		workers:  workers,
 		loggedOn: false,
+		switches: make(chan *SwitchRequest),
 	}
 }
 
@@ -49,10 +67,37 @@ func (w *AuthWorker) Run(wg *sync.WaitGroup, term *chan int) {
 	w.log.Debugf("Worker Started")
 
 	nw := w.findNetWorker()
+
+	tokenSource, err := NewTokenSource()
+	if err != nil {
+		w.log.Errorf("Auth token configuration error: %s - requesting application termination", err)
+		nw.SetFatal(fmt.Sprintf("auth token configuration: %s", err))
+		*term <- 1
+		return
+	}
+	w.tokenSource = tokenSource
+
+	policy, err := network.NewReconnectPolicy()
+	if err != nil {
+		w.log.Errorf("Reconnect configuration error: %s - requesting application termination", err)
+		nw.SetFatal(fmt.Sprintf("reconnect configuration: %s", err))
+		*term <- 1
+		return
+	}
+	w.policy = policy
+
+	w.stateFile = viper.GetString("logon.state_file")
+	w.loadRefreshToken()
+
 	connectionChannel := nw.Subscribe(w.id, network.SubscriptionTypeConection, w.label).Channel
 	responseChannel := nw.Subscribe(w.id, protocolapp.OnResponseEvent, w.label).Channel
 	errorChannel := nw.Subscribe(w.id, protocolapp.OnErrorEvent, w.label).Channel
 
+	// The connection may have been made before this worker subscribed
+	if nw.ConnectionState() == network.StateConnected {
+		w.logon()
+	}
+
 waitloop:
 	for {
 		w.log.Tracef("Entering Select")
@@ -63,12 +108,18 @@ waitloop:
 			switch cmd {
 			case network.Connected:
 				w.log.Debugf("Connected message received")
-				if !w.isLoggedOn() {
-					w.doLogon()
+				if !w.isLoggedOn() && nw.ConnectionState() != network.StateAuthenticating {
+					w.logon()
 				}
 			case network.Disconnected:
 				w.log.Debugf("Disconnected message received")
 				w.unsetLoggedOn()
+				w.logonRetry = nil
+				capabilities.Reset()
+				w.finishSwitch(ErrNotConnected, false)
+			case network.Reauthenticate:
+				w.log.Infof("Re-authentication requested, discarding refresh token")
+				w.discardRefreshToken()
 			}
 
 		case response := <-responseChannel:
@@ -79,35 +130,58 @@ waitloop:
 				w.log.Errorf("Unmarshal error: %s", err)
 				continue
 			}
-			if resp.Success && sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.LogonRequest) && resp.StreamID == 0 {
-				sequence.RemoveEntry(w.id, resp.Seq)
-				w.refreshToken = resp.RefreshToken
+			if !sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.LogonRequest) || resp.StreamID != 0 {
+				continue
+			}
+			sequence.RemoveEntry(w.id, resp.Seq)
+
+			err = errorcodes.FromResponse(resp, protocolapp.LogonRequest)
+			if err == nil {
+				if resp.RefreshToken != "" {
+					w.saveRefreshToken(resp.RefreshToken)
+				}
+				w.logonAttempts = 0
 				w.setLoggedOn()
+				capabilities.SetLogon(w.channels, w.listenOnly)
+				nw.SetOnline("logon succeeded")
+				w.finishSwitch(nil, false)
 				continue
 			}
-			if !resp.Success && sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.LogonRequest) && resp.Error == "invalid password" {
-				w.log.Error("Logon failure - invalid password - requesting application termination")
-				sequence.RemoveEntry(w.id, resp.Seq)
-				*term <- 1
-				break waitloop
+			if w.usingRefreshToken {
+				w.log.Warnf("Logon with refresh token failed (%s), logging on with full credentials", resp.Error)
+				w.discardRefreshToken()
+				w.logon()
+				continue
 			}
-
-			if !resp.Success && sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.LogonRequest) && resp.Error == "invalid username" {
-				w.log.Error("Logon failure - invalid username - requesting application termination")
-				sequence.RemoveEntry(w.id, resp.Seq)
-				*term <- 1
-				break waitloop
+			if w.finishSwitch(err, true) {
+				continue
 			}
-
-			if !resp.Success && sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.LogonRequest) && resp.Error == "not authorized" {
-				w.log.Error("Logon failure - invalid authorisation token - requesting application termination")
-				sequence.RemoveEntry(w.id, resp.Seq)
-				*term <- 1
+			if !w.logonFailed(err, nw, term) {
 				break waitloop
 			}
 
 		case errorMessage := <-errorChannel:
-			w.log.Debugf("Error: %s", errorcodes.Description(string(errorMessage.([]byte))))
+			e := protocolapp.NewOnError()
+			if err := json.Unmarshal(errorMessage.([]byte), e); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			err := errorcodes.FromOnError(e)
+			w.log.Debugf("Error: %s", errorcodes.Description(e.Error))
+			if w.isLoggedOn() && errorcodes.CategoryOf(err) == errorcodes.CategoryAuth {
+				if !w.sessionFailed(err, nw, term) {
+					break waitloop
+				}
+			}
+
+		case r := <-w.switches:
+			w.startSwitch(r, nw)
+
+		case <-w.logonRetry:
+			w.logonRetry = nil
+			if !w.isLoggedOn() && nw.ConnectionState().IsConnected() {
+				w.logon()
+			}
 
 		case logonCommand, more := <-w.command:
 			if more {
@@ -115,10 +189,16 @@ waitloop:
 				switch logonCommand {
 				case worker.Logon:
 					if !w.isLoggedOn() {
-						w.doLogon()
+						w.logon()
 					}
 				case worker.Logoff:
 					w.unsetLoggedOn()
+				case worker.Relogon:
+					w.unsetLoggedOn()
+					w.logonRetry = nil
+					if nw.ConnectionState().IsConnected() {
+						w.logon()
+					}
 				case worker.Terminate:
 					w.log.Debugf("Terminating")
 					w.unsetLoggedOn()
@@ -178,34 +258,164 @@ func (w *AuthWorker) unsetLoggedOn() {
 	w.loggedOn = false
 }
 
+// logonFailed reacts to a rejected logon request. It returns false when
+// the worker should stop.
+func (w *AuthWorker) logonFailed(err error, nw *network.Networker, term *chan int) bool {
+	e, _ := errorcodes.As(err)
+	if e.Action == errorcodes.ActionTerminate {
+		w.log.Errorf("Logon failure - %s (%s) - requesting application termination", e.Code, e.Description)
+		nw.SetFatal("logon failure: " + e.Code)
+		*term <- 1
+		return false
+	}
+	w.log.Warnf("Logon failure - %s (%s, %s)", e.Code, e.Category, e.Description)
+	w.scheduleLogon()
+	return true
+}
+
+// sessionFailed reacts to an auth error reported while logged on. It
+// returns false when the worker should stop.
+func (w *AuthWorker) sessionFailed(err error, nw *network.Networker, term *chan int) bool {
+	e, _ := errorcodes.As(err)
+	switch e.Action {
+	case errorcodes.ActionTerminate:
+		w.log.Errorf("Session failure - %s - requesting application termination", e.Code)
+		nw.SetFatal("session failure: " + e.Code)
+		*term <- 1
+		return false
+	case errorcodes.ActionRelogon:
+		w.log.Warnf("Session no longer authorised (%s), logging on with full credentials", e.Code)
+		w.discardRefreshToken()
+		w.unsetLoggedOn()
+		nw.SetAuthenticating("session invalidated: " + e.Code)
+		w.logon()
+	}
+	return true
+}
+
+// logon sends a logon request, trying again after a backoff if it could
+// not be sent
+func (w *AuthWorker) logon() {
+	if err := w.doLogon(); err != nil {
+		w.log.Errorf("Logon not sent: %s", err)
+		w.scheduleLogon()
+	}
+}
+
+// scheduleLogon tries the logon again after the reconnect policy's
+// interval for the number of attempts made so far
+func (w *AuthWorker) scheduleLogon() {
+	delay := w.policy.Interval(w.logonAttempts)
+	w.logonAttempts++
+	w.log.Infof("Logging on again in %s (attempt %d)", delay, w.logonAttempts)
+	w.logonRetry = time.After(delay)
+}
+
 func (w *AuthWorker) doLogon() error {
 	logon := protocolapp.NewLogon()
-	logon.Channel = viper.GetString("logon.channel")
+	channels := logonChannels()
+	switch len(channels) {
+	case 0:
+	case 1:
+		logon.Channel = channels[0]
+	default:
+		logon.Channels = channels
+	}
 
 	if w.refreshToken != "" {
 		logon.RefreshToken = w.refreshToken
 	} else {
-		logon.AuthToken = viper.GetString("logon.auth_token")
+		token, err := w.tokenSource.Token()
+		if err != nil {
+			return errors.Annotate(err, "No auth token for Zello logon request")
+		}
+		if its, ok := w.tokenSource.(*IssuerTokenSource); ok {
+			w.log.Debugf("Using self-issued auth token expiring %s", its.Expires().Format(time.RFC3339))
+		}
+		logon.AuthToken = token
 	}
 
 	logon.Username = viper.GetString("logon.username")
 	logon.Password = viper.GetString("logon.password")
 	logon.ListenOnly = viper.GetBool("logon.listen_only")
 	logon.Seq = sequence.GetNextSequenceNumber(w.id, logon.Command)
+	w.usingRefreshToken = logon.RefreshToken != ""
+	w.Lock()
+	w.channels = channels
+	w.listenOnly = logon.ListenOnly
+	w.Unlock()
 
 	buff, err := json.Marshal(logon)
 	if err != nil {
-		w.log.Errorf("Marshal error: %s", err)
 		return errors.Annotate(err, "Marshal failure for Zello logon request")
 	}
 
-	w.log.Tracef("Sending: %s", buff)
+	w.log.Tracef("Sending: %s", secrets.RedactBytes(buff))
 
 	nw := w.findNetWorker()
+	if nw.ConnectionState().IsConnected() {
+		nw.SetAuthenticating(fmt.Sprintf("logon to channel '%s'", strings.Join(channels, "', '")))
+	}
 	nw.Data([]byte(string(buff)))
 	return nil
 }
 
+// loadRefreshToken restores a refresh token saved by an earlier run for the configured user
+func (w *AuthWorker) loadRefreshToken() {
+	if w.stateFile == "" {
+		return
+	}
+	state, err := loadState(w.stateFile)
+	if err != nil {
+		w.log.Warnf("Ignoring saved state: %s", err)
+		return
+	}
+	if state == nil || state.RefreshToken == "" {
+		return
+	}
+	if state.Username != viper.GetString("logon.username") {
+		w.log.Infof("Ignoring saved refresh token for user '%s'", state.Username)
+		return
+	}
+	w.refreshToken = state.RefreshToken
+	w.refreshIssuedAt = state.IssuedAt
+	secrets.Register(state.RefreshToken)
+	w.log.Infof("Using refresh token issued %s", state.IssuedAt.Format(time.RFC3339))
+}
+
+func (w *AuthWorker) saveRefreshToken(token string) {
+	if token == w.refreshToken {
+		return
+	}
+	w.refreshToken = token
+	w.refreshIssuedAt = time.Now().UTC()
+	secrets.Register(token)
+	if w.stateFile == "" {
+		return
+	}
+	err := saveState(w.stateFile, &savedState{
+		Username:     viper.GetString("logon.username"),
+		RefreshToken: w.refreshToken,
+		IssuedAt:     w.refreshIssuedAt,
+	})
+	if err != nil {
+		w.log.Errorf("Unable to save refresh token: %s", err)
+		return
+	}
+	w.log.Debugf("Refresh token saved to %s", w.stateFile)
+}
+
+func (w *AuthWorker) discardRefreshToken() {
+	w.refreshToken = ""
+	w.refreshIssuedAt = time.Time{}
+	if w.stateFile == "" {
+		return
+	}
+	if err := removeState(w.stateFile); err != nil {
+		w.log.Errorf("Unable to remove saved refresh token: %s", err)
+	}
+}
+
 // Label return label of worker
 func (w *AuthWorker) Label() string {
 	return w.label
@@ -0,0 +1,89 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package authenticate_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/zellotest"
+	"github.com/spf13/viper"
+)
+
+const (
+	testChannel   = "Test Channel"
+	testAuthToken = "zellotest-auth-token"
+)
+
+func TestLogonReconnectAndRefreshToken(t *testing.T) {
+	s := zellotest.NewServer()
+	defer s.Close()
+	s.Configure(testChannel, "monitor", "secret", testAuthToken)
+	s.SetCredentials("monitor", "secret", testAuthToken)
+	s.SetRefreshToken("refresh-1")
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	viper.Set("logon.state_file", stateFile)
+	viper.Set("logon.listen_only", false)
+
+	c := s.NewClient()
+	c.Start(t)
+	nw := c.Network
+
+	// The first logon uses the auth token, and the refresh token returned
+	// is kept for later logons
+	logon := s.ExpectLogon(t, testChannel)
+	if logon.AuthToken != testAuthToken || logon.RefreshToken != "" {
+		t.Fatalf("first logon with auth token '%s' and refresh token '%s'", logon.AuthToken, logon.RefreshToken)
+	}
+	c.WaitForState(t, network.StateOnline)
+
+	// A going away close is retried after a backoff, logging on with the
+	// refresh token
+	mark := len(nw.StateSnapshot().Transitions)
+	if err := s.CloseConnection(1001, "going away"); err != nil {
+		t.Fatal(err)
+	}
+	logon = s.ExpectLogon(t, testChannel)
+	if logon.RefreshToken != "refresh-1" || logon.AuthToken != "" {
+		t.Fatalf("logon after reconnecting with refresh token '%s' and auth token '%s'", logon.RefreshToken, logon.AuthToken)
+	}
+	c.WaitForState(t, network.StateOnline)
+	if !c.PassedThrough(mark, network.StateBackoff) {
+		t.Errorf("reconnected without backing off: %+v", nw.StateSnapshot().Transitions[mark:])
+	}
+
+	// A rejected refresh token falls back to the auth token
+	s.SetRefreshToken("refresh-2")
+	mark = len(nw.StateSnapshot().Transitions)
+	if err := s.CloseConnection(3001, "channel closed"); err != nil {
+		t.Fatal(err)
+	}
+	logon = s.ExpectLogon(t, testChannel)
+	if logon.RefreshToken != "refresh-1" {
+		t.Fatalf("logon after channel closed with refresh token '%s'", logon.RefreshToken)
+	}
+	logon = s.ExpectLogon(t, testChannel)
+	if logon.AuthToken != testAuthToken || logon.RefreshToken != "" {
+		t.Fatalf("fallback logon with auth token '%s' and refresh token '%s'", logon.AuthToken, logon.RefreshToken)
+	}
+	c.WaitForState(t, network.StateOnline)
+	if !c.PassedThrough(mark, network.StateBackoff) {
+		t.Errorf("reconnected without backing off: %+v", nw.StateSnapshot().Transitions[mark:])
+	}
+
+	// The new refresh token is used on the next logon
+	if err := s.CloseConnection(1001, "going away"); err != nil {
+		t.Fatal(err)
+	}
+	logon = s.ExpectLogon(t, testChannel)
+	if logon.RefreshToken != "refresh-2" {
+		t.Fatalf("logon with refresh token '%s', want the one issued by the fallback logon", logon.RefreshToken)
+	}
+	c.WaitForState(t, network.StateOnline)
+
+	if c.Terminated() {
+		t.Fatal("termination requested")
+	}
+}
@@ -0,0 +1,38 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package authenticate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/juju/errors"
+)
+
+// failingTokenSource cannot sign a token, as when the issuer key is
+// unreadable
+type failingTokenSource struct{}
+
+func (failingTokenSource) Token() (string, error) {
+	return "", errors.New("key unreadable")
+}
+
+func TestLogonNotSentIsRetried(t *testing.T) {
+	var workers worker.Workers
+	w := NewAuthWorker(&workers, 2, "Auth Worker")
+	w.tokenSource = failingTokenSource{}
+	w.policy = &network.ReconnectPolicy{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond, Multiplier: 1}
+
+	w.logon()
+	if w.logonRetry == nil || w.logonAttempts != 1 {
+		t.Fatalf("logon not retried, %d attempts", w.logonAttempts)
+	}
+	select {
+	case <-w.logonRetry:
+	case <-time.After(time.Second):
+		t.Fatal("retry not scheduled")
+	}
+}
@@ -0,0 +1,86 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package authenticate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	stateFileMode = 0600
+)
+
+// savedState is kept between runs so that a restart can log on with the
+// refresh token rather than the full credentials
+type savedState struct {
+	Username     string    `json:"username"`
+	RefreshToken string    `json:"refresh_token"`
+	IssuedAt     time.Time `json:"issued_at"`
+}
+
+// loadState reads the state file. A missing file is not an error. A file
+// readable by other users is tightened to owner only.
+func loadState(name string) (*savedState, error) {
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "state file")
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(name, stateFileMode); err != nil {
+			return nil, errors.Annotatef(err, "state file '%s' is readable by other users", name)
+		}
+	}
+
+	buff, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "state file")
+	}
+	state := &savedState{}
+	if err := json.Unmarshal(buff, state); err != nil {
+		return nil, errors.Annotatef(err, "state file '%s'", name)
+	}
+	return state, nil
+}
+
+// saveState writes the state file atomically with owner-only permissions
+func saveState(name string, state *savedState) error {
+	buff, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return errors.Annotate(err, "marshal state")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return errors.Annotate(err, "state file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(stateFileMode); err != nil {
+		tmp.Close()
+		return errors.Annotate(err, "state file")
+	}
+	if _, err := tmp.Write(buff); err != nil {
+		tmp.Close()
+		return errors.Annotate(err, "state file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Annotate(err, "state file")
+	}
+	return errors.Annotate(os.Rename(tmp.Name(), name), "state file")
+}
+
+// removeState deletes the state file, ignoring a missing file
+func removeState(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return errors.Annotate(err, "state file")
+	}
+	return nil
+}
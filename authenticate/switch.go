@@ -0,0 +1,182 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package authenticate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/sequence"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// Switch failures
+var (
+	ErrNotConnected  = errors.New("not connected to Zello")
+	ErrSwitchPending = errors.New("a channel switch is already in progress")
+	ErrNoChannel     = errors.New("at least one channel is needed")
+	ErrSwitchTimeout = errors.New("timed out waiting for the logon response")
+)
+
+// SwitchRequest changes the channels and listen-only mode of the live
+// connection. A nil ListenOnly keeps the current mode.
+type SwitchRequest struct {
+	Channels   []string
+	ListenOnly *bool
+	reply      chan error
+}
+
+// logonSettings are the config values a switch changes
+type logonSettings struct {
+	channels   []string
+	listenOnly bool
+}
+
+// SwitchChannel logs on again with new channels or listen-only mode and
+// waits for the result. If the logon fails the previous settings are
+// restored.
+func (w *AuthWorker) SwitchChannel(channels []string, listenOnly *bool, timeout time.Duration) error {
+	var cleaned []string
+	for _, c := range channels {
+		if c = strings.TrimSpace(c); c != "" {
+			cleaned = append(cleaned, c)
+		}
+	}
+	if len(cleaned) == 0 && listenOnly == nil {
+		return ErrNoChannel
+	}
+	r := &SwitchRequest{Channels: cleaned, ListenOnly: listenOnly, reply: make(chan error, 1)}
+	select {
+	case w.switches <- r:
+	case <-time.After(timeout):
+		return ErrSwitchTimeout
+	}
+	select {
+	case err := <-r.reply:
+		return err
+	case <-time.After(timeout):
+		return ErrSwitchTimeout
+	}
+}
+
+// Channels returns the channels and listen-only mode of the current logon
+func (w *AuthWorker) Channels() ([]string, bool) {
+	w.Lock()
+	defer w.Unlock()
+	return append([]string(nil), w.channels...), w.listenOnly
+}
+
+// startSwitch handles a SwitchRequest in the worker's goroutine
+func (w *AuthWorker) startSwitch(r *SwitchRequest, nw *network.Networker) {
+	if w.pendingSwitch != nil {
+		r.reply <- ErrSwitchPending
+		return
+	}
+	if !nw.ConnectionState().IsConnected() {
+		r.reply <- ErrNotConnected
+		return
+	}
+
+	previous := currentLogonSettings()
+	next := previous
+	if len(r.Channels) > 0 {
+		next.channels = r.Channels
+	}
+	if r.ListenOnly != nil {
+		next.listenOnly = *r.ListenOnly
+	}
+	if len(next.channels) == 0 {
+		r.reply <- ErrNoChannel
+		return
+	}
+	w.log.Infof("Switching to channels '%s', listen only %t", strings.Join(next.channels, "', '"), next.listenOnly)
+
+	w.finaliseWorkers(fmt.Sprintf("switching to channel '%s'", next.channels[0]))
+	sequence.Reset()
+	capabilities.Reset()
+
+	w.pendingSwitch = r
+	w.previousLogon = &previous
+	next.apply()
+	w.unsetLoggedOn()
+	w.logonRetry = nil
+	w.logonAttempts = 0
+	if err := w.doLogon(); err != nil {
+		w.finishSwitch(err, true)
+	}
+}
+
+// finishSwitch reports the result of the logon made for a switch. On
+// failure the previous settings are restored and, if relogon is set, used
+// to log on again. It returns true if a switch was pending.
+func (w *AuthWorker) finishSwitch(err error, relogon bool) bool {
+	if w.pendingSwitch == nil {
+		return false
+	}
+	r := w.pendingSwitch
+	previous := w.previousLogon
+	w.pendingSwitch = nil
+	w.previousLogon = nil
+
+	if err == nil {
+		w.log.Infof("Channel switch complete")
+		r.reply <- nil
+		return true
+	}
+	w.log.Warnf("Channel switch failed (%s), returning to channels '%s'", err, strings.Join(previous.channels, "', '"))
+	r.reply <- errors.Annotate(err, "logon failed")
+	if len(previous.channels) > 0 {
+		previous.apply()
+	}
+	if relogon {
+		w.logon()
+	}
+	return true
+}
+
+// finaliseWorkers asks every worker with per-channel state to complete or
+// discard it
+func (w *AuthWorker) finaliseWorkers(reason string) {
+	for i := range *w.workers {
+		f, ok := (*w.workers)[i].(worker.Finaliser)
+		if !ok {
+			continue
+		}
+		if !f.Finalise(reason) {
+			w.log.Warnf("%s did not finalise within %s", f.Label(), worker.FinaliseTimeout)
+		}
+	}
+}
+
+// logonChannels returns the configured channels, logon.channels taking
+// precedence over logon.channel
+func logonChannels() []string {
+	if channels := viper.GetStringSlice("logon.channels"); len(channels) > 0 {
+		return channels
+	}
+	if channel := viper.GetString("logon.channel"); channel != "" {
+		return []string{channel}
+	}
+	return nil
+}
+
+func currentLogonSettings() logonSettings {
+	return logonSettings{
+		channels:   logonChannels(),
+		listenOnly: viper.GetBool("logon.listen_only"),
+	}
+}
+
+// apply the settings for the next logon. They are not written back to the
+// config file.
+func (s logonSettings) apply() {
+	viper.Set("logon.channels", s.channels)
+	viper.Set("logon.channel", s.channels[0])
+	viper.Set("logon.listen_only", s.listenOnly)
+}
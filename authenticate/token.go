@@ -0,0 +1,182 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package authenticate
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	minimumTokenLifetime = time.Minute
+)
+
+// TokenSource supplies the auth_token sent with a logon
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// NewTokenSource create a TokenSource from the 'logon' config section. When
+// logon.issuer and logon.issuer_key_file are set tokens are self-issued and
+// logon.auth_token, if set, is only used when signing fails.
+func NewTokenSource() (TokenSource, error) {
+	static := viper.GetString("logon.auth_token")
+	issuer := viper.GetString("logon.issuer")
+	keyFile := viper.GetString("logon.issuer_key_file")
+
+	if issuer == "" && keyFile == "" {
+		if static == "" {
+			return nil, errors.New("one of logon.auth_token or logon.issuer and logon.issuer_key_file must be set")
+		}
+		return staticTokenSource(static), nil
+	}
+	if issuer == "" || keyFile == "" {
+		return nil, errors.New("logon.issuer and logon.issuer_key_file must be set together")
+	}
+
+	key, err := loadRSAPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	source := NewIssuerTokenSource(issuer, key,
+		viper.GetDuration("logon.token_lifetime"), viper.GetDuration("logon.token_refresh_before"))
+	source.fallback = static
+	return source, nil
+}
+
+// staticTokenSource always returns the configured token
+type staticTokenSource string
+
+func (s staticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+// IssuerTokenSource mints RS256 developer tokens signed with the issuer's
+// private key. A token is reused until it is within refreshBefore of expiry.
+type IssuerTokenSource struct {
+	sync.Mutex
+	issuer        string
+	key           *rsa.PrivateKey
+	lifetime      time.Duration
+	refreshBefore time.Duration
+	fallback      string
+	now           func() time.Time
+	token         string
+	expires       time.Time
+}
+
+// NewIssuerTokenSource create a new IssuerTokenSource
+func NewIssuerTokenSource(issuer string, key *rsa.PrivateKey, lifetime time.Duration, refreshBefore time.Duration) *IssuerTokenSource {
+	if lifetime < minimumTokenLifetime {
+		lifetime = minimumTokenLifetime
+	}
+	if refreshBefore < 0 || refreshBefore >= lifetime {
+		refreshBefore = lifetime / 4
+	}
+	return &IssuerTokenSource{
+		issuer:        issuer,
+		key:           key,
+		lifetime:      lifetime,
+		refreshBefore: refreshBefore,
+		now:           time.Now,
+	}
+}
+
+// Token returns a current token, minting a new one when needed
+func (s *IssuerTokenSource) Token() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	if s.token != "" && now.Before(s.expires.Add(-s.refreshBefore)) {
+		return s.token, nil
+	}
+
+	expires := now.Add(s.lifetime)
+	token, err := signJWT(s.key, map[string]interface{}{
+		"iss": s.issuer,
+		"exp": expires.Unix(),
+	})
+	if err != nil {
+		if s.fallback != "" {
+			return s.fallback, nil
+		}
+		return "", err
+	}
+	s.token = token
+	s.expires = expires
+	return token, nil
+}
+
+// Expires returns the expiry time of the current token
+func (s *IssuerTokenSource) Expires() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.expires
+}
+
+// signJWT builds a compact RS256 JSON Web Token
+func signJWT(key *rsa.PrivateKey, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", errors.Annotate(err, "marshal JWT header")
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Annotate(err, "marshal JWT claims")
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Annotate(err, "sign JWT")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// loadRSAPrivateKey reads a PKCS #1 or PKCS #8 PEM encoded RSA private key
+func loadRSAPrivateKey(name string) (*rsa.PrivateKey, error) {
+	buff, err := os.ReadFile(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "logon.issuer_key_file")
+	}
+	block, _ := pem.Decode(buff)
+	if block == nil {
+		return nil, errors.Errorf("logon.issuer_key_file '%s' is not PEM encoded", name)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Annotate(err, "logon.issuer_key_file")
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Annotate(err, "logon.issuer_key_file")
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.Errorf("logon.issuer_key_file '%s' is not an RSA key", name)
+		}
+		return rsaKey, nil
+	}
+	return nil, errors.Errorf("logon.issuer_key_file '%s' has unsupported PEM type '%s'", name, block.Type)
+}
@@ -0,0 +1,273 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package authenticate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	fixtureKeyOnce sync.Once
+	fixtureKey     *rsa.PrivateKey
+)
+
+// testKey returns an RSA key generated once for all the tests
+func testKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	fixtureKeyOnce.Do(func() {
+		var err error
+		if fixtureKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return fixtureKey
+}
+
+// writePEM writes a PEM block to a file in a temporary directory
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "issuer.pem")
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// verifyJWT checks the RS256 signature of token with the public key and
+// returns its claims
+func verifyJWT(t *testing.T, token string, key *rsa.PublicKey) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token '%s' has %d parts", token, len(parts))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("signature: %s", err)
+	}
+
+	var header map[string]string
+	decode := func(part string, v interface{}) {
+		buff, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(buff, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decode(parts[0], &header)
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		t.Errorf("header %v", header)
+	}
+	claims := make(map[string]interface{})
+	decode(parts[1], &claims)
+	return claims
+}
+
+func TestIssuerTokenSigned(t *testing.T) {
+	key := testKey(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewIssuerTokenSource("monitor-dev", key, time.Hour, 10*time.Minute)
+	s.now = func() time.Time { return now }
+
+	token, err := s.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := verifyJWT(t, token, &key.PublicKey)
+	if claims["iss"] != "monitor-dev" || claims["exp"] != float64(now.Add(time.Hour).Unix()) || len(claims) != 2 {
+		t.Errorf("claims %v", claims)
+	}
+	if !s.Expires().Equal(now.Add(time.Hour)) {
+		t.Errorf("expires %s", s.Expires())
+	}
+
+	// A token signed by another key does not verify
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(&other.PublicKey, crypto.SHA256, digest[:], signature) == nil {
+		t.Error("token verified with the wrong key")
+	}
+}
+
+func TestIssuerTokenReusedUntilRefresh(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewIssuerTokenSource("monitor-dev", testKey(t), time.Hour, 10*time.Minute)
+	s.now = func() time.Time { return now }
+
+	first, _ := s.Token()
+	now = now.Add(49 * time.Minute)
+	if token, _ := s.Token(); token != first {
+		t.Error("token replaced before the refresh time")
+	}
+	now = now.Add(time.Minute)
+	second, _ := s.Token()
+	if second == first {
+		t.Error("token not replaced at the refresh time")
+	}
+	claims := verifyJWT(t, second, &testKey(t).PublicKey)
+	if claims["exp"] != float64(now.Add(time.Hour).Unix()) {
+		t.Errorf("refreshed claims %v", claims)
+	}
+}
+
+func TestIssuerTokenLimits(t *testing.T) {
+	for _, c := range []struct {
+		lifetime, refreshBefore   time.Duration
+		wantLifetime, wantRefresh time.Duration
+	}{
+		{time.Second, 0, minimumTokenLifetime, 0},
+		{time.Hour, -time.Minute, time.Hour, 15 * time.Minute},
+		{time.Hour, 2 * time.Hour, time.Hour, 15 * time.Minute},
+		{time.Hour, 5 * time.Minute, time.Hour, 5 * time.Minute},
+	} {
+		s := NewIssuerTokenSource("monitor-dev", testKey(t), c.lifetime, c.refreshBefore)
+		if s.lifetime != c.wantLifetime || s.refreshBefore != c.wantRefresh {
+			t.Errorf("lifetime %s refresh %s became %s and %s", c.lifetime, c.refreshBefore, s.lifetime, s.refreshBefore)
+		}
+	}
+}
+
+func TestIssuerTokenFallback(t *testing.T) {
+	// Far too small to sign a SHA-256 digest
+	broken := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: big.NewInt(3233), E: 17}, D: big.NewInt(2753)}
+	s := NewIssuerTokenSource("monitor-dev", broken, time.Hour, 0)
+	if _, err := s.Token(); err == nil {
+		t.Fatal("signed with a broken key")
+	}
+	s.fallback = "static"
+	if token, err := s.Token(); err != nil || token != "static" {
+		t.Errorf("fallback %q, %v", token, err)
+	}
+}
+
+func TestLoadRSAPrivateKey(t *testing.T) {
+	key := testKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for blockType, der := range map[string][]byte{
+		"RSA PRIVATE KEY": x509.MarshalPKCS1PrivateKey(key),
+		"PRIVATE KEY":     pkcs8,
+	} {
+		loaded, err := loadRSAPrivateKey(writePEM(t, blockType, der))
+		if err != nil {
+			t.Errorf("%s: %s", blockType, err)
+			continue
+		}
+		if !loaded.Equal(key) {
+			t.Errorf("%s: loaded a different key", blockType)
+		}
+	}
+}
+
+func TestLoadRSAPrivateKeyErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(t.TempDir(), "issuer.key")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		file string
+		want string
+	}{
+		{"missing", filepath.Join(t.TempDir(), "missing.pem"), "no such file"},
+		{"not PEM", notPEM, "not PEM encoded"},
+		{"malformed PKCS #1", writePEM(t, "RSA PRIVATE KEY", []byte("garbage")), "logon.issuer_key_file"},
+		{"malformed PKCS #8", writePEM(t, "PRIVATE KEY", []byte("garbage")), "logon.issuer_key_file"},
+		{"not RSA", writePEM(t, "PRIVATE KEY", ecDER), "not an RSA key"},
+		{"unsupported type", writePEM(t, "EC PRIVATE KEY", []byte("garbage")), "unsupported PEM type"},
+	} {
+		if _, err := loadRSAPrivateKey(c.file); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: %v, want an error containing '%s'", c.name, err, c.want)
+		}
+	}
+}
+
+func TestNewTokenSource(t *testing.T) {
+	keyFile := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testKey(t)))
+	set := func(token, issuer, file string) {
+		viper.Set("logon.auth_token", token)
+		viper.Set("logon.issuer", issuer)
+		viper.Set("logon.issuer_key_file", file)
+	}
+	t.Cleanup(func() { set("", "", "") })
+
+	set("", "", "")
+	if _, err := NewTokenSource(); err == nil {
+		t.Error("no token source configured accepted")
+	}
+	set("", "monitor-dev", "")
+	if _, err := NewTokenSource(); err == nil {
+		t.Error("issuer without a key file accepted")
+	}
+	set("", "monitor-dev", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := NewTokenSource(); err == nil {
+		t.Error("missing key file accepted")
+	}
+
+	set("static", "", "")
+	s, err := NewTokenSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := s.Token(); token != "static" {
+		t.Errorf("static token %q", token)
+	}
+
+	set("static", "monitor-dev", keyFile)
+	s, err = NewTokenSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, ok := s.(*IssuerTokenSource)
+	if !ok || issuer.fallback != "static" {
+		t.Fatalf("token source %#v", s)
+	}
+	token, err := s.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims := verifyJWT(t, token, &testKey(t).PublicKey); claims["iss"] != "monitor-dev" {
+		t.Errorf("claims %v", claims)
+	}
+}
@@ -0,0 +1,188 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package capabilities records what the current channel and connection
+// allow, so outbound requests can be refused before they reach Zello.
+package capabilities
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmurray/monitor/protocolapp"
+)
+
+// Capabilities of the channel as last reported and of the connection
+type Capabilities struct {
+	Channel            string    `json:"channel"`
+	Known              bool      `json:"known"`
+	Online             bool      `json:"online"`
+	ImagesSupported    bool      `json:"images_supported"`
+	TextingSupported   bool      `json:"texting_supported"`
+	LocationsSupported bool      `json:"locations_supported"`
+	ListenOnly         bool      `json:"listen_only"`
+	Updated            time.Time `json:"updated"`
+}
+
+// Kind of capability failure
+type Kind int
+
+// Capability failures
+const (
+	KindUnknown      Kind = iota // no channel status received yet
+	KindOffline                  // channel is offline
+	KindListenOnly               // connection is listen-only
+	KindNotSupported             // channel does not support the feature
+)
+
+// Error is returned when a request cannot be sent
+type Error struct {
+	Kind    Kind
+	Feature string
+	Channel string
+}
+
+func (e *Error) Error() string {
+	switch e.Kind {
+	case KindUnknown:
+		return fmt.Sprintf("status of channel %s is not yet known", e.Channel)
+	case KindOffline:
+		return fmt.Sprintf("channel %s is offline", e.Channel)
+	case KindListenOnly:
+		return "connection is listen-only"
+	}
+	return fmt.Sprintf("%s not supported on channel %s", e.Feature, e.Channel)
+}
+
+// Features that can be checked
+const (
+	FeatureTexting   = "texting"
+	FeatureImages    = "images"
+	FeatureLocations = "locations"
+	FeatureVoice     = "voice"
+)
+
+var (
+	lock       sync.Mutex
+	channels   = map[string]*Capabilities{}
+	primary    string
+	listenOnly bool
+)
+
+// Update from a channel status message
+func Update(c *protocolapp.OnChannelStatus) {
+	lock.Lock()
+	defer lock.Unlock()
+	if primary == "" {
+		primary = c.Channel
+	}
+	channels[c.Channel] = &Capabilities{
+		Channel:            c.Channel,
+		Known:              true,
+		Online:             strings.EqualFold(c.Status, "online"),
+		ImagesSupported:    c.ImagesSupported,
+		TextingSupported:   c.TextingSupported,
+		LocationsSupported: c.LocationsSupported,
+		ListenOnly:         listenOnly,
+		Updated:            time.Now().UTC(),
+	}
+}
+
+// SetLogon records the channels and listen_only mode of the current
+// logon. The first channel is the one outbound requests are checked
+// against. Status of channels no longer logged on to is forgotten.
+func SetLogon(logonChannels []string, logonListenOnly bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	primary = ""
+	if len(logonChannels) > 0 {
+		primary = logonChannels[0]
+	}
+	listenOnly = logonListenOnly
+	kept := make(map[string]*Capabilities)
+	for _, name := range logonChannels {
+		if c, ok := channels[name]; ok {
+			c.ListenOnly = listenOnly
+			kept[name] = c
+		}
+	}
+	channels = kept
+}
+
+// Reset forgets the channel status, for example when the connection is
+// lost or the channel is changing. Listen-only mode is kept until the
+// next logon.
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	channels = make(map[string]*Capabilities)
+}
+
+// Get the capabilities of the channel outbound requests are sent to
+func Get() Capabilities {
+	lock.Lock()
+	defer lock.Unlock()
+	return get(primary)
+}
+
+// GetChannel returns the capabilities of a channel
+func GetChannel(name string) Capabilities {
+	lock.Lock()
+	defer lock.Unlock()
+	return get(name)
+}
+
+func get(name string) Capabilities {
+	if c, ok := channels[name]; ok {
+		return *c
+	}
+	return Capabilities{Channel: name, ListenOnly: listenOnly}
+}
+
+// Check returns an *Error if feature cannot be used now
+func Check(feature string) error {
+	c := Get()
+	switch {
+	case c.ListenOnly:
+		return &Error{Kind: KindListenOnly, Feature: feature, Channel: c.Channel}
+	case !c.Known:
+		return &Error{Kind: KindUnknown, Feature: feature, Channel: c.Channel}
+	case !c.Online:
+		return &Error{Kind: KindOffline, Feature: feature, Channel: c.Channel}
+	}
+	supported := true
+	switch feature {
+	case FeatureTexting:
+		supported = c.TextingSupported
+	case FeatureImages:
+		supported = c.ImagesSupported
+	case FeatureLocations:
+		supported = c.LocationsSupported
+	}
+	if !supported {
+		return &Error{Kind: KindNotSupported, Feature: feature, Channel: c.Channel}
+	}
+	return nil
+}
+
+// CheckText checks text messages can be sent
+func CheckText() error {
+	return Check(FeatureTexting)
+}
+
+// CheckImage checks images can be sent
+func CheckImage() error {
+	return Check(FeatureImages)
+}
+
+// CheckLocation checks locations can be sent
+func CheckLocation() error {
+	return Check(FeatureLocations)
+}
+
+// CheckVoice checks voice streams can be sent
+func CheckVoice() error {
+	return Check(FeatureVoice)
+}
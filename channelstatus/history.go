@@ -0,0 +1,159 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package channelstatus
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/juju/errors"
+)
+
+// StatusRecord is one channel status change
+type StatusRecord struct {
+	Time               time.Time `json:"time"`
+	Channel            string    `json:"channel"`
+	Status             string    `json:"status"`
+	UsersOnline        int       `json:"users_online"`
+	ImagesSupported    bool      `json:"images_supported"`
+	TextingSupported   bool      `json:"texting_supported"`
+	LocationsSupported bool      `json:"locations_supported"`
+	Error              string    `json:"error,omitempty"`
+}
+
+// NewStatusRecord builds a record from a status message
+func NewStatusRecord(t time.Time, c *protocolapp.OnChannelStatus) StatusRecord {
+	return StatusRecord{
+		Time:               t.UTC(),
+		Channel:            c.Channel,
+		Status:             c.Status,
+		UsersOnline:        c.UsersOnline,
+		ImagesSupported:    c.ImagesSupported,
+		TextingSupported:   c.TextingSupported,
+		LocationsSupported: c.LocationsSupported,
+		Error:              c.Error,
+	}
+}
+
+// sameStatus compares everything but the time
+func (r StatusRecord) sameStatus(o StatusRecord) bool {
+	r.Time = o.Time
+	return r == o
+}
+
+// History keeps the most recent status changes, oldest first
+type History struct {
+	sync.Mutex
+	size    int
+	records []StatusRecord
+}
+
+// NewHistory create a History holding at most size records
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+	return &History{size: size}
+}
+
+// Add a record unless it repeats the latest one. It returns true if the
+// record was added.
+func (h *History) Add(r StatusRecord) bool {
+	h.Lock()
+	defer h.Unlock()
+	if n := len(h.records); n > 0 && h.records[n-1].sameStatus(r) {
+		return false
+	}
+	if len(h.records) == h.size {
+		copy(h.records, h.records[1:])
+		h.records = h.records[:h.size-1]
+	}
+	h.records = append(h.records, r)
+	return true
+}
+
+// Latest returns the most recent record, if there is one
+func (h *History) Latest() (StatusRecord, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if len(h.records) == 0 {
+		return StatusRecord{}, false
+	}
+	return h.records[len(h.records)-1], true
+}
+
+// Records returns records at or after since, oldest first. A positive
+// limit returns only the most recent limit records.
+func (h *History) Records(since time.Time, limit int) []StatusRecord {
+	h.Lock()
+	defer h.Unlock()
+	var records []StatusRecord
+	for _, r := range h.records {
+		if !r.Time.Before(since) {
+			records = append(records, r)
+		}
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records
+}
+
+var csvHeader = []string{
+	"time", "channel", "status", "users_online",
+	"images_supported", "texting_supported", "locations_supported", "error",
+}
+
+func (r StatusRecord) csvRow() []string {
+	return []string{
+		r.Time.Format(time.RFC3339),
+		r.Channel,
+		r.Status,
+		strconv.Itoa(r.UsersOnline),
+		strconv.FormatBool(r.ImagesSupported),
+		strconv.FormatBool(r.TextingSupported),
+		strconv.FormatBool(r.LocationsSupported),
+		r.Error,
+	}
+}
+
+// WriteCSV writes records with a header row
+func WriteCSV(w io.Writer, records []StatusRecord) error {
+	c := csv.NewWriter(w)
+	if err := c.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := c.Write(r.csvRow()); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// appendCSV adds a record to a CSV file, writing the header if the file is new
+func appendCSV(name string, r StatusRecord) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Annotate(err, "open channel history file")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Annotate(err, "channel history file")
+	}
+	c := csv.NewWriter(f)
+	if info.Size() == 0 {
+		c.Write(csvHeader)
+	}
+	c.Write(r.csvRow())
+	c.Flush()
+	return errors.Annotate(c.Error(), "write channel history file")
+}
@@ -0,0 +1,167 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package channelstatus
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// SubscriptionTypeChannelState carries a ChannelEvent each time the
+// channel condition changes
+const SubscriptionTypeChannelState = "channel_state"
+
+// Condition of the channel as reported by on_channel_status
+type Condition string
+
+// Channel conditions
+const (
+	ConditionUnknown Condition = ""
+	ConditionOnline  Condition = "online"
+	ConditionOffline Condition = "offline"
+	ConditionClosed  Condition = "closed"
+	ConditionBlocked Condition = "blocked"
+	ConditionError   Condition = "error"
+)
+
+// PolicyAction is what to do when the channel reports a failure condition
+type PolicyAction int
+
+// Policy actions
+const (
+	ActionTerminate PolicyAction = iota // stop the application
+	ActionRelogon                       // wait, then log on again with back off
+	ActionAlert                         // keep the connection and raise an alert
+)
+
+var policyActionNames = map[PolicyAction]string{
+	ActionTerminate: "terminate",
+	ActionRelogon:   "relogon",
+	ActionAlert:     "alert",
+}
+
+func (a PolicyAction) String() string {
+	if name, ok := policyActionNames[a]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParsePolicyAction converts a configured action name
+func ParsePolicyAction(name string) (PolicyAction, error) {
+	for a, n := range policyActionNames {
+		if strings.EqualFold(n, name) {
+			return a, nil
+		}
+	}
+	return ActionTerminate, errors.Errorf("unknown channel policy action '%s'", name)
+}
+
+// Policy maps each failure condition to an action
+type Policy map[Condition]PolicyAction
+
+// NewPolicy create a Policy from the 'channel.policy' config section
+func NewPolicy() (Policy, error) {
+	p := make(Policy)
+	for _, c := range []Condition{ConditionClosed, ConditionBlocked, ConditionError} {
+		key := "channel.policy." + string(c)
+		action, err := ParsePolicyAction(viper.GetString(key))
+		if err != nil {
+			return nil, errors.Annotate(err, key)
+		}
+		p[c] = action
+	}
+	return p, nil
+}
+
+// ChannelState is the channel condition as last reported
+type ChannelState struct {
+	Channel     string    `json:"channel"`
+	Condition   Condition `json:"condition"`
+	Action      string    `json:"action,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	UsersOnline int       `json:"users_online"`
+	Since       time.Time `json:"since"`
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+}
+
+// ChannelEvent describes a change of channel condition. Down is set when
+// the channel comes back online.
+type ChannelEvent struct {
+	Channel string        `json:"channel"`
+	From    Condition     `json:"from"`
+	To      Condition     `json:"to"`
+	Reason  string        `json:"reason,omitempty"`
+	At      time.Time     `json:"at"`
+	Down    time.Duration `json:"down,omitempty"`
+}
+
+// classify the condition reported by a status message
+func classify(c *protocolapp.OnChannelStatus) Condition {
+	switch {
+	case closedChannel(c):
+		return ConditionClosed
+	case blockedOnChannel(c):
+		return ConditionBlocked
+	case errorOnChannel(c):
+		return ConditionError
+	case strings.EqualFold(c.Status, "online"):
+		return ConditionOnline
+	}
+	return ConditionOffline
+}
+
+func blockedOnChannel(c *protocolapp.OnChannelStatus) bool {
+	/*
+	   Check for being blocked on channel
+	   ==================================
+
+	   c.Error == "blocked"
+	   c.ErrorType == "unknown"
+	   c.Status == "offline"
+	   c.UsersOnline == 0
+	*/
+	if strings.EqualFold(c.ErrorType, "unknown") &&
+		strings.EqualFold(c.Error, "blocked") &&
+		strings.EqualFold(c.Status, "offline") &&
+		c.UsersOnline == 0 {
+		return true
+	}
+	return false
+}
+
+func closedChannel(c *protocolapp.OnChannelStatus) bool {
+	/*
+	   Check for offline channel
+	   =========================
+
+	   c.Error == "channel_closed"
+	   c.ErrorType == "unknown"
+	   c.Status == "offline"
+	   c.UsersOnline == 0
+	*/
+	if strings.EqualFold(c.ErrorType, "unknown") &&
+		strings.EqualFold(c.Error, "channel_closed") &&
+		strings.EqualFold(c.Status, "offline") &&
+		c.UsersOnline == 0 {
+		return true
+	}
+	return false
+}
+
+func errorOnChannel(c *protocolapp.OnChannelStatus) bool {
+	/*
+	   Check for error on channel
+	   ==========================
+
+	   c.Error != ""
+	   c.ErrorType != ""
+	*/
+	return (c.ErrorType != "") || (c.Error != "")
+}
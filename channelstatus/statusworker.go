@@ -6,12 +6,15 @@ package channelstatus
 import (
 	"bytes"
 	"encoding/json"
-	"strings"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/jcmurray/monitor/authenticate"
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/errorcodes"
 	"github.com/jcmurray/monitor/network"
 	"github.com/jcmurray/monitor/protocolapp"
-	"github.com/jcmurray/monitor/errorcodes"
 	"github.com/jcmurray/monitor/worker"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -22,21 +25,31 @@ const ()
 // StatusWorker stream worker
 type StatusWorker struct {
 	sync.Mutex
-	command chan int
-	log     *log.Entry
-	id      int
-	label   string
-	workers *worker.Workers
+	command  chan int
+	log      *log.Entry
+	id       int
+	label    string
+	workers  *worker.Workers
+	policy   Policy
+	backoff  *network.ReconnectPolicy
+	retry    <-chan time.Time
+	state    ChannelState
+	history  *History
+	csvFile  string
+	finalise chan worker.FinaliseRequest
 }
 
 // NewStatusWorker create a new Statusworker
 func NewStatusWorker(workers *worker.Workers, id int, label string) *StatusWorker {
 	return &StatusWorker{
-		command: make(chan int, 10),
-		id:      id,
-		label:   label,
-		log:     log.WithFields(log.Fields{"Label": label, "ID": id}),
-		workers: workers,
+		command:  make(chan int, 10),
+		id:       id,
+		label:    label,
+		log:      log.WithFields(log.Fields{"Label": label, "ID": id}),
+		workers:  workers,
+		history:  NewHistory(viper.GetInt("channel.history.size")),
+		csvFile:  viper.GetString("channel.history.csv_file"),
+		finalise: make(chan worker.FinaliseRequest),
 	}
 }
 
@@ -49,12 +62,30 @@ func (w *StatusWorker) Run(wg *sync.WaitGroup, term *chan int) {
 	statusChannel := nw.Subscribe(w.id, protocolapp.OnChannelStatusEvent, w.label).Channel
 	errorChannel := nw.Subscribe(w.id, protocolapp.OnErrorEvent, w.label).Channel
 
+	policy, err := NewPolicy()
+	if err == nil {
+		w.backoff, err = network.NewReconnectPolicy()
+	}
+	if err != nil {
+		w.log.Errorf("Channel policy configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+	w.policy = policy
+
 waitloop:
 	for {
 		w.log.Debugf("Entering Select")
 		select {
 		case errorMessage := <-errorChannel:
-			w.log.Debugf("Response: %s", errorcodes.Description(string(errorMessage.([]byte))))
+			e, err := errorcodes.FromMessage(errorMessage.([]byte))
+			if err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			if !w.onError(e, term) {
+				break waitloop
+			}
 
 		case channelStatus := <-statusChannel:
 
@@ -65,25 +96,12 @@ waitloop:
 				continue
 			}
 
-			if w.closedChannel(c) {
-				w.log.Errorf("Error - Exiting - User: '%s' Channel Closed: '%s'", viper.GetString("logon.username"), c.Channel)
-				w.log.Tracef("Requesting application termination")
-				*term <- 1
-				continue
-			}
-
-			if w.blockedOnChannel(c) {
-				w.log.Errorf("Error - Exiting - User: '%s' Blocked on Channel: '%s'", viper.GetString("logon.username"), c.Channel)
-				w.log.Tracef("Requesting application termination")
-				*term <- 1
-				continue
+			w.record(c)
+			capabilities.Update(c)
+			if !w.statusChanged(nw, c, term) {
+				break waitloop
 			}
-
-			if w.errorOnChannel(c) {
-				w.log.Errorf("Error - Exiting - User: '%s' on Channel: '%s', error type '%s', %s",
-					viper.GetString("logon.username"), c.Channel, c.ErrorType, c.Error)
-				w.log.Tracef("Requesting application termination")
-				*term <- 1
+			if errorOnChannel(c) {
 				continue
 			}
 
@@ -107,6 +125,19 @@ waitloop:
 
 			w.log.Infof(statusMessage.String(), c.Channel, c.Status, c.UsersOnline)
 
+		case r := <-w.finalise:
+			w.log.Debugf("Resetting channel state: %s", r.Reason)
+			w.Lock()
+			w.state = ChannelState{}
+			w.Unlock()
+			w.retry = nil
+			close(r.Done)
+
+		case <-w.retry:
+			w.retry = nil
+			w.log.Infof("Logging on to channel '%s' again (attempt %d)", w.state.Channel, w.state.Attempts)
+			w.relogon()
+
 		case streamCommand, more := <-w.command:
 			if more {
 				w.log.Debugf("Received command %d", streamCommand)
@@ -140,6 +171,12 @@ func (w *StatusWorker) Terminate() {
 	w.Command(worker.Terminate)
 }
 
+// Finalise resets the channel state before the channel changes. The
+// status history is kept.
+func (w *StatusWorker) Finalise(reason string) bool {
+	return worker.Finalise(w.finalise, reason, worker.FinaliseTimeout)
+}
+
 // FindNetWorker find Net worker
 func (w *StatusWorker) findNetWorker() *network.Networker {
 	for i := range *w.workers {
@@ -151,59 +188,143 @@ func (w *StatusWorker) findNetWorker() *network.Networker {
 	return nil
 }
 
-func (w *StatusWorker) blockedOnChannel(c *protocolapp.OnChannelStatus) bool {
-	/*
-	   Check for being blocked on channel
-	   ==================================
+// statusChanged applies the channel policy to a status message. It
+// returns false when the worker should stop.
+func (w *StatusWorker) statusChanged(nw *network.Networker, c *protocolapp.OnChannelStatus, term *chan int) bool {
+	now := time.Now().UTC()
+	condition := classify(c)
+	reason := c.Error
+	if c.ErrorType != "" && c.ErrorType != "unknown" {
+		reason = fmt.Sprintf("%s (%s)", c.Error, c.ErrorType)
+	}
 
-	   If so then bail -- unrecoverable error
+	w.Lock()
+	previous := w.state
+	w.state.Channel = c.Channel
+	w.state.Condition = condition
+	w.state.Reason = reason
+	w.state.UsersOnline = c.UsersOnline
+	w.state.Action = ""
+	w.state.NextAttempt = time.Time{}
+	if condition != previous.Condition {
+		w.state.Since = now
+	}
+	if condition == ConditionOnline {
+		w.state.Attempts = 0
+	}
+	w.Unlock()
+
+	if condition != previous.Condition {
+		event := ChannelEvent{Channel: c.Channel, From: previous.Condition, To: condition, Reason: reason, At: now}
+		if condition == ConditionOnline && previous.Condition != ConditionUnknown {
+			event.Down = now.Sub(previous.Since)
+			w.retry = nil
+			w.log.Infof("Channel '%s' back online after %s", c.Channel, event.Down.Round(time.Second))
+		}
+		w.publish(nw, event)
+	}
 
-	   c.Error == "blocked"
-	   c.ErrorType == "unknown"
-	   c.Status == "offline"
-	   c.UsersOnline == 0
-	*/
-	if strings.EqualFold(c.ErrorType, "unknown") &&
-		strings.EqualFold(c.Error, "blocked") &&
-		strings.EqualFold(c.Status, "offline") &&
-		c.UsersOnline == 0 {
+	action, ok := w.policy[condition]
+	if !ok {
 		return true
 	}
-	return false
+	w.Lock()
+	w.state.Action = action.String()
+	w.Unlock()
+
+	username := viper.GetString("logon.username")
+	switch action {
+	case ActionTerminate:
+		w.log.Errorf("Error - Exiting - User: '%s' on Channel: '%s' %s: %s", username, c.Channel, condition, reason)
+		w.log.Tracef("Requesting application termination")
+		*term <- 1
+		return false
+
+	case ActionRelogon:
+		if w.retry != nil {
+			return true
+		}
+		w.Lock()
+		delay := w.backoff.Interval(w.state.Attempts)
+		w.state.Attempts++
+		w.state.NextAttempt = now.Add(delay)
+		w.Unlock()
+		w.log.Warnf("User: '%s' on Channel: '%s' %s: %s - logging on again in %s", username, c.Channel, condition, reason, delay)
+		w.retry = time.After(delay)
+
+	case ActionAlert:
+		w.log.Errorf("Alert - User: '%s' on Channel: '%s' %s: %s - keeping connection", username, c.Channel, condition, reason)
+	}
+	return true
+}
+
+// record a status change in the history and the CSV file, if configured
+func (w *StatusWorker) record(c *protocolapp.OnChannelStatus) {
+	r := NewStatusRecord(time.Now(), c)
+	if !w.history.Add(r) || w.csvFile == "" {
+		return
+	}
+	if err := appendCSV(w.csvFile, r); err != nil {
+		w.log.Errorf("Channel history error: %s", err)
+	}
 }
 
-func (w *StatusWorker) closedChannel(c *protocolapp.OnChannelStatus) bool {
-	/*
-	   Check for offline channel
-	   =========================
+// LatestStatus returns the most recent channel status, if one has been received
+func (w *StatusWorker) LatestStatus() (StatusRecord, bool) {
+	return w.history.Latest()
+}
 
-	   If so then bail -- unrecoverable error
+// StatusHistory returns status changes at or after since, oldest first. A
+// positive limit returns only the most recent limit changes.
+func (w *StatusWorker) StatusHistory(since time.Time, limit int) []StatusRecord {
+	return w.history.Records(since, limit)
+}
 
-	   c.Error == "channel_closed"
-	   c.ErrorType == "unknown"
-	   c.Status == "offline"
-	   c.UsersOnline == 0
-	*/
-	if strings.EqualFold(c.ErrorType, "unknown") &&
-		strings.EqualFold(c.Error, "channel_closed") &&
-		strings.EqualFold(c.Status, "offline") &&
-		c.UsersOnline == 0 {
-		return true
+func (w *StatusWorker) publish(nw *network.Networker, event ChannelEvent) {
+	buff, err := json.Marshal(event)
+	if err != nil {
+		w.log.Errorf("Marshal error: %s", err)
+		return
 	}
-	return false
+	nw.Publish(SubscriptionTypeChannelState, buff)
 }
 
-func (w *StatusWorker) errorOnChannel(c *protocolapp.OnChannelStatus) bool {
-	/*
-	   Check for error on channel
-	   ==========================
+// onError reacts to an on_error event. The status worker owns channel
+// errors: it logs on again or requests application termination for
+// them, as the catalogue recommends. It returns false when the worker
+// should stop.
+func (w *StatusWorker) onError(e *errorcodes.Error, term *chan int) bool {
+	switch reaction := errorcodes.React(e, errorcodes.CategoryChannel); reaction {
+	case errorcodes.ReactTerminate:
+		w.log.Errorf("Channel error - %s (%s) - requesting application termination", e.Code, e.Description)
+		*term <- 1
+		return false
+	case errorcodes.ReactRelogon:
+		w.log.Warnf("Channel error - %s (%s) - logging on again", e.Code, e.Description)
+		w.relogon()
+	case errorcodes.ReactRetry:
+		w.log.Infof("Channel error - %s (%s) - the request will be tried again", e.Code, e.Description)
+	default:
+		w.log.Debugf("Error: %s (%s, %s)", e.Description, e.Category, reaction)
+	}
+	return true
+}
 
-	   If so then bail -- unrecoverable error
+// relogon asks the Auth worker to log on again
+func (w *StatusWorker) relogon() {
+	for i := range *w.workers {
+		if aw, ok := (*w.workers)[i].(*authenticate.AuthWorker); ok {
+			aw.Command(worker.Relogon)
+			return
+		}
+	}
+}
 
-	   c.Error != ""
-	   c.ErrorType != ""
-	*/
-	return (c.ErrorType != "") || (c.Error != "")
+// ChannelState returns the channel condition as last reported
+func (w *StatusWorker) ChannelState() ChannelState {
+	w.Lock()
+	defer w.Unlock()
+	return w.state
 }
 
 // Label return label of worker
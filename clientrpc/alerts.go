@@ -0,0 +1,67 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	"github.com/jcmurray/monitor/alerts"
+	"github.com/jcmurray/monitor/clientapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WatchAlerts rpc entry point
+func (w *RPCWorker) WatchAlerts(r *clientapi.WatchAlertsRequest, stream clientapi.ClientService_WatchAlertsServer) error {
+	w.log.Debug("in WatchAlerts")
+
+	minSeverity, err := alerts.ParseSeverity(r.MinSeverity)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	aw := w.findAlertWorker()
+	if aw == nil {
+		return status.Errorf(codes.Unavailable, "alert worker is not running")
+	}
+
+	id, watch := aw.Watch(minSeverity)
+	defer aw.Unwatch(id)
+	for {
+		select {
+		case a, more := <-watch:
+			if !more {
+				return status.Errorf(codes.Unavailable, "alert worker has stopped")
+			}
+			if err := stream.Send(alert(a)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func alert(a alerts.Alert) *clientapi.Alert {
+	return &clientapi.Alert{
+		Id:       a.ID,
+		Time:     timestamp(a.Time),
+		Rule:     a.Rule,
+		Severity: a.Severity.String(),
+		Source:   string(a.Source),
+		Channel:  a.Channel,
+		From:     a.From,
+		For:      a.For,
+		Text:     a.Text,
+		Matched:  a.Matched,
+	}
+}
+
+// findAlertWorker find Alert worker
+func (w *RPCWorker) findAlertWorker() *alerts.AlertWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *alerts.AlertWorker:
+			return (*w.workers)[i].(*alerts.AlertWorker)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	context "context"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/jcmurray/monitor/schedule"
+	"github.com/juju/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// ListAnnouncements rpc entry point
+func (w *RPCWorker) ListAnnouncements(ctx context.Context, e *emptypb.Empty) (*clientapi.AnnouncementList, error) {
+	w.log.Debug("in ListAnnouncements")
+
+	sw := w.findSchedulerWorker()
+	if sw == nil {
+		return nil, status.Errorf(codes.Unavailable, "scheduler worker is not running")
+	}
+	list, err := sw.List()
+	if err != nil {
+		return nil, scheduleError(err)
+	}
+	response := &clientapi.AnnouncementList{}
+	for _, a := range list {
+		response.Announcements = append(response.Announcements, announcement(a))
+	}
+	return response, nil
+}
+
+// AddAnnouncement rpc entry point
+func (w *RPCWorker) AddAnnouncement(ctx context.Context, r *clientapi.Announcement) (*clientapi.Announcement, error) {
+	w.log.Infof("in AddAnnouncement")
+
+	sw := w.findSchedulerWorker()
+	if sw == nil {
+		return nil, status.Errorf(codes.Unavailable, "scheduler worker is not running")
+	}
+	a := schedule.Announcement{
+		ID:           r.Id,
+		Text:         r.Text,
+		Audio:        r.Audio,
+		For:          r.For,
+		Cron:         r.Cron,
+		TimeZone:     r.TimeZone,
+		SkipHolidays: r.SkipHolidays,
+		Paused:       r.Paused,
+	}
+	if r.Every != nil {
+		a.Every = r.Every.AsDuration().String()
+	}
+	if r.AfterActivity != nil {
+		a.AfterActivity = r.AfterActivity.AsDuration().String()
+	}
+	if r.Start != nil {
+		a.Start = r.Start.AsTime()
+	}
+	added, err := sw.Add(a)
+	if err != nil {
+		return nil, scheduleError(err)
+	}
+	return announcement(added), nil
+}
+
+// PauseAnnouncement rpc entry point
+func (w *RPCWorker) PauseAnnouncement(ctx context.Context, r *clientapi.PauseAnnouncementRequest) (*clientapi.Announcement, error) {
+	w.log.Infof("in PauseAnnouncement")
+
+	sw := w.findSchedulerWorker()
+	if sw == nil {
+		return nil, status.Errorf(codes.Unavailable, "scheduler worker is not running")
+	}
+	a, err := sw.SetPaused(r.Id, r.Paused)
+	if err != nil {
+		return nil, scheduleError(err)
+	}
+	return announcement(a), nil
+}
+
+// DeleteAnnouncement rpc entry point
+func (w *RPCWorker) DeleteAnnouncement(ctx context.Context, r *clientapi.AnnouncementID) (*emptypb.Empty, error) {
+	w.log.Infof("in DeleteAnnouncement")
+
+	sw := w.findSchedulerWorker()
+	if sw == nil {
+		return nil, status.Errorf(codes.Unavailable, "scheduler worker is not running")
+	}
+	if err := sw.Delete(r.Id); err != nil {
+		return nil, scheduleError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func announcement(a schedule.Announcement) *clientapi.Announcement {
+	response := &clientapi.Announcement{
+		Id:           a.ID,
+		Text:         a.Text,
+		Audio:        a.Audio,
+		For:          a.For,
+		Cron:         a.Cron,
+		TimeZone:     a.TimeZone,
+		SkipHolidays: a.SkipHolidays,
+		Paused:       a.Paused,
+		Start:        timestamp(a.Start),
+		LastSent:     timestamp(a.LastSent),
+		Next:         timestamp(a.Next),
+	}
+	if a.Interval() == 0 {
+		response.Start = nil
+	}
+	if every := a.Interval(); every > 0 {
+		response.Every = durationpb.New(every)
+	}
+	if period := a.ActivityPeriod(); period > 0 {
+		response.AfterActivity = durationpb.New(period)
+	}
+	return response
+}
+
+// scheduleError maps scheduler errors to gRPC status codes
+func scheduleError(err error) error {
+	switch errors.Cause(err) {
+	case schedule.ErrNotFound:
+		return status.Errorf(codes.NotFound, "%s", err)
+	case schedule.ErrExists:
+		return status.Errorf(codes.AlreadyExists, "%s", err)
+	case schedule.ErrNotRunning:
+		return status.Errorf(codes.Unavailable, "%s", err)
+	}
+	if errors.Is(err, errors.NotValid) {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	return status.Errorf(codes.Internal, "%s", err)
+}
+
+// findSchedulerWorker find Scheduler worker
+func (w *RPCWorker) findSchedulerWorker() *schedule.SchedulerWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *schedule.SchedulerWorker:
+			return (*w.workers)[i].(*schedule.SchedulerWorker)
+		}
+	}
+	return nil
+}
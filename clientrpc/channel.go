@@ -0,0 +1,74 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	context "context"
+	"strings"
+	"time"
+
+	"github.com/jcmurray/monitor/authenticate"
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/juju/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultSwitchTimeout = 30 * time.Second
+)
+
+// SwitchChannel rpc entry point
+func (w *RPCWorker) SwitchChannel(ctx context.Context, r *clientapi.SwitchChannelRequest) (*clientapi.SwitchChannelResponse, error) {
+	w.log.Infof("in SwitchChannel")
+
+	aw := w.findAuthWorker()
+	if aw == nil {
+		return nil, status.Errorf(codes.Unavailable, "auth worker is not running")
+	}
+
+	var listenOnly *bool
+	if r.ListenOnly != nil {
+		value := r.ListenOnly.Value
+		listenOnly = &value
+	}
+	timeout := defaultSwitchTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	err := aw.SwitchChannel(r.Channels, listenOnly, timeout)
+	switch errors.Cause(err) {
+	case nil:
+	case authenticate.ErrNoChannel:
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err)
+	case authenticate.ErrNotConnected:
+		return nil, status.Errorf(codes.Unavailable, "%s", err)
+	case authenticate.ErrSwitchPending:
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+	case authenticate.ErrSwitchTimeout:
+		return nil, status.Errorf(codes.DeadlineExceeded, "%s", err)
+	default:
+		return nil, status.Errorf(codes.Aborted, "%s", err)
+	}
+
+	channels, current := aw.Channels()
+	return &clientapi.SwitchChannelResponse{
+		Success:    true,
+		Message:    "logged on to channels '" + strings.Join(channels, "', '") + "'",
+		Channels:   channels,
+		ListenOnly: current,
+	}, nil
+}
+
+// findAuthWorker find Auth worker
+func (w *RPCWorker) findAuthWorker() *authenticate.AuthWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *authenticate.AuthWorker:
+			return (*w.workers)[i].(*authenticate.AuthWorker)
+		}
+	}
+	return nil
+}
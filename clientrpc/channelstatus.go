@@ -0,0 +1,89 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	"bytes"
+	context "context"
+	"time"
+
+	"github.com/jcmurray/monitor/channelstatus"
+	"github.com/jcmurray/monitor/clientapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// GetChannelStatus rpc entry point
+func (w *RPCWorker) GetChannelStatus(ctx context.Context, e *empty.Empty) (*clientapi.ChannelStatus, error) {
+	w.log.Debug("in GetChannelStatus")
+
+	sw := w.findStatusWorker()
+	if sw == nil {
+		return nil, status.Errorf(codes.Unavailable, "channel status worker is not running")
+	}
+	current, ok := sw.LatestStatus()
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "no channel status has been received yet")
+	}
+	return &clientapi.ChannelStatus{
+		Current: statusRecord(current),
+		State:   channelState(sw.ChannelState()),
+	}, nil
+}
+
+// ChannelStatusHistory rpc entry point
+func (w *RPCWorker) ChannelStatusHistory(ctx context.Context, r *clientapi.ChannelStatusHistoryRequest) (*clientapi.ChannelStatusHistoryResponse, error) {
+	w.log.Debug("in ChannelStatusHistory")
+
+	sw := w.findStatusWorker()
+	if sw == nil {
+		return nil, status.Errorf(codes.Unavailable, "channel status worker is not running")
+	}
+	if r.Limit < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "limit %d must not be negative", r.Limit)
+	}
+	var since time.Time
+	if r.Since != nil {
+		since = r.Since.AsTime()
+	}
+	history := sw.StatusHistory(since, int(r.Limit))
+
+	response := &clientapi.ChannelStatusHistoryResponse{}
+	for _, record := range history {
+		response.Records = append(response.Records, statusRecord(record))
+	}
+	if r.Csv {
+		var buff bytes.Buffer
+		if err := channelstatus.WriteCSV(&buff, history); err != nil {
+			return nil, status.Errorf(codes.Internal, "CSV export failed: %s", err)
+		}
+		response.Csv = buff.Bytes()
+	}
+	return response, nil
+}
+
+func statusRecord(r channelstatus.StatusRecord) *clientapi.ChannelStatusRecord {
+	return &clientapi.ChannelStatusRecord{
+		Time:               timestamp(r.Time),
+		Channel:            r.Channel,
+		Status:             r.Status,
+		UsersOnline:        int32(r.UsersOnline),
+		ImagesSupported:    r.ImagesSupported,
+		TextingSupported:   r.TextingSupported,
+		LocationsSupported: r.LocationsSupported,
+		Error:              r.Error,
+	}
+}
+
+// findStatusWorker find Status worker
+func (w *RPCWorker) findStatusWorker() *channelstatus.StatusWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *channelstatus.StatusWorker:
+			return (*w.workers)[i].(*channelstatus.StatusWorker)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	context "context"
+	fmt "fmt"
+
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/heard"
+	"github.com/jcmurray/monitor/locations"
+	"github.com/juju/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SendLocation rpc entry point
+func (w *RPCWorker) SendLocation(ctx context.Context, l *clientapi.LocationMessage) (*clientapi.LocationMessageResponse, error) {
+	w.log.Infof("in SendLocation")
+
+	lw := w.findLocationWorker()
+	if lw == nil {
+		return nil, status.Errorf(codes.Unavailable, "location worker is not running")
+	}
+	err := lw.Send(heard.Position{
+		Latitude:  l.Latitude,
+		Longitude: l.Longitude,
+		Accuracy:  l.Accuracy,
+		Address:   l.FormattedAddress,
+	}, l.For)
+	if err != nil {
+		return nil, locationError(err)
+	}
+
+	return &clientapi.LocationMessageResponse{
+		Success: true,
+		Message: fmt.Sprintf("Location %.7f,%.7f for '%s' sent", l.Latitude, l.Longitude, l.For),
+	}, nil
+}
+
+// locationError maps location worker errors to gRPC status codes
+func locationError(err error) error {
+	if _, ok := err.(*capabilities.Error); ok {
+		return capabilityError(err)
+	}
+	if errors.Is(err, errors.NotValid) {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if errors.Cause(err) == locations.ErrNoResponse {
+		return status.Errorf(codes.DeadlineExceeded, "%s", err)
+	}
+	if _, ok := errorcodes.As(err); ok {
+		return status.Errorf(codes.Aborted, "%s", err)
+	}
+	return status.Errorf(codes.Internal, "%s", err)
+}
+
+// findLocationWorker find Location worker
+func (w *RPCWorker) findLocationWorker() *locations.LocationWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *locations.LocationWorker:
+			return (*w.workers)[i].(*locations.LocationWorker)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	context "context"
+	fmt "fmt"
+	"strings"
+
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/jcmurray/monitor/private"
+	"github.com/jcmurray/monitor/protocolapp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ReplyPrivate rpc entry point
+func (w *RPCWorker) ReplyPrivate(ctx context.Context, r *clientapi.PrivateReply) (*clientapi.TextMessageResponse, error) {
+	w.log.Infof("in ReplyPrivate")
+
+	if strings.TrimSpace(r.Message) == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "message is empty")
+	}
+	to := strings.TrimSpace(r.To)
+	if to == "" {
+		peer, ok := private.LastPeer()
+		if !ok {
+			return nil, status.Errorf(codes.FailedPrecondition, "no private message to reply to")
+		}
+		to = peer
+	}
+	if err := capabilities.CheckText(); err != nil {
+		return nil, capabilityError(err)
+	}
+
+	tmw := w.findTextWorker()
+	q := tmw.Enqueue(protocolapp.InternalTextMessageRequest{
+		For:     to,
+		Message: r.Message,
+	})
+
+	return textQueued(fmt.Sprintf("Private reply for '%s' received: %s", to, r.Message), q), nil
+}
+
+// PrivateConversations rpc entry point
+func (w *RPCWorker) PrivateConversations(ctx context.Context, r *clientapi.PrivateConversationsRequest) (*clientapi.PrivateConversationsResponse, error) {
+	w.log.Infof("in PrivateConversations")
+
+	response := &clientapi.PrivateConversationsResponse{}
+	for _, c := range private.Recent(int(r.Limit)) {
+		response.Conversations = append(response.Conversations, &clientapi.PrivateConversation{
+			Peer:     c.Peer,
+			Channel:  c.Channel,
+			Last:     timestamppb.New(c.Last),
+			LastKind: string(c.LastKind),
+			LastText: c.LastText,
+			Received: int32(c.Received),
+			Sent:     int32(c.Sent),
+		})
+	}
+	return response, nil
+}
@@ -4,6 +4,8 @@
 package clientrpc
 
 import (
+	"time"
+
 	"github.com/jcmurray/monitor/audiodecoder"
 	"github.com/jcmurray/monitor/authenticate"
 	"github.com/jcmurray/monitor/channelstatus"
@@ -14,7 +16,9 @@ import (
 	"github.com/jcmurray/monitor/streams"
 	"github.com/jcmurray/monitor/texts"
 	"github.com/jcmurray/monitor/worker"
+	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const ()
@@ -41,6 +45,7 @@ func (w *RPCWorker) Status(empty *empty.Empty, stream clientapi.ClientService_St
 				Id:                 int32(t.ID()),
 				Name:               t.Label(),
 				WorkerSubscription: subs,
+				ConnectionState:    connectionState(t.StateSnapshot(), t.Liveness()),
 			}
 
 		case *authenticate.AuthWorker:
@@ -71,6 +76,7 @@ func (w *RPCWorker) Status(empty *empty.Empty, stream clientapi.ClientService_St
 				Id:                 int32(t.ID()),
 				Name:               t.Label(),
 				WorkerSubscription: subs,
+				ChannelState:       channelState(t.ChannelState()),
 			}
 
 		case *streams.StreamWorker:
@@ -171,6 +177,49 @@ func (w *RPCWorker) Status(empty *empty.Empty, stream clientapi.ClientService_St
 	return nil
 }
 
+func connectionState(snapshot network.StateSnapshot, liveness network.Liveness) *clientapi.ConnectionState {
+	var transitions []*clientapi.StateTransition
+	for _, t := range snapshot.Transitions {
+		transitions = append(transitions, &clientapi.StateTransition{
+			From:   t.From.String(),
+			To:     t.To.String(),
+			Reason: t.Reason,
+			At:     timestamppb.New(t.At),
+		})
+	}
+	return &clientapi.ConnectionState{
+		State:       snapshot.State.String(),
+		Reason:      snapshot.Reason,
+		Since:       timestamppb.New(snapshot.Since),
+		Transitions: transitions,
+		Rtt:         durationpb.New(liveness.RTT),
+		LastSeen:    timestamp(liveness.LastSeen),
+		LastPing:    timestamp(liveness.LastPing),
+		LastPong:    timestamp(liveness.LastPong),
+	}
+}
+
+func channelState(state channelstatus.ChannelState) *clientapi.ChannelState {
+	return &clientapi.ChannelState{
+		Channel:     state.Channel,
+		Condition:   string(state.Condition),
+		Action:      state.Action,
+		Reason:      state.Reason,
+		UsersOnline: int32(state.UsersOnline),
+		Since:       timestamp(state.Since),
+		Attempts:    int32(state.Attempts),
+		NextAttempt: timestamp(state.NextAttempt),
+	}
+}
+
+// timestamp converts t, leaving the zero time unset
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
 // Command sent to this worker
 func (w *RPCWorker) Command(c int) {
 	w.command <- c
@@ -0,0 +1,120 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	"bufio"
+	context "context"
+	"strings"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/jcmurray/monitor/texts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	exportChunkSize = 32 * 1024
+)
+
+// SearchTextHistory rpc entry point
+func (w *RPCWorker) SearchTextHistory(ctx context.Context, q *clientapi.TextHistoryQuery) (*clientapi.TextHistoryResponse, error) {
+	w.log.Debug("in SearchTextHistory")
+
+	history, query, err := w.textHistoryQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	response := &clientapi.TextHistoryResponse{}
+	for _, r := range history.Query(query) {
+		response.Records = append(response.Records, textRecord(r))
+	}
+	return response, nil
+}
+
+// ExportTextHistory rpc entry point
+func (w *RPCWorker) ExportTextHistory(r *clientapi.TextHistoryExportRequest, stream clientapi.ClientService_ExportTextHistoryServer) error {
+	w.log.Debug("in ExportTextHistory")
+
+	history, query, err := w.textHistoryQuery(r.Query)
+	if err != nil {
+		return err
+	}
+	var write func(records []texts.TextRecord) error
+	out := bufio.NewWriterSize(chunkWriter(func(data []byte) error {
+		return stream.Send(&clientapi.TextHistoryChunk{Data: data})
+	}), exportChunkSize)
+	switch strings.ToLower(r.Format) {
+	case "", "jsonl":
+		write = func(records []texts.TextRecord) error { return texts.WriteJSONL(out, records) }
+	case "csv":
+		write = func(records []texts.TextRecord) error { return texts.WriteCSV(out, records) }
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown export format '%s', use jsonl or csv", r.Format)
+	}
+	if err := write(history.Query(query)); err != nil {
+		return status.Errorf(codes.Internal, "export failed: %s", err)
+	}
+	if err := out.Flush(); err != nil {
+		return status.Errorf(codes.Internal, "export failed: %s", err)
+	}
+	return nil
+}
+
+// textHistoryQuery checks a query and finds the history it runs against
+func (w *RPCWorker) textHistoryQuery(q *clientapi.TextHistoryQuery) (*texts.TextHistory, texts.TextQuery, error) {
+	var query texts.TextQuery
+	tmw := w.findTextWorker()
+	if tmw == nil || tmw.TextHistory() == nil {
+		return nil, query, status.Errorf(codes.Unavailable, "text message worker is not running")
+	}
+	if q == nil {
+		return tmw.TextHistory(), query, nil
+	}
+	if q.Limit < 0 {
+		return nil, query, status.Errorf(codes.InvalidArgument, "limit %d must not be negative", q.Limit)
+	}
+	query = texts.TextQuery{
+		From:    q.From,
+		For:     q.For,
+		Channel: q.Channel,
+		Text:    q.Text,
+		Limit:   int(q.Limit),
+	}
+	if q.Since != nil {
+		query.Since = q.Since.AsTime()
+	}
+	if q.Until != nil {
+		query.Until = q.Until.AsTime()
+	}
+	if !query.Since.IsZero() && !query.Until.IsZero() && !query.Until.After(query.Since) {
+		return nil, query, status.Errorf(codes.InvalidArgument, "until must be after since")
+	}
+	return tmw.TextHistory(), query, nil
+}
+
+func textRecord(r texts.TextRecord) *clientapi.TextRecord {
+	return &clientapi.TextRecord{
+		Id:        r.ID,
+		Time:      timestamp(r.Time),
+		Direction: r.Direction,
+		Channel:   r.Channel,
+		From:      r.From,
+		For:       r.For,
+		MessageId: int32(r.MessageID),
+		Text:      r.Text,
+	}
+}
+
+// chunkWriter sends each write as a chunk of an export stream
+type chunkWriter func(data []byte) error
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	if err := c(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
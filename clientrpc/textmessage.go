@@ -5,12 +5,15 @@ package clientrpc
 
 import (
 	context "context"
-	"encoding/json"
 	fmt "fmt"
 
+	"github.com/jcmurray/monitor/capabilities"
 	"github.com/jcmurray/monitor/clientapi"
 	"github.com/jcmurray/monitor/protocolapp"
 	"github.com/jcmurray/monitor/texts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 const ()
@@ -21,18 +24,48 @@ func (w *RPCWorker) SendTextMessage(ctx context.Context, t *clientapi.TextMessag
 	w.log.Infof("For=%s", t.For)
 	w.log.Infof("Message=%s", t.Message)
 
-	message, _ := json.Marshal(protocolapp.InternalTextMessageRequest{
+	if err := capabilities.CheckText(); err != nil {
+		return nil, capabilityError(err)
+	}
+
+	tmw := w.findTextWorker()
+	q := tmw.Enqueue(protocolapp.InternalTextMessageRequest{
 		For:     t.For,
 		Message: t.Message,
 	})
 
-	tmw := w.findTextWorker()
-	tmw.TextMessageEvent(message)
+	return textQueued(fmt.Sprintf("Text messaage for '%s' received: %s", t.For, t.Message), q), nil
+}
 
+// textQueued builds the response to a text message added to the
+// outbound queue
+func textQueued(message string, q texts.Queued) *clientapi.TextMessageResponse {
+	if q.Parts > 1 {
+		message += fmt.Sprintf(" (in %d parts)", q.Parts)
+	}
+	if q.Wait > 0 {
+		message += fmt.Sprintf(", queued at position %d", q.Position)
+	}
 	return &clientapi.TextMessageResponse{
-		Success: true,
-		Message: fmt.Sprintf("Text messaage for '%s' received: %s", t.For, t.Message),
-	}, nil
+		Success:       true,
+		Message:       message,
+		QueuePosition: int32(q.Position),
+		Parts:         int32(q.Parts),
+		EstimatedWait: durationpb.New(q.Wait),
+	}
+}
+
+// capabilityError converts a capabilities error to a gRPC status
+func capabilityError(err error) error {
+	e, ok := err.(*capabilities.Error)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	switch e.Kind {
+	case capabilities.KindUnknown, capabilities.KindOffline:
+		return status.Error(codes.Unavailable, e.Error())
+	}
+	return status.Error(codes.FailedPrecondition, e.Error())
 }
 
 // findTextWorker find Text worker
@@ -0,0 +1,94 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package clientrpc
+
+import (
+	"bufio"
+	context "context"
+	"strings"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/jcmurray/monitor/locations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListTracks rpc entry point
+func (w *RPCWorker) ListTracks(ctx context.Context, q *clientapi.TrackQuery) (*clientapi.TrackList, error) {
+	w.log.Debug("in ListTracks")
+
+	store, query, err := w.trackQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	response := &clientapi.TrackList{}
+	for _, t := range store.Query(query) {
+		first, last := t.Fixes[0], t.Fixes[len(t.Fixes)-1]
+		coords := locations.Convert(last.Latitude, last.Longitude)
+		response.Tracks = append(response.Tracks, &clientapi.TrackSummary{
+			User:         t.User,
+			Fixes:        int32(len(t.Fixes)),
+			First:        timestamp(first.Time),
+			Last:         timestamp(last.Time),
+			Distance:     t.Distance(),
+			AverageSpeed: t.AverageSpeed(),
+			MaxSpeed:     t.MaxSpeed(),
+			Latitude:     last.Latitude,
+			Longitude:    last.Longitude,
+			Address:      last.Address,
+			Maidenhead:   coords.Maidenhead,
+			Mgrs:         coords.MGRS,
+			Place:        last.Place,
+		})
+	}
+	return response, nil
+}
+
+// ExportTracks rpc entry point
+func (w *RPCWorker) ExportTracks(r *clientapi.TrackExportRequest, stream clientapi.ClientService_ExportTracksServer) error {
+	w.log.Debug("in ExportTracks")
+
+	store, query, err := w.trackQuery(r.Query)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(r.Format) {
+	case locations.FormatGPX, locations.FormatKML, locations.FormatGeoJSON:
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown export format '%s', use gpx, kml or geojson", r.Format)
+	}
+	out := bufio.NewWriterSize(chunkWriter(func(data []byte) error {
+		return stream.Send(&clientapi.TrackChunk{Data: data})
+	}), exportChunkSize)
+	if err := locations.WriteTracks(out, r.Format, store.Query(query)); err != nil {
+		return status.Errorf(codes.Internal, "export failed: %s", err)
+	}
+	if err := out.Flush(); err != nil {
+		return status.Errorf(codes.Internal, "export failed: %s", err)
+	}
+	return nil
+}
+
+// trackQuery checks a query and finds the track store it runs against
+func (w *RPCWorker) trackQuery(q *clientapi.TrackQuery) (*locations.TrackStore, locations.TrackQuery, error) {
+	var query locations.TrackQuery
+	lw := w.findLocationWorker()
+	if lw == nil || lw.Tracks() == nil {
+		return nil, query, status.Errorf(codes.Unavailable, "location worker is not running")
+	}
+	if q == nil {
+		return lw.Tracks(), query, nil
+	}
+	query.User = q.User
+	if q.Since != nil {
+		query.Since = q.Since.AsTime()
+	}
+	if q.Until != nil {
+		query.Until = q.Until.AsTime()
+	}
+	if !query.Since.IsZero() && !query.Until.IsZero() && !query.Until.After(query.Since) {
+		return nil, query, status.Errorf(codes.InvalidArgument, "until must be after since")
+	}
+	return lw.Tracks(), query, nil
+}
@@ -0,0 +1,53 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	register("alerts", "Print alerts as they are raised", runAlerts)
+}
+
+func runAlerts(args []string) int {
+	var (
+		conn        connection
+		minSeverity string
+	)
+	flags := pflag.NewFlagSet("alerts", pflag.ContinueOnError)
+	flags.StringVarP(&minSeverity, "min-severity", "s", "", "Only alerts of this severity or above: info, warning or critical (default all)")
+	conn.addFlags(flags)
+	// Watching runs until interrupted unless a timeout is given
+	conn.timeout = 0
+	flags.Lookup("timeout").DefValue = "0s"
+	flags.Lookup("timeout").Usage = "Stop watching after this long (default never)"
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		stream, err := client.WatchAlerts(ctx, &clientapi.WatchAlertsRequest{MinSeverity: minSeverity})
+		if err != nil {
+			return err
+		}
+		for {
+			a, err := stream.Recv()
+			if err == io.EOF || ctx.Err() == context.DeadlineExceeded {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %-8s %s: '%s' from %s on %s: %s\n", a.Time.AsTime().Local().Format(time.RFC3339),
+				a.Severity, a.Rule, a.Matched, a.From, a.Channel, a.Text)
+		}
+	})
+}
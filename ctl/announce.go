@@ -0,0 +1,206 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func init() {
+	register("announcements", "List scheduled announcements", runAnnouncements)
+	register("announce", "Schedule a text or voice announcement", runAnnounce)
+	register("announce-pause", "Pause a scheduled announcement", runAnnouncePause(true))
+	register("announce-resume", "Resume a paused announcement", runAnnouncePause(false))
+	register("announce-delete", "Delete a scheduled announcement", runAnnounceDelete)
+}
+
+func runAnnouncements(args []string) int {
+	var conn connection
+	flags := pflag.NewFlagSet("announcements", pflag.ContinueOnError)
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		r, err := client.ListAnnouncements(ctx, &emptypb.Empty{})
+		if err != nil {
+			return err
+		}
+		t := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(t, "ID\tSCHEDULE\tZONE\tFOR\tNEXT\tLAST SENT\tAUDIO\tTEXT")
+		for _, a := range r.Announcements {
+			fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", a.Id, schedule(a), zone(a.TimeZone), a.For,
+				next(a), when(a.LastSent), a.Audio, a.Text)
+		}
+		return t.Flush()
+	})
+}
+
+func runAnnounce(args []string) int {
+	var (
+		conn          connection
+		id            string
+		forUser       string
+		cron          string
+		every         time.Duration
+		afterActivity time.Duration
+		audio         string
+		timeZone      string
+		start         string
+		skipHolidays  bool
+		paused        bool
+	)
+	flags := pflag.NewFlagSet("announce", pflag.ContinueOnError)
+	flags.StringVar(&id, "id", "", "Announcement id (default generated)")
+	flags.StringVar(&forUser, "for", "", "Send privately to this user (default the channel)")
+	flags.StringVar(&cron, "cron", "", "Cron expression, e.g. '0 9 * * mon-fri'")
+	flags.DurationVar(&every, "every", 0, "Send at this interval instead of on a cron expression")
+	flags.DurationVar(&afterActivity, "after-activity", 0, "Send once the channel has been active this long, such as a station ID")
+	flags.StringVar(&audio, "audio", "", "Ogg Opus file on the monitor to transmit, the text, if given, follows it")
+	flags.StringVar(&timeZone, "tz", "", "IANA time zone of the schedule (default UTC)")
+	flags.StringVar(&start, "start", "", "First send of an interval announcement, RFC3339 (default now)")
+	flags.BoolVar(&skipHolidays, "skip-holidays", false, "Do not send on the configured holidays")
+	flags.BoolVar(&paused, "paused", false, "Add the announcement paused")
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+	text := strings.Join(flags.Args(), " ")
+	if text == "" && audio == "" {
+		fmt.Fprintln(os.Stderr, "Nothing to announce: give --audio or the message after the flags")
+		return 2
+	}
+	a := &clientapi.Announcement{
+		Id:           id,
+		Text:         text,
+		Audio:        audio,
+		For:          forUser,
+		Cron:         cron,
+		TimeZone:     timeZone,
+		SkipHolidays: skipHolidays,
+		Paused:       paused,
+	}
+	if every > 0 {
+		a.Every = durationpb.New(every)
+	}
+	if afterActivity > 0 {
+		a.AfterActivity = durationpb.New(afterActivity)
+	}
+	if start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --start: %s\n", err)
+			return 2
+		}
+		a.Start = timestamppb.New(t)
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		r, err := client.AddAnnouncement(ctx, a)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Announcement '%s' scheduled, next %s\n", r.Id, next(r))
+		return nil
+	})
+}
+
+func runAnnouncePause(paused bool) func(args []string) int {
+	name := "announce-resume"
+	if paused {
+		name = "announce-pause"
+	}
+	return func(args []string) int {
+		var conn connection
+		flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+		conn.addFlags(flags)
+		if ok, code := parse(flags, args); !ok {
+			return code
+		}
+		if flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Give the id of the announcement")
+			return 2
+		}
+
+		return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+			r, err := client.PauseAnnouncement(ctx, &clientapi.PauseAnnouncementRequest{Id: flags.Arg(0), Paused: paused})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Announcement '%s' next %s\n", r.Id, next(r))
+			return nil
+		})
+	}
+}
+
+func runAnnounceDelete(args []string) int {
+	var conn connection
+	flags := pflag.NewFlagSet("announce-delete", pflag.ContinueOnError)
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Give the id of the announcement")
+		return 2
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		if _, err := client.DeleteAnnouncement(ctx, &clientapi.AnnouncementID{Id: flags.Arg(0)}); err != nil {
+			return err
+		}
+		fmt.Printf("Announcement '%s' deleted\n", flags.Arg(0))
+		return nil
+	})
+}
+
+// schedule describes when an announcement is sent
+func schedule(a *clientapi.Announcement) string {
+	var s string
+	switch {
+	case a.Cron != "":
+		s = a.Cron
+	case a.AfterActivity != nil:
+		s = "after " + a.AfterActivity.AsDuration().String() + " of activity"
+	default:
+		s = "every " + a.Every.AsDuration().String()
+	}
+	if a.SkipHolidays {
+		s += ", not holidays"
+	}
+	return s
+}
+
+func next(a *clientapi.Announcement) string {
+	if a.Paused {
+		return "paused"
+	}
+	return when(a.Next)
+}
+
+func when(t *timestamppb.Timestamp) string {
+	if t == nil {
+		return "-"
+	}
+	return t.AsTime().Local().Format(time.RFC3339)
+}
+
+func zone(name string) string {
+	if name == "" {
+		return "UTC"
+	}
+	return name
+}
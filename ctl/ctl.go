@@ -0,0 +1,115 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package ctl implements the 'monitor ctl' commands, which control a
+// running monitor through its gRPC API.
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/jcmurray/monitor/util"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+)
+
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+var commands = map[string]command{}
+
+func register(name string, summary string, run func(args []string) int) {
+	commands[name] = command{summary: summary, run: run}
+}
+
+// Run the command named by args[0] and return the process exit code
+func Run(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", args[0])
+		usage()
+		return 2
+	}
+	return c.run(args[1:])
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s ctl <command> [flags]\n\nCommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s ctl <command> --help' for the flags of a command.\n", os.Args[0])
+}
+
+// connection flags shared by every command
+type connection struct {
+	address string
+	timeout time.Duration
+}
+
+func (c *connection) addFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&c.address, "address", "a", fmt.Sprintf("localhost:%d", util.DefaultRPCServerPort), "Address of the monitor's gRPC API")
+	flags.DurationVarP(&c.timeout, "timeout", "t", defaultTimeout, "How long to wait for the monitor")
+}
+
+// call dials the monitor and runs f with a client and a context carrying
+// the timeout, if there is one
+func (c *connection) call(f func(ctx context.Context, client clientapi.ClientServiceClient) error) int {
+	conn, err := grpc.Dial(c.address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to connect to %s: %s\n", c.address, err)
+		return 1
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.timeout)
+	}
+	defer cancel()
+	if err := f(ctx, clientapi.NewClientServiceClient(conn)); err != nil {
+		if s, ok := status.FromError(err); ok {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", s.Code(), s.Message())
+		} else {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// parse flags, printing usage for --help
+func parse(flags *pflag.FlagSet, args []string) (bool, int) {
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return false, 0
+		}
+		return false, 2
+	}
+	return true, 0
+}
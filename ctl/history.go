@@ -0,0 +1,129 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/juju/errors"
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func init() {
+	register("history", "Search or export the text message history", runHistory)
+}
+
+func runHistory(args []string) int {
+	var (
+		conn         connection
+		since, until string
+		query        clientapi.TextHistoryQuery
+		export       string
+		output       string
+	)
+	flags := pflag.NewFlagSet("history", pflag.ContinueOnError)
+	flags.StringVar(&since, "since", "", "Oldest message, a time such as 2022-10-12 or 2022-10-12T09:00:00Z, or an age such as 7d or 36h")
+	flags.StringVar(&until, "until", "", "Only messages before this time or age")
+	flags.StringVar(&query.From, "from", "", "Only messages from this user")
+	flags.StringVar(&query.For, "for", "", "Only private messages for this user")
+	flags.StringVarP(&query.Channel, "channel", "c", "", "Only messages on this channel")
+	flags.StringVarP(&query.Text, "search", "s", "", "Only messages containing every word, end a word with * to match its prefix")
+	flags.Int32VarP(&query.Limit, "limit", "n", 0, "Only the most recent messages (default all)")
+	flags.StringVar(&export, "export", "", "Write the messages as jsonl or csv instead of a table")
+	flags.StringVarP(&output, "output", "o", "", "Write to this file instead of standard output")
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+
+	now := time.Now()
+	var err error
+	if query.Since, err = parseTime(since, now); err != nil {
+		fmt.Fprintf(os.Stderr, "--since: %s\n", err)
+		return 2
+	}
+	if query.Until, err = parseTime(until, now); err != nil {
+		fmt.Fprintf(os.Stderr, "--until: %s\n", err)
+		return 2
+	}
+
+	out := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		if export != "" {
+			stream, err := client.ExportTextHistory(ctx, &clientapi.TextHistoryExportRequest{Query: &query, Format: export})
+			if err != nil {
+				return err
+			}
+			for {
+				chunk, err := stream.Recv()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if _, err := out.Write(chunk.Data); err != nil {
+					return err
+				}
+			}
+		}
+
+		r, err := client.SearchTextHistory(ctx, &query)
+		if err != nil {
+			return err
+		}
+		t := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(t, "TIME\tCHANNEL\tFROM\tFOR\tTEXT")
+		for _, m := range r.Records {
+			fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\n", m.Time.AsTime().Local().Format(time.RFC3339), m.Channel, m.From, m.For, m.Text)
+		}
+		return t.Flush()
+	})
+}
+
+// parseTime accepts an RFC 3339 time, a date, or an age before now such
+// as 90m, 36h or 7d. An empty value returns nil.
+func parseTime(value string, now time.Time) (*timestamppb.Timestamp, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamppb.New(t), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return timestamppb.New(t), nil
+	}
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid age '%s'", value)
+		}
+		return timestamppb.New(now.AddDate(0, 0, -n)), nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return nil, errors.Errorf("'%s' is not a time, date or age", value)
+	}
+	return timestamppb.New(now.Add(-age)), nil
+}
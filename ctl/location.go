@@ -0,0 +1,47 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	register("send-location", "Send a position to the channel or a user", runSendLocation)
+}
+
+func runSendLocation(args []string) int {
+	var (
+		conn connection
+		l    clientapi.LocationMessage
+	)
+	flags := pflag.NewFlagSet("send-location", pflag.ContinueOnError)
+	flags.Float64Var(&l.Latitude, "lat", 0, "Latitude in decimal degrees, north positive")
+	flags.Float64Var(&l.Longitude, "lon", 0, "Longitude in decimal degrees, east positive")
+	flags.Float64Var(&l.Accuracy, "accuracy", 0, "Accuracy in metres")
+	flags.StringVar(&l.FormattedAddress, "address", "", "Address shown with the position")
+	flags.StringVar(&l.For, "for", "", "Send privately to this user (default the channel)")
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+	if !flags.Changed("lat") || !flags.Changed("lon") {
+		fmt.Fprintln(os.Stderr, "Give the position with --lat and --lon")
+		return 2
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		r, err := client.SendLocation(ctx, &l)
+		if err != nil {
+			return err
+		}
+		fmt.Println(r.Message)
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	register("reply", "Send a private text message", runReply)
+	register("conversations", "List recent private conversations", runConversations)
+}
+
+func runReply(args []string) int {
+	var (
+		conn connection
+		to   string
+	)
+	flags := pflag.NewFlagSet("reply", pflag.ContinueOnError)
+	flags.StringVar(&to, "to", "", "User to send to (default the sender of the most recent private message)")
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+	message := strings.Join(flags.Args(), " ")
+	if message == "" {
+		fmt.Fprintln(os.Stderr, "Nothing to send: give the message after the flags")
+		return 2
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		r, err := client.ReplyPrivate(ctx, &clientapi.PrivateReply{To: to, Message: message})
+		if err != nil {
+			return err
+		}
+		fmt.Println(r.Message)
+		return nil
+	})
+}
+
+func runConversations(args []string) int {
+	var (
+		conn  connection
+		limit int32
+	)
+	flags := pflag.NewFlagSet("conversations", pflag.ContinueOnError)
+	flags.Int32VarP(&limit, "limit", "n", 0, "Show only this many of the most recent (default all)")
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		r, err := client.PrivateConversations(ctx, &clientapi.PrivateConversationsRequest{Limit: limit})
+		if err != nil {
+			return err
+		}
+		t := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(t, "USER\tCHANNEL\tLAST\tKIND\tIN\tOUT\tTEXT")
+		for _, c := range r.Conversations {
+			fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", c.Peer, c.Channel,
+				c.Last.AsTime().Local().Format(time.RFC3339), c.LastKind, c.Received, c.Sent, c.LastText)
+		}
+		return t.Flush()
+	})
+}
@@ -0,0 +1,52 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func init() {
+	register("switch", "Log on to other channels or change listen-only mode", runSwitch)
+}
+
+func runSwitch(args []string) int {
+	var (
+		conn       connection
+		channels   []string
+		listenOnly bool
+	)
+	flags := pflag.NewFlagSet("switch", pflag.ContinueOnError)
+	flags.StringSliceVarP(&channels, "channel", "c", nil, "Channel to log on to, repeat or separate with commas for several (default keep the current channels)")
+	flags.BoolVar(&listenOnly, "listen-only", false, "Log on listen-only, --listen-only=false to allow sending (default keep the current mode)")
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+
+	request := &clientapi.SwitchChannelRequest{Channels: channels}
+	if flags.Changed("listen-only") {
+		request.ListenOnly = wrapperspb.Bool(listenOnly)
+	}
+	if len(request.Channels) == 0 && request.ListenOnly == nil {
+		fmt.Fprintln(os.Stderr, "Nothing to change: give --channel and/or --listen-only")
+		return 2
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		r, err := client.SwitchChannel(ctx, request)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Logged on to '%s', listen only %t\n", strings.Join(r.Channels, "', '"), r.ListenOnly)
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jcmurray/monitor/clientapi"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	register("tracks", "List or export the location tracks of users", runTracks)
+}
+
+func runTracks(args []string) int {
+	var (
+		conn         connection
+		since, until string
+		query        clientapi.TrackQuery
+		export       string
+		output       string
+	)
+	flags := pflag.NewFlagSet("tracks", pflag.ContinueOnError)
+	flags.StringVarP(&query.User, "user", "u", "", "Only this user's track (default every user)")
+	flags.StringVar(&since, "since", "", "Oldest fix, a time such as 2022-10-12 or 2022-10-12T09:00:00Z, or an age such as 7d or 36h")
+	flags.StringVar(&until, "until", "", "Only fixes before this time or age")
+	flags.StringVar(&export, "export", "", "Write the tracks as gpx, kml or geojson instead of a summary")
+	flags.StringVarP(&output, "output", "o", "", "Write to this file instead of standard output")
+	conn.addFlags(flags)
+	if ok, code := parse(flags, args); !ok {
+		return code
+	}
+
+	now := time.Now()
+	var err error
+	if query.Since, err = parseTime(since, now); err != nil {
+		fmt.Fprintf(os.Stderr, "--since: %s\n", err)
+		return 2
+	}
+	if query.Until, err = parseTime(until, now); err != nil {
+		fmt.Fprintf(os.Stderr, "--until: %s\n", err)
+		return 2
+	}
+
+	out := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return conn.call(func(ctx context.Context, client clientapi.ClientServiceClient) error {
+		if export != "" {
+			stream, err := client.ExportTracks(ctx, &clientapi.TrackExportRequest{Query: &query, Format: export})
+			if err != nil {
+				return err
+			}
+			for {
+				chunk, err := stream.Recv()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if _, err := out.Write(chunk.Data); err != nil {
+					return err
+				}
+			}
+		}
+
+		r, err := client.ListTracks(ctx, &query)
+		if err != nil {
+			return err
+		}
+		t := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(t, "USER\tFIXES\tFIRST\tLAST\tDISTANCE\tAVERAGE\tMAX\tPOSITION\tGRID\tMGRS\tPLACE\tADDRESS")
+		for _, s := range r.Tracks {
+			fmt.Fprintf(t, "%s\t%d\t%s\t%s\t%.2f km\t%.1f km/h\t%.1f km/h\t%.5f,%.5f\t%s\t%s\t%s\t%s\n", s.User, s.Fixes,
+				s.First.AsTime().Local().Format(time.RFC3339), s.Last.AsTime().Local().Format(time.RFC3339),
+				s.Distance/1000, s.AverageSpeed*3.6, s.MaxSpeed*3.6, s.Latitude, s.Longitude, s.Maidenhead, s.Mgrs, s.Place, s.Address)
+		}
+		return t.Flush()
+	})
+}
@@ -3,32 +3,272 @@
 
 package errorcodes
 
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+
+	"github.com/jcmurray/monitor/protocolapp"
+)
+
+// Category groups error codes by their cause
+type Category int
+
+// Error categories
+const (
+	CategoryUnknown Category = iota
+	CategoryAuth
+	CategoryTransient
+	CategoryChannel
+	CategoryClientBug
+)
+
+var categoryNames = map[Category]string{
+	CategoryUnknown:   "unknown",
+	CategoryAuth:      "auth",
+	CategoryTransient: "transient",
+	CategoryChannel:   "channel",
+	CategoryClientBug: "client bug",
+}
+
+func (c Category) String() string {
+	if name, ok := categoryNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Category(%d)", int(c))
+}
+
+// Action is what a worker should do about an error
+type Action int
+
+// Recommended actions
+const (
+	ActionReport Action = iota // log it and drop the request
+	ActionIgnore
+	ActionBackoff   // try the request again later
+	ActionRelogon   // log on again
+	ActionTerminate // stop the application
+)
+
+var actionNames = map[Action]string{
+	ActionReport:    "report",
+	ActionIgnore:    "ignore",
+	ActionBackoff:   "back off",
+	ActionRelogon:   "re-logon",
+	ActionTerminate: "terminate",
+}
+
+func (a Action) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Error is a Zello error code with its category and recommended action
+type Error struct {
+	Code        string
+	Request     string // request that failed, if known
+	Description string
+	Category    Category
+	Action      Action
+}
+
+func (e *Error) Error() string {
+	if e.Request != "" {
+		return fmt.Sprintf("%s: %s", e.Request, e.Code)
+	}
+	return e.Code
+}
+
+// Is matches any Error with the same code, so a catalogue entry such as
+// ErrNotAuthorized can be used with errors.Is
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+type entry struct {
+	description string
+	category    Category
+	action      Action
+}
+
+var (
+	catalogue map[string]entry
+
+	// logonCatalogue overrides the catalogue for failed logon requests,
+	// where retrying the same credentials cannot succeed
+	logonCatalogue map[string]entry
+)
+
+// Frequently tested errors
 var (
-	d map[string]string
+	ErrNotAuthorized   = &Error{Code: "not authorized"}
+	ErrNotLoggedIn     = &Error{Code: "not logged in"}
+	ErrListenOnly      = &Error{Code: "listen only connection"}
+	ErrChannelNotReady = &Error{Code: "channel is not ready"}
 )
 
 func init() {
-	d = map[string]string{}
-	d["unknown command"] = "Server didn't recognize the command received from the client."
-	d["internal server error"] = "An internal error occured within the server. If the error persists please contact us at support@zello.com."
-	d["invalid json"] = "The command received included malformed JSON."
-	d["invalid request"] = "The server couldn't recognize command format."
-	d["not authorized"] = "Username, password or token are not valid."
-	d["not logged in"] = "Server received a command before successful logon."
-	d["not enough params"] = "The command doesn't include some of the required attributes."
-	d["server closed connection"] = "The connection to Zello network was closed. You can try re-connecting."
-	d["channel is not ready"] = "Channel you are trying to talk to is not yet connected. Wait for channel online status before sending a message"
-	d["listen only connection"] = "The client tried to send a message over listen-only connection."
-	d["failed to start stream"] = "Unable to start the stream for unknown reason. You can try again later."
-	d["failed to stop stream"] = "Unable to stop the stream for unknown reason. This error is safe to ignore."
-	d["failed to send data"] = "An error occured while trying to send stream data packet."
-	d["invalid audio packet"] = "Malformed audio packet is received."
+	catalogue = map[string]entry{
+		"unknown command":          {"Server didn't recognize the command received from the client.", CategoryClientBug, ActionReport},
+		"internal server error":    {"An internal error occured within the server. If the error persists please contact us at support@zello.com.", CategoryTransient, ActionBackoff},
+		"invalid json":             {"The command received included malformed JSON.", CategoryClientBug, ActionReport},
+		"invalid request":          {"The server couldn't recognize command format.", CategoryClientBug, ActionReport},
+		"not authorized":           {"Username, password or token are not valid.", CategoryAuth, ActionRelogon},
+		"not logged in":            {"Server received a command before successful logon.", CategoryAuth, ActionRelogon},
+		"not enough params":        {"The command doesn't include some of the required attributes.", CategoryClientBug, ActionReport},
+		"server closed connection": {"The connection to Zello network was closed. You can try re-connecting.", CategoryTransient, ActionBackoff},
+		"channel is not ready":     {"Channel you are trying to talk to is not yet connected. Wait for channel online status before sending a message", CategoryChannel, ActionBackoff},
+		"listen only connection":   {"The client tried to send a message over listen-only connection.", CategoryClientBug, ActionReport},
+		"failed to start stream":   {"Unable to start the stream for unknown reason. You can try again later.", CategoryTransient, ActionBackoff},
+		"failed to stop stream":    {"Unable to stop the stream for unknown reason. This error is safe to ignore.", CategoryTransient, ActionIgnore},
+		"failed to send data":      {"An error occured while trying to send stream data packet.", CategoryTransient, ActionBackoff},
+		"invalid audio packet":     {"Malformed audio packet is received.", CategoryClientBug, ActionReport},
+		"invalid username":         {"The username is not known to Zello.", CategoryAuth, ActionTerminate},
+		"invalid password":         {"The password does not match the username.", CategoryAuth, ActionTerminate},
+	}
+	logonCatalogue = map[string]entry{
+		"not authorized": {"The auth token is not valid.", CategoryAuth, ActionTerminate},
+	}
+	for _, e := range []*Error{ErrNotAuthorized, ErrNotLoggedIn, ErrListenOnly, ErrChannelNotReady} {
+		fill(e, catalogue)
+	}
 }
 
 // Description from error code
 func Description(code string) string {
-	if desc, ok := d[code]; ok {
-		return desc
+	if e, ok := catalogue[code]; ok {
+		return e.description
 	}
 	return "Unrecognised error code."
 }
+
+// New returns the Error for a code. Codes not in the catalogue are
+// CategoryUnknown with ActionReport.
+func New(code string, request string) *Error {
+	e := &Error{Code: code, Request: request}
+	if request == protocolapp.LogonRequest && fill(e, logonCatalogue) {
+		return e
+	}
+	fill(e, catalogue)
+	return e
+}
+
+func fill(e *Error, from map[string]entry) bool {
+	found, ok := from[e.Code]
+	if !ok {
+		e.Description = "Unrecognised error code."
+		return false
+	}
+	e.Description = found.description
+	e.Category = found.category
+	e.Action = found.action
+	return true
+}
+
+// FromOnError returns the Error carried by an on_error event
+func FromOnError(e *protocolapp.OnError) error {
+	return New(e.Error, "")
+}
+
+// FromMessage parses a raw on_error event
+func FromMessage(message []byte) (*Error, error) {
+	e := protocolapp.NewOnError()
+	if err := json.Unmarshal(message, e); err != nil {
+		return nil, err
+	}
+	return New(e.Error, ""), nil
+}
+
+// FromResponse returns the Error for a failed response to request, or nil
+// if the request succeeded
+func FromResponse(r *protocolapp.Response, request string) error {
+	if r.Success {
+		return nil
+	}
+	return New(r.Error, request)
+}
+
+// As returns the Error in err's chain, if there is one
+func As(err error) (*Error, bool) {
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// CategoryOf returns the category of err, CategoryUnknown if it is not an Error
+func CategoryOf(err error) Category {
+	if e, ok := As(err); ok {
+		return e.Category
+	}
+	return CategoryUnknown
+}
+
+// Reaction is what a worker does about an error
+type Reaction int
+
+// Reactions
+const (
+	ReactLog       Reaction = iota // nothing beyond logging it
+	ReactRetry                     // try the failed request again after a back off
+	ReactReset                     // discard work tied to the session, which is about to end
+	ReactRelogon                   // log on again
+	ReactTerminate                 // request application termination
+)
+
+var reactionNames = map[Reaction]string{
+	ReactLog:       "log",
+	ReactRetry:     "retry",
+	ReactReset:     "reset",
+	ReactRelogon:   "re-logon",
+	ReactTerminate: "terminate",
+}
+
+func (r Reaction) String() string {
+	if name, ok := reactionNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reaction(%d)", int(r))
+}
+
+// React returns how a worker that owns the given categories reacts to err.
+// Logging on again and terminating are left to the owner of the error's
+// category, so that one error does not stop the application or log on
+// again once for each worker; the others reset.
+func React(err error, owns ...Category) Reaction {
+	e, ok := As(err)
+	if !ok {
+		return ReactLog
+	}
+	owner := false
+	for _, c := range owns {
+		owner = owner || c == e.Category
+	}
+	switch e.Action {
+	case ActionBackoff:
+		return ReactRetry
+	case ActionRelogon:
+		if owner {
+			return ReactRelogon
+		}
+		return ReactReset
+	case ActionTerminate:
+		if owner {
+			return ReactTerminate
+		}
+		return ReactReset
+	}
+	return ReactLog
+}
+
+// ActionOf returns the recommended action for err, ActionReport if it is not an Error
+func ActionOf(err error) Action {
+	if e, ok := As(err); ok {
+		return e.Action
+	}
+	return ActionReport
+}
@@ -0,0 +1,55 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package errorcodes
+
+import (
+	"testing"
+
+	"github.com/jcmurray/monitor/protocolapp"
+)
+
+func TestReact(t *testing.T) {
+	for _, c := range []struct {
+		code    string
+		request string
+		owns    []Category
+		want    Reaction
+	}{
+		// Terminate is left to the owner of the category
+		{"invalid password", "", []Category{CategoryAuth}, ReactTerminate},
+		{"invalid password", "", []Category{CategoryChannel}, ReactReset},
+		{"invalid username", "", nil, ReactReset},
+		{"not authorized", protocolapp.LogonRequest, []Category{CategoryAuth}, ReactTerminate},
+		// As is logging on again
+		{"not authorized", "", []Category{CategoryAuth}, ReactRelogon},
+		{"not logged in", "", []Category{CategoryAuth, CategoryChannel}, ReactRelogon},
+		{"not authorized", "", nil, ReactReset},
+		// Anyone may retry or log
+		{"channel is not ready", "", nil, ReactRetry},
+		{"failed to send data", "", []Category{CategoryChannel}, ReactRetry},
+		{"invalid json", "", []Category{CategoryClientBug}, ReactLog},
+		{"failed to stop stream", "", nil, ReactLog},
+		{"no such error", "", []Category{CategoryUnknown}, ReactLog},
+	} {
+		if got := React(New(c.code, c.request), c.owns...); got != c.want {
+			t.Errorf("React(%s, %v) = %s, want %s", c.code, c.owns, got, c.want)
+		}
+	}
+}
+
+func TestReactToMessage(t *testing.T) {
+	e, err := FromMessage([]byte(`{"command":"on_error","error":"not authorized"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Category != CategoryAuth || e.Action != ActionRelogon {
+		t.Fatalf("decoded %s as %s, %s", e.Code, e.Category, e.Action)
+	}
+	if got := React(e, CategoryAuth); got != ReactRelogon {
+		t.Errorf("auth owner reacts with %s", got)
+	}
+	if got := React(nil); got != ReactLog {
+		t.Errorf("no error reacts with %s", got)
+	}
+}
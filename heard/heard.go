@@ -0,0 +1,137 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package heard remembers when each user was last heard on a channel and
+// the last position they reported.
+package heard
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of traffic a user is heard sending
+const (
+	KindVoice    = "voice"
+	KindText     = "text"
+	KindImage    = "image"
+	KindLocation = "location"
+)
+
+// maxUsers is the number of users remembered, the least recently heard
+// being forgotten first
+const maxUsers = 500
+
+// Position is a location reported by a user
+type Position struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Accuracy  float64   `json:"accuracy,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Station is what is known about one user
+type Station struct {
+	User     string    `json:"user"`
+	Channel  string    `json:"channel,omitempty"`
+	Kind     string    `json:"kind"`
+	Last     time.Time `json:"last"`
+	Position *Position `json:"position,omitempty"`
+}
+
+var (
+	lock     sync.Mutex
+	stations = map[string]*Station{}
+)
+
+// Heard records traffic from user
+func Heard(user string, channel string, kind string) {
+	if user == "" {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	s := station(user)
+	s.Channel = channel
+	s.Kind = kind
+	s.Last = time.Now().UTC()
+}
+
+// Located records a position reported by user
+func Located(user string, channel string, p Position) {
+	if user == "" {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	s := station(user)
+	s.Channel = channel
+	s.Kind = KindLocation
+	s.Last = time.Now().UTC()
+	if p.Time.IsZero() {
+		p.Time = s.Last
+	}
+	s.Position = &p
+}
+
+// station returns the entry for user, creating it if needed. lock must be held.
+func station(user string) *Station {
+	key := strings.ToLower(user)
+	if s, ok := stations[key]; ok {
+		return s
+	}
+	if len(stations) >= maxUsers {
+		oldest := ""
+		for k, s := range stations {
+			if oldest == "" || s.Last.Before(stations[oldest].Last) {
+				oldest = k
+			}
+		}
+		delete(stations, oldest)
+	}
+	s := &Station{User: user}
+	stations[key] = s
+	return s
+}
+
+// Get returns what is known about user
+func Get(user string) (Station, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	s, ok := stations[strings.ToLower(user)]
+	if !ok {
+		return Station{}, false
+	}
+	return s.copy(), true
+}
+
+// Recent returns the most recently heard users, most recent first. A
+// positive limit returns only that many.
+func Recent(limit int) []Station {
+	lock.Lock()
+	defer lock.Unlock()
+	recent := make([]Station, 0, len(stations))
+	for _, s := range stations {
+		recent = append(recent, s.copy())
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].Last.After(recent[j].Last)
+	})
+	if limit > 0 && len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent
+}
+
+// copy a Station and its Position
+func (s *Station) copy() Station {
+	result := *s
+	if s.Position != nil {
+		p := *s.Position
+		result.Position = &p
+	}
+	return result
+}
@@ -8,10 +8,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/heard"
 	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
 	"github.com/jcmurray/monitor/protocolapp"
 	"github.com/jcmurray/monitor/worker"
 	log "github.com/sirupsen/logrus"
@@ -45,6 +48,7 @@ type ImageWorker struct {
 	label        string
 	workers      *worker.Workers
 	activeImages imagesInfo
+	finalise     chan worker.FinaliseRequest
 }
 
 // NewImageWorker create a new ImageWorker
@@ -56,6 +60,7 @@ func NewImageWorker(workers *worker.Workers, id int, label string) *ImageWorker
 		log:          log.WithFields(log.Fields{"Label": label, "ID": id}),
 		workers:      workers,
 		activeImages: make(imagesInfo),
+		finalise:     make(chan worker.FinaliseRequest),
 	}
 }
 
@@ -74,7 +79,12 @@ waitloop:
 		w.log.Debugf("Entering Select")
 		select {
 		case errorMessage := <-errorChannel:
-			w.log.Debugf("Response: %s", errorcodes.Description(string(errorMessage.([]byte))))
+			e, err := errorcodes.FromMessage(errorMessage.([]byte))
+			if err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.onError(e)
 
 		case imageMessage := <-imageChannel:
 
@@ -98,7 +108,13 @@ waitloop:
 				thumbnailReceived: false,
 				fullImageReceived: false,
 			}
+			heard.Heard(c.From, c.Channel, heard.KindImage)
 
+			if private.IsPrivate(c.For) {
+				private.Log(w.log, "Private image id %d Started - from '%s' on '%s' for '%s'", c.MessageID, c.From, c.Channel, c.For)
+				private.Notify(nw, private.Received(private.KindImage, c.Channel, c.From, c.For, c.MessageID, ""))
+				continue
+			}
 			w.log.Infof("Message id %d Started - from '%s' on '%s' for '%s'", c.MessageID, c.From, c.Channel, c.For)
 
 		case imageData := <-imageDataChannel:
@@ -128,6 +144,10 @@ waitloop:
 			}
 			continue
 
+		case r := <-w.finalise:
+			w.discard(r.Reason)
+			close(r.Done)
+
 		case imageCommand, more := <-w.command:
 			if more {
 				w.log.Debugf("Received command %d", imageCommand)
@@ -162,6 +182,29 @@ func (w *ImageWorker) Terminate() {
 	w.Command(worker.Terminate)
 }
 
+// onError reacts to an on_error event. The rest of an incomplete image
+// will not arrive once the session ends.
+func (w *ImageWorker) onError(e *errorcodes.Error) {
+	reaction := errorcodes.React(e)
+	w.log.Debugf("Error: %s (%s, %s)", e.Description, e.Category, reaction)
+	if reaction == errorcodes.ReactReset {
+		w.discard(e.Code)
+	}
+}
+
+// discard incomplete images
+func (w *ImageWorker) discard(reason string) {
+	for id, ii := range w.activeImages {
+		w.log.Infof("Image id %d Discarded incomplete - from '%s' on '%s' for '%s': %s", id, ii.From, ii.Channel, ii.For, reason)
+		delete(w.activeImages, id)
+	}
+}
+
+// Finalise discards incomplete images before the channel changes
+func (w *ImageWorker) Finalise(reason string) bool {
+	return worker.Finalise(w.finalise, reason, worker.FinaliseTimeout)
+}
+
 // FindNetWorker find Net worker
 func (w *ImageWorker) findNetWorker() *network.Networker {
 	for i := range *w.workers {
@@ -190,9 +233,17 @@ func (w *ImageWorker) saveThumbNailImageFile(ai *ImageInfo, data []byte) {
 }
 
 func (w *ImageWorker) saveImageFile(ai *ImageInfo, fileName string, data []byte) {
+	if dir := private.GetRoute().ImageDirectory; dir != "" && private.IsPrivate(ai.For) {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			w.log.Errorf("Private image directory error: %s", err)
+			return
+		}
+		fileName = filepath.Join(dir, fileName)
+	}
 	f, err := os.Create(fileName)
 	if err != nil {
 		w.log.Errorf("Image file creation error: %s", err)
+		return
 	}
 	defer f.Close()
 	_, err = f.Write(data)
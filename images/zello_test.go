@@ -0,0 +1,99 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package images_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/images"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
+	"github.com/jcmurray/monitor/zellotest"
+	"github.com/spf13/viper"
+)
+
+const testChannel = "Test Channel"
+
+// start runs an image worker against a logged on test server, saving
+// private images in a temporary directory
+func start(t *testing.T) (*zellotest.Server, *zellotest.Client, *images.ImageWorker, string) {
+	t.Helper()
+	s := zellotest.NewServer()
+	t.Cleanup(s.Close)
+	s.Configure(testChannel, "monitor", "secret", "token")
+	dir := t.TempDir()
+	viper.Set("logon.state_file", filepath.Join(dir, "state.json"))
+	viper.Set("image.logging", true)
+	viper.Set("private.image_directory", filepath.Join(dir, "private"))
+	t.Cleanup(func() {
+		viper.Set("image.logging", false)
+		viper.Set("private.image_directory", "")
+	})
+
+	c := s.NewClient()
+	iw := images.NewImageWorker(&c.Workers, zellotest.FirstWorkerID, "Image Worker")
+	c.Add(iw)
+	c.Start(t)
+	s.ExpectLogon(t, testChannel)
+	c.WaitForState(t, network.StateOnline)
+	return s, c, iw, filepath.Join(dir, "private")
+}
+
+func TestPrivateImageSaved(t *testing.T) {
+	logs := zellotest.WatchLogs(t)
+	s, c, iw, dir := start(t)
+	messages := c.Network.Subscribe(zellotest.FirstWorkerID+1, private.SubscriptionTypePrivateMessage, "Test").Channel
+
+	id, err := s.SendImage(testChannel, "alice", "monitor", []byte("thumbnail"), []byte("full image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-messages:
+		var p private.Message
+		if err := json.Unmarshal(m.([]byte), &p); err != nil || p.Kind != private.KindImage || p.ID != id || p.Peer != "alice" {
+			t.Fatalf("private message %+v, %v", p, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no private message published")
+	}
+	logs.Expect(t, fmt.Sprintf("Logging full      image on message ID %d", id))
+
+	// The worker handles one event at a time, so once Finalise returns
+	// the full image has been written
+	if !iw.Finalise("test") {
+		t.Fatal("image worker not finalised")
+	}
+	for name, want := range map[string]string{
+		fmt.Sprintf("image_thumb_%d_camera.jpeg", id): "thumbnail",
+		fmt.Sprintf("image_full_%d_camera.jpeg", id):  "full image",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s is %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestIncompleteImageDiscardedOnSessionError(t *testing.T) {
+	logs := zellotest.WatchLogs(t)
+	s, _, _, _ := start(t)
+
+	// Only the thumbnail arrives before the session ends
+	id, err := s.SendImage(testChannel, "alice", "monitor", []byte("thumbnail"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs.Expect(t, fmt.Sprintf("Logging thumbnail image on message ID %d", id))
+	if err := s.SendError("not authorized"); err != nil {
+		t.Fatal(err)
+	}
+	logs.Expect(t, fmt.Sprintf("Image id %d Discarded incomplete", id))
+	s.ExpectLogon(t, testChannel)
+}
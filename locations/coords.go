@@ -0,0 +1,205 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package locations
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// WGS84 ellipsoid and UTM projection constants
+const (
+	wgs84Axis       = 6378137.0
+	wgs84Flattening = 1 / 298.257223563
+	utmScale        = 0.9996
+	utmFalseEasting = 500000.0
+	utmFalseNorth   = 10000000.0 // added to northings in the southern hemisphere
+)
+
+const (
+	utmBands       = "CDEFGHJKLMNPQRSTUVWX"
+	mgrsRowLetters = "ABCDEFGHJKLMNPQRSTUV"
+)
+
+var mgrsColumnLetters = [3]string{"ABCDEFGH", "JKLMNPQR", "STUVWXYZ"}
+
+// Coordinates is a position in the formats people read off maps and say
+// over the radio. UTM and MGRS are empty outside latitudes 80°S to 84°N.
+type Coordinates struct {
+	DMS        string `json:"dms,omitempty"`
+	Maidenhead string `json:"maidenhead,omitempty"`
+	UTM        string `json:"utm,omitempty"`
+	MGRS       string `json:"mgrs,omitempty"`
+}
+
+// Convert a position in decimal degrees to the other formats
+func Convert(latitude float64, longitude float64) Coordinates {
+	c := Coordinates{
+		DMS:        DMS(latitude, longitude),
+		Maidenhead: Maidenhead(latitude, longitude, 6),
+	}
+	if u, err := ToUTM(latitude, longitude); err == nil {
+		c.UTM = u.String()
+		c.MGRS = u.MGRS(5)
+	}
+	return c
+}
+
+// DMS formats a position as degrees, minutes and seconds, such as
+// 51°30'02.5"N 0°07'28.6"W
+func DMS(latitude float64, longitude float64) string {
+	return dms(latitude, "N", "S") + " " + dms(longitude, "E", "W")
+}
+
+func dms(value float64, positive string, negative string) string {
+	hemisphere := positive
+	if value < 0 {
+		hemisphere = negative
+	}
+	tenths := int64(math.Round(math.Abs(value) * 36000))
+	return fmt.Sprintf("%d°%02d'%02d.%d\"%s", tenths/36000, tenths/600%60, tenths/10%60, tenths%10, hemisphere)
+}
+
+// Maidenhead returns the grid locator of a position with length
+// characters, from 2 to 10 and even. Six, such as IO91wm, is the usual
+// length and narrows a position to about 5 by 2.5 km.
+func Maidenhead(latitude float64, longitude float64, length int) string {
+	if length < 2 {
+		length = 2
+	}
+	if length > 10 {
+		length = 10
+	}
+	// Each pair divides the previous cell, alternately into 18 fields,
+	// 10 squares and 24 subsquares
+	lon := math.Min(math.Max(longitude+180, 0), 360-1e-9)
+	lat := math.Min(math.Max(latitude+90, 0), 180-1e-9)
+	lonSize, latSize := 20.0, 10.0
+	var b strings.Builder
+	for pair := 0; pair < length/2; pair++ {
+		base, divisions := byte('A'), 18.0
+		switch {
+		case pair%2 == 1:
+			base, divisions = '0', 10
+		case pair > 0:
+			base, divisions = 'a', 24
+		}
+		if pair > 0 {
+			lonSize /= divisions
+			latSize /= divisions
+		}
+		x, y := math.Floor(lon/lonSize), math.Floor(lat/latSize)
+		b.WriteByte(base + byte(x))
+		b.WriteByte(base + byte(y))
+		lon -= x * lonSize
+		lat -= y * latSize
+	}
+	return b.String()
+}
+
+// UTM is a position on the Universal Transverse Mercator grid
+type UTM struct {
+	Zone     int
+	Band     byte
+	Easting  float64 // metres
+	Northing float64 // metres, from the equator or, in the southern hemisphere, 10,000 km south of it
+}
+
+// String formats the position such as 30U 699316 5710164
+func (u UTM) String() string {
+	return fmt.Sprintf("%d%c %.0f %.0f", u.Zone, u.Band, math.Floor(u.Easting), math.Floor(u.Northing))
+}
+
+// MGRS formats the position as a Military Grid Reference System
+// reference, such as 30U XC 99316 10164, with digits figures each for the
+// easting and northing within the 100 km square: 5 for 1 m, 4 for 10 m
+// and so on down to 1 for 10 km
+func (u UTM) MGRS(digits int) string {
+	if digits < 1 {
+		digits = 1
+	}
+	if digits > 5 {
+		digits = 5
+	}
+	set := (u.Zone - 1) % 3
+	column := int(math.Floor(u.Easting/100000)) - 1
+	row := int(math.Floor(u.Northing/100000)) % 20
+	if u.Zone%2 == 0 {
+		row = (row + 5) % 20
+	}
+	divisor := math.Pow(10, float64(5-digits))
+	easting := math.Floor(math.Mod(u.Easting, 100000) / divisor)
+	northing := math.Floor(math.Mod(u.Northing, 100000) / divisor)
+	return fmt.Sprintf("%d%c %c%c %0*.0f %0*.0f", u.Zone, u.Band,
+		mgrsColumnLetters[set][column], mgrsRowLetters[row], digits, easting, digits, northing)
+}
+
+// ToUTM projects a WGS84 position onto its UTM zone, keeping the Norway
+// and Svalbard exceptions, with the Krüger series to third order
+func ToUTM(latitude float64, longitude float64) (UTM, error) {
+	if latitude < -80 || latitude > 84 || longitude < -180 || longitude > 180 {
+		return UTM{}, errors.NotValidf("UTM position %g,%g", latitude, longitude)
+	}
+	if longitude == 180 {
+		longitude = -180
+	}
+	zone := int(math.Floor((longitude+180)/6)) + 1
+	band := int(math.Floor((latitude + 80) / 8))
+	if band > len(utmBands)-1 {
+		band = len(utmBands) - 1 // X covers 72°N to 84°N
+	}
+	switch {
+	case utmBands[band] == 'V' && zone == 31 && longitude >= 3:
+		zone = 32
+	case utmBands[band] == 'X' && zone == 32:
+		zone = 31
+		if longitude >= 9 {
+			zone = 33
+		}
+	case utmBands[band] == 'X' && zone == 34:
+		zone = 33
+		if longitude >= 21 {
+			zone = 35
+		}
+	case utmBands[band] == 'X' && zone == 36:
+		zone = 35
+		if longitude >= 33 {
+			zone = 37
+		}
+	}
+
+	n := wgs84Flattening / (2 - wgs84Flattening)
+	a := wgs84Axis / (1 + n) * (1 + n*n/4 + n*n*n*n/64)
+	alpha := [3]float64{
+		n/2 - 2*n*n/3 + 5*n*n*n/16,
+		13*n*n/48 - 3*n*n*n/5,
+		61 * n * n * n / 240,
+	}
+	phi := radians(latitude)
+	lambda := radians(longitude - float64(zone*6-183))
+	e := 2 * math.Sqrt(n) / (1 + n)
+	t := math.Sinh(math.Atanh(math.Sin(phi)) - e*math.Atanh(e*math.Sin(phi)))
+	xi := math.Atan2(t, math.Cos(lambda))
+	eta := math.Atanh(math.Sin(lambda) / math.Sqrt(1+t*t))
+
+	easting, northing := eta, xi
+	for j, alphaJ := range alpha {
+		k := 2 * float64(j+1)
+		easting += alphaJ * math.Cos(k*xi) * math.Sinh(k*eta)
+		northing += alphaJ * math.Sin(k*xi) * math.Cosh(k*eta)
+	}
+	u := UTM{
+		Zone:     zone,
+		Band:     utmBands[band],
+		Easting:  utmFalseEasting + utmScale*a*easting,
+		Northing: utmScale * a * northing,
+	}
+	if latitude < 0 {
+		u.Northing += utmFalseNorth
+	}
+	return u, nil
+}
@@ -0,0 +1,219 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package locations
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Track export formats
+const (
+	FormatGPX     = "gpx"
+	FormatKML     = "kml"
+	FormatGeoJSON = "geojson"
+)
+
+// WriteTracks writes tracks in one of the export formats
+func WriteTracks(w io.Writer, format string, tracks []Track) error {
+	switch strings.ToLower(format) {
+	case FormatGPX:
+		return WriteGPX(w, tracks)
+	case FormatKML:
+		return WriteKML(w, tracks)
+	case FormatGeoJSON:
+		return WriteGeoJSON(w, tracks)
+	}
+	return errors.Errorf("unknown track format '%s', use gpx, kml or geojson", format)
+}
+
+// WriteGPX writes tracks as GPX 1.1, one track per user. The speed and
+// course at each point are given in a Garmin TrackPointExtension, and its
+// grid references in the comment.
+func WriteGPX(w io.Writer, tracks []Track) error {
+	b := bufio.NewWriter(w)
+	b.WriteString(xml.Header)
+	b.WriteString(`<gpx version="1.1" creator="monitor" xmlns="http://www.topografix.com/GPX/1/1"` +
+		` xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">` + "\n")
+	for _, t := range tracks {
+		fmt.Fprintf(b, "  <trk>\n    <name>%s</name>\n", escape(t.User))
+		fmt.Fprintf(b, "    <desc>%s</desc>\n", escape(summary(t)))
+		b.WriteString("    <trkseg>\n")
+		for _, f := range t.Fixes {
+			fmt.Fprintf(b, "      <trkpt lat=\"%.7f\" lon=\"%.7f\">\n", f.Latitude, f.Longitude)
+			fmt.Fprintf(b, "        <time>%s</time>\n", f.Time.UTC().Format(time.RFC3339))
+			fmt.Fprintf(b, "        <cmt>%s</cmt>\n", escape(references(f)))
+			if f.Address != "" {
+				fmt.Fprintf(b, "        <desc>%s</desc>\n", escape(f.Address))
+			}
+			b.WriteString("        <extensions><gpxtpx:TrackPointExtension>")
+			fmt.Fprintf(b, "<gpxtpx:speed>%.2f</gpxtpx:speed><gpxtpx:course>%.1f</gpxtpx:course>", f.Speed, f.Heading)
+			b.WriteString("</gpxtpx:TrackPointExtension></extensions>\n")
+			b.WriteString("      </trkpt>\n")
+		}
+		b.WriteString("    </trkseg>\n  </trk>\n")
+	}
+	b.WriteString("</gpx>\n")
+	return b.Flush()
+}
+
+// WriteKML writes tracks as KML 2.2, one gx:Track placemark per user so
+// that the time of each point is kept, with the grid references of each
+// point in its extended data
+func WriteKML(w io.Writer, tracks []Track) error {
+	b := bufio.NewWriter(w)
+	b.WriteString(xml.Header)
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">` + "\n")
+	b.WriteString("<Document>\n  <name>monitor tracks</name>\n")
+	b.WriteString("  <Schema id=\"references\">\n")
+	for _, name := range referenceNames {
+		fmt.Fprintf(b, "    <gx:SimpleArrayField name=\"%s\" type=\"string\"/>\n", name)
+	}
+	b.WriteString("  </Schema>\n")
+	for _, t := range tracks {
+		b.WriteString("  <Placemark>\n")
+		fmt.Fprintf(b, "    <name>%s</name>\n", escape(t.User))
+		fmt.Fprintf(b, "    <description>%s</description>\n", escape(summary(t)))
+		b.WriteString("    <gx:Track>\n")
+		for _, f := range t.Fixes {
+			fmt.Fprintf(b, "      <when>%s</when>\n", f.Time.UTC().Format(time.RFC3339))
+		}
+		for _, f := range t.Fixes {
+			fmt.Fprintf(b, "      <gx:coord>%.7f %.7f 0</gx:coord>\n", f.Longitude, f.Latitude)
+		}
+		b.WriteString("      <ExtendedData>\n        <SchemaData schemaUrl=\"#references\">\n")
+		for i, name := range referenceNames {
+			fmt.Fprintf(b, "          <gx:SimpleArrayData name=\"%s\">\n", name)
+			for _, f := range t.Fixes {
+				fmt.Fprintf(b, "            <gx:value>%s</gx:value>\n", escape(referenceValues(f)[i]))
+			}
+			b.WriteString("          </gx:SimpleArrayData>\n")
+		}
+		b.WriteString("        </SchemaData>\n      </ExtendedData>\n")
+		b.WriteString("    </gx:Track>\n  </Placemark>\n")
+	}
+	b.WriteString("</Document>\n</kml>\n")
+	return b.Flush()
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// WriteGeoJSON writes tracks as a GeoJSON feature collection: a
+// LineString for each user, with the time of each point in its
+// coordTimes property, followed by a Point for each fix
+func WriteGeoJSON(w io.Writer, tracks []Track) error {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, t := range tracks {
+		line := make([][2]float64, 0, len(t.Fixes))
+		times := make([]string, 0, len(t.Fixes))
+		for _, f := range t.Fixes {
+			line = append(line, [2]float64{f.Longitude, f.Latitude})
+			times = append(times, f.Time.UTC().Format(time.RFC3339))
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: line},
+			Properties: map[string]interface{}{
+				"user":          t.User,
+				"coordTimes":    times,
+				"distance":      round(t.Distance(), 1),
+				"duration":      t.Duration().Seconds(),
+				"average_speed": round(t.AverageSpeed(), 2),
+				"max_speed":     round(t.MaxSpeed(), 2),
+			},
+		})
+		for _, f := range t.Fixes {
+			properties := map[string]interface{}{
+				"user":     t.User,
+				"time":     f.Time.UTC().Format(time.RFC3339),
+				"distance": round(f.Distance, 1),
+				"speed":    round(f.Speed, 2),
+				"heading":  round(f.Heading, 1),
+			}
+			if f.Channel != "" {
+				properties["channel"] = f.Channel
+			}
+			if f.Accuracy > 0 {
+				properties["accuracy"] = f.Accuracy
+			}
+			if f.Address != "" {
+				properties["address"] = f.Address
+			}
+			for i, value := range referenceValues(f) {
+				if value != "" {
+					properties[referenceNames[i]] = value
+				}
+			}
+			collection.Features = append(collection.Features, geoJSONFeature{
+				Type:       "Feature",
+				Geometry:   geoJSONGeometry{Type: "Point", Coordinates: [2]float64{f.Longitude, f.Latitude}},
+				Properties: properties,
+			})
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(collection)
+}
+
+// summary describes a track for people reading an export
+func summary(t Track) string {
+	return fmt.Sprintf("%d fixes, %.2f km in %s, average %.1f km/h, max %.1f km/h",
+		len(t.Fixes), t.Distance()/1000, t.Duration().Round(time.Second), t.AverageSpeed()*3.6, t.MaxSpeed()*3.6)
+}
+
+// referenceNames are the names of referenceValues in KML and GeoJSON
+var referenceNames = []string{"dms", "maidenhead", "utm", "mgrs", "place"}
+
+// referenceValues returns the position of a fix in other formats
+func referenceValues(f Fix) []string {
+	c := Convert(f.Latitude, f.Longitude)
+	return []string{c.DMS, c.Maidenhead, c.UTM, c.MGRS, f.Place}
+}
+
+// references describes the position of a fix in other formats for people
+func references(f Fix) string {
+	c := Convert(f.Latitude, f.Longitude)
+	s := c.DMS + " " + c.Maidenhead
+	if c.MGRS != "" {
+		s += " " + c.MGRS
+	}
+	if f.Place != "" {
+		s += " " + f.Place
+	}
+	return s
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
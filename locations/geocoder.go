@@ -0,0 +1,203 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package locations
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	w3w "github.com/jcmurray/what3words"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// Geocoder names a position, such as with a what3words address. It is
+// called on the location worker's goroutine, behind a cache.
+type Geocoder interface {
+	Name() string
+	Geocode(latitude float64, longitude float64) (string, error)
+}
+
+// GeocoderFactory makes a geocoder from the config
+type GeocoderFactory func() (Geocoder, error)
+
+var (
+	geocodersLock sync.Mutex
+	geocoders     = map[string]GeocoderFactory{
+		"what3words": newWhat3Words,
+	}
+)
+
+// RegisterGeocoder makes a geocoder available to the 'location.geocoder'
+// config setting
+func RegisterGeocoder(name string, factory GeocoderFactory) {
+	geocodersLock.Lock()
+	defer geocodersLock.Unlock()
+	geocoders[strings.ToLower(name)] = factory
+}
+
+// NewGeocoder makes the geocoder named by 'location.geocoder', behind a
+// cache sized by 'location.geocoder_cache'. It returns nil if there is
+// none or 'location.offline' is set. The old 'location.what3words'
+// setting chooses what3words.
+func NewGeocoder() (Geocoder, error) {
+	if viper.GetBool("location.offline") {
+		return nil, nil
+	}
+	name := strings.ToLower(viper.GetString("location.geocoder"))
+	if name == "" && viper.GetBool("location.what3words") {
+		name = "what3words"
+	}
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	geocodersLock.Lock()
+	factory, ok := geocoders[name]
+	var names []string
+	for n := range geocoders {
+		names = append(names, n)
+	}
+	geocodersLock.Unlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, errors.Errorf("unknown location.geocoder '%s', use none or %s", name, strings.Join(names, ", "))
+	}
+	g, err := factory()
+	if err != nil {
+		return nil, errors.Annotatef(err, "geocoder '%s'", name)
+	}
+	size := viper.GetInt("location.geocoder_cache.size")
+	ttl := viper.GetDuration("location.geocoder_cache.ttl")
+	if size <= 0 {
+		return g, nil
+	}
+	return NewCachedGeocoder(g, size, ttl), nil
+}
+
+// what3words geocoder, made once so the API client is reused
+type what3Words struct {
+	api *w3w.Geocoder
+}
+
+func newWhat3Words() (Geocoder, error) {
+	key := viper.GetString("location.what3wordsapikey")
+	if key == "" {
+		return nil, errors.New("location.what3wordsapikey is not set")
+	}
+	return &what3Words{api: w3w.NewGeocoder(key)}, nil
+}
+
+func (g *what3Words) Name() string {
+	return "what3words"
+}
+
+func (g *what3Words) Geocode(latitude float64, longitude float64) (string, error) {
+	coords, err := w3w.NewCoordinates(latitude, longitude)
+	if err != nil {
+		return "", err
+	}
+	resp, err := g.api.ConvertTo3wa(coords)
+	if err != nil {
+		return "", err
+	}
+	return "///" + resp.Words, nil
+}
+
+// CachedGeocoder keeps the most recently used answers of a geocoder for a
+// time, so that a user reporting the same position again does not use up
+// a paid quota. Positions are matched to about a metre. Failures are not
+// cached.
+type CachedGeocoder struct {
+	sync.Mutex
+	geocoder Geocoder
+	size     int
+	ttl      time.Duration
+	entries  map[[2]int64]*list.Element
+	recent   *list.List // most recently used at the front
+	now      func() time.Time
+	hits     int
+	misses   int
+}
+
+type cacheEntry struct {
+	key     [2]int64
+	place   string
+	expires time.Time
+}
+
+// NewCachedGeocoder caches up to size answers of g, each for ttl. A zero
+// ttl keeps them until they are pushed out.
+func NewCachedGeocoder(g Geocoder, size int, ttl time.Duration) *CachedGeocoder {
+	return &CachedGeocoder{
+		geocoder: g,
+		size:     size,
+		ttl:      ttl,
+		entries:  make(map[[2]int64]*list.Element),
+		recent:   list.New(),
+		now:      time.Now,
+	}
+}
+
+// Name of the cached geocoder
+func (c *CachedGeocoder) Name() string {
+	return c.geocoder.Name()
+}
+
+// Geocode answers from the cache, or asks the geocoder and caches its
+// answer
+func (c *CachedGeocoder) Geocode(latitude float64, longitude float64) (string, error) {
+	key := [2]int64{int64(math.Round(latitude * 1e5)), int64(math.Round(longitude * 1e5))}
+	now := c.now()
+
+	c.Lock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if entry.expires.IsZero() || now.Before(entry.expires) {
+			c.recent.MoveToFront(e)
+			c.hits++
+			c.Unlock()
+			return entry.place, nil
+		}
+		c.recent.Remove(e)
+		delete(c.entries, key)
+	}
+	c.misses++
+	c.Unlock()
+
+	place, err := c.geocoder.Geocode(latitude, longitude)
+	if err != nil {
+		return "", err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	entry := &cacheEntry{key: key, place: place}
+	if c.ttl > 0 {
+		entry.expires = now.Add(c.ttl)
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.recent.MoveToFront(e)
+		return place, nil
+	}
+	c.entries[key] = c.recent.PushFront(entry)
+	for c.recent.Len() > c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return place, nil
+}
+
+// Stats returns the number of answers from the cache and from the
+// geocoder
+func (c *CachedGeocoder) Stats() (hits int, misses int) {
+	c.Lock()
+	defer c.Unlock()
+	return c.hits, c.misses
+}
@@ -0,0 +1,480 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package locations
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// SubscriptionTypeGeofence carries a GeofenceEvent each time a user
+// enters, leaves or dwells in a geofence
+const SubscriptionTypeGeofence = "geofence"
+
+// Geofence event kinds
+const (
+	GeofenceEnter = "enter"
+	GeofenceExit  = "exit"
+	GeofenceDwell = "dwell"
+)
+
+const (
+	geofenceDwellCheck = 30 * time.Second
+)
+
+var geofenceSeverities = []string{"info", "warning", "critical"}
+
+// GeofenceEvent is published when a user enters, leaves or has dwelt in
+// a geofence
+type GeofenceEvent struct {
+	Time      time.Time     `json:"time"`
+	Kind      string        `json:"kind"`
+	Fence     string        `json:"fence"`
+	Severity  string        `json:"severity,omitempty"`
+	User      string        `json:"user"`
+	Channel   string        `json:"channel,omitempty"`
+	Latitude  float64       `json:"latitude"`
+	Longitude float64       `json:"longitude"`
+	Accuracy  float64       `json:"accuracy,omitempty"`
+	Inside    time.Duration `json:"inside,omitempty"` // time spent inside, for exit and dwell events
+	Place     string        `json:"place,omitempty"`
+	Coordinates
+	sendText bool
+}
+
+// String describes the event for people
+func (e GeofenceEvent) String() string {
+	at := fmt.Sprintf("%.5f,%.5f", e.Latitude, e.Longitude)
+	if e.MGRS != "" {
+		at += " (" + e.MGRS + ")"
+	}
+	switch e.Kind {
+	case GeofenceEnter:
+		return fmt.Sprintf("%s entered %s at %s", e.User, e.Fence, at)
+	case GeofenceExit:
+		return fmt.Sprintf("%s left %s after %s at %s", e.User, e.Fence, e.Inside.Round(time.Second), at)
+	case GeofenceDwell:
+		return fmt.Sprintf("%s has been in %s for %s at %s", e.User, e.Fence, e.Inside.Round(time.Second), at)
+	}
+	return fmt.Sprintf("%s %s %s", e.User, e.Kind, e.Fence)
+}
+
+// FenceConfig is one entry of the 'location.geofences.fences' config
+// list, or the properties of a feature in the geofence GeoJSON file. A
+// fence is either a circle, given by its centre and radius, or a polygon.
+type FenceConfig struct {
+	Name      string      `mapstructure:"name" json:"name"`
+	Severity  string      `mapstructure:"severity" json:"severity"`
+	AlertOn   []string    `mapstructure:"alert_on" json:"alert_on"`
+	Dwell     string      `mapstructure:"dwell" json:"dwell"`
+	Text      bool        `mapstructure:"text" json:"text"`
+	Latitude  float64     `mapstructure:"latitude" json:"-"`
+	Longitude float64     `mapstructure:"longitude" json:"-"`
+	Radius    float64     `mapstructure:"radius" json:"radius"`
+	Polygon   [][]float64 `mapstructure:"polygon" json:"-"` // latitude, longitude pairs
+}
+
+// Fence is an area users are watched entering and leaving
+type Fence struct {
+	Name     string
+	Severity string
+	Dwell    time.Duration // 0 raises no dwell events
+	Text     bool          // send events to the channel as a text message
+	alertOn  []string
+	circle   *circle
+	polygons [][][][2]float64 // polygons of rings of longitude, latitude points; the first ring is the outside, the rest holes
+}
+
+type circle struct {
+	latitude  float64
+	longitude float64
+	radius    float64
+}
+
+// NewFence checks a fence definition
+func NewFence(c FenceConfig) (*Fence, error) {
+	f, err := newFence(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Polygon) > 0 {
+		ring := make([][2]float64, 0, len(c.Polygon))
+		for _, p := range c.Polygon {
+			if len(p) != 2 {
+				return nil, errors.NotValidf("polygon point %v, want latitude and longitude", p)
+			}
+			ring = append(ring, [2]float64{p[1], p[0]})
+		}
+		return f, f.addPolygon([][][2]float64{ring})
+	}
+	return f, f.setCircle(c.Latitude, c.Longitude, c.Radius)
+}
+
+// newFence checks everything but the shape of a fence
+func newFence(c FenceConfig) (*Fence, error) {
+	if c.Name == "" {
+		return nil, errors.New("geofence has no name")
+	}
+	f := &Fence{Name: c.Name, Text: c.Text}
+	if c.Severity != "" {
+		for _, s := range geofenceSeverities {
+			if strings.EqualFold(s, c.Severity) {
+				f.Severity = s
+			}
+		}
+		if f.Severity == "" {
+			return nil, errors.Errorf("unknown alert severity '%s'", c.Severity)
+		}
+	}
+	if c.Dwell != "" {
+		var err error
+		if f.Dwell, err = time.ParseDuration(c.Dwell); err != nil {
+			return nil, errors.Annotate(err, "dwell")
+		}
+	}
+	for _, k := range c.AlertOn {
+		switch k = strings.ToLower(k); k {
+		case GeofenceEnter, GeofenceExit, GeofenceDwell:
+			f.alertOn = append(f.alertOn, k)
+		default:
+			return nil, errors.Errorf("unknown geofence event '%s', use enter, exit or dwell", k)
+		}
+	}
+	return f, nil
+}
+
+func (f *Fence) setCircle(latitude float64, longitude float64, radius float64) error {
+	if err := validPoint(latitude, longitude); err != nil {
+		return err
+	}
+	if radius <= 0 {
+		return errors.NotValidf("radius %g, a circle needs a radius in metres", radius)
+	}
+	f.circle = &circle{latitude: latitude, longitude: longitude, radius: radius}
+	return nil
+}
+
+// addPolygon adds a polygon given as GeoJSON rings of longitude,
+// latitude points, closing rings that are left open
+func (f *Fence) addPolygon(rings [][][2]float64) error {
+	if len(rings) == 0 {
+		return errors.NotValidf("polygon with no rings")
+	}
+	for i, ring := range rings {
+		for _, p := range ring {
+			if err := validPoint(p[1], p[0]); err != nil {
+				return err
+			}
+		}
+		if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+			ring = append(ring, ring[0])
+			rings[i] = ring
+		}
+		if len(ring) < 4 {
+			return errors.NotValidf("polygon ring of %d points, at least 3", len(ring)-1)
+		}
+	}
+	f.polygons = append(f.polygons, rings)
+	return nil
+}
+
+func validPoint(latitude float64, longitude float64) error {
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return errors.NotValidf("point %g,%g", latitude, longitude)
+	}
+	return nil
+}
+
+// alerts reports whether events of this kind are raised
+func (f *Fence) alerts(kind string) bool {
+	if len(f.alertOn) == 0 {
+		return true
+	}
+	for _, k := range f.alertOn {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// depth in metres of a point inside the fence boundary, negative when
+// the point is outside
+func (f *Fence) depth(latitude float64, longitude float64) float64 {
+	if f.circle != nil {
+		return f.circle.radius - distance(f.circle.latitude, f.circle.longitude, latitude, longitude)
+	}
+	inside := false
+	nearest := math.Inf(1)
+	for _, rings := range f.polygons {
+		in := inRing(rings[0], latitude, longitude)
+		for i, ring := range rings {
+			if i > 0 && in && inRing(ring, latitude, longitude) {
+				in = false
+			}
+			nearest = math.Min(nearest, toRing(ring, latitude, longitude))
+		}
+		inside = inside || in
+	}
+	if inside {
+		return nearest
+	}
+	return -nearest
+}
+
+// inRing casts a ray east from the point and counts the edges it crosses
+func inRing(ring [][2]float64, latitude float64, longitude float64) bool {
+	in := false
+	for i := 1; i < len(ring); i++ {
+		a, b := ring[i-1], ring[i]
+		if (a[1] > latitude) != (b[1] > latitude) &&
+			longitude < a[0]+(latitude-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+			in = !in
+		}
+	}
+	return in
+}
+
+// toRing is the distance in metres from a point to the nearest edge of a
+// ring, working on a flat projection centred on the point
+func toRing(ring [][2]float64, latitude float64, longitude float64) float64 {
+	scale := math.Cos(radians(latitude))
+	project := func(p [2]float64) (float64, float64) {
+		return radians(p[0]-longitude) * scale * earthRadius, radians(p[1]-latitude) * earthRadius
+	}
+	nearest := math.Inf(1)
+	for i := 1; i < len(ring); i++ {
+		ax, ay := project(ring[i-1])
+		bx, by := project(ring[i])
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if l := dx*dx + dy*dy; l > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+		}
+		nearest = math.Min(nearest, math.Hypot(ax+t*dx, ay+t*dy))
+	}
+	return nearest
+}
+
+// Geofences tracks which users are inside each fence. A fix only moves a
+// user in or out of a fence when it is further from the boundary than its
+// accuracy plus the hysteresis, so a poor or wandering fix near the edge
+// does not raise a stream of events. The first fix that places a user
+// inside or outside a fence raises no event.
+type Geofences struct {
+	fences     []*Fence
+	hysteresis float64
+	presence   map[string]*presence
+}
+
+type presence struct {
+	fence  *Fence
+	inside bool
+	since  time.Time
+	dwelt  bool
+	last   Fix
+}
+
+// NewGeofences loads the 'location.geofences' config section
+func NewGeofences() (*Geofences, error) {
+	g := &Geofences{
+		hysteresis: viper.GetFloat64("location.geofences.hysteresis"),
+		presence:   make(map[string]*presence),
+	}
+	if g.hysteresis < 0 {
+		return nil, errors.NotValidf("location.geofences.hysteresis %g", g.hysteresis)
+	}
+	var configs []FenceConfig
+	if err := viper.UnmarshalKey("location.geofences.fences", &configs); err != nil {
+		return nil, errors.Annotate(err, "location.geofences.fences")
+	}
+	for i, c := range configs {
+		f, err := NewFence(c)
+		if err != nil {
+			return nil, errors.Annotatef(err, "location.geofences.fences[%d]", i)
+		}
+		g.fences = append(g.fences, f)
+	}
+	if name := viper.GetString("location.geofences.file"); name != "" {
+		fences, err := LoadGeofences(name)
+		if err != nil {
+			return nil, errors.Annotate(err, "location.geofences.file")
+		}
+		g.fences = append(g.fences, fences...)
+	}
+	seen := make(map[string]bool)
+	for _, f := range g.fences {
+		if seen[strings.ToLower(f.Name)] {
+			return nil, errors.Errorf("more than one geofence named '%s'", f.Name)
+		}
+		seen[strings.ToLower(f.Name)] = true
+	}
+	return g, nil
+}
+
+// LoadGeofences reads fences from a GeoJSON feature collection. Polygon
+// and MultiPolygon features are used as they are; a Point feature is a
+// circle and needs a radius property. The other properties are those of
+// FenceConfig.
+func LoadGeofences(fileName string) ([]*Fence, error) {
+	buff, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var collection struct {
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties FenceConfig `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buff, &collection); err != nil {
+		return nil, errors.Annotate(err, fileName)
+	}
+	var fences []*Fence
+	for i, feature := range collection.Features {
+		c := feature.Properties
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("%s[%d]", fileName, i)
+		}
+		f, err := newFence(c)
+		if err == nil {
+			err = f.setShape(feature.Geometry.Type, feature.Geometry.Coordinates, c.Radius)
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "feature %d", i)
+		}
+		fences = append(fences, f)
+	}
+	return fences, nil
+}
+
+// setShape sets the shape of a fence from a GeoJSON geometry
+func (f *Fence) setShape(geometry string, coordinates json.RawMessage, radius float64) error {
+	switch geometry {
+	case "Point":
+		var point [2]float64
+		if err := json.Unmarshal(coordinates, &point); err != nil {
+			return errors.Trace(err)
+		}
+		return f.setCircle(point[1], point[0], radius)
+	case "Polygon":
+		var rings [][][2]float64
+		if err := json.Unmarshal(coordinates, &rings); err != nil {
+			return errors.Trace(err)
+		}
+		return f.addPolygon(rings)
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(coordinates, &polygons); err != nil {
+			return errors.Trace(err)
+		}
+		if len(polygons) == 0 {
+			return errors.NotValidf("MultiPolygon with no polygons")
+		}
+		for _, rings := range polygons {
+			if err := f.addPolygon(rings); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.NotSupportedf("geometry '%s'", geometry)
+}
+
+// Len is the number of fences
+func (g *Geofences) Len() int {
+	return len(g.fences)
+}
+
+// Evaluate moves the user of a fix in and out of the fences, returning
+// the events the fences raise
+func (g *Geofences) Evaluate(fix Fix) []GeofenceEvent {
+	var events []GeofenceEvent
+	margin := fix.Accuracy + g.hysteresis
+	for _, f := range g.fences {
+		key := strings.ToLower(fix.User) + "\x00" + f.Name
+		p := g.presence[key]
+		depth := f.depth(fix.Latitude, fix.Longitude)
+		var inside bool
+		switch {
+		case depth >= margin:
+			inside = true
+		case -depth >= margin:
+			inside = false
+		default:
+			if p != nil {
+				p.last = fix
+			}
+			continue
+		}
+		if p == nil {
+			g.presence[key] = &presence{fence: f, inside: inside, since: fix.Time, last: fix}
+			continue
+		}
+		p.last = fix
+		if inside == p.inside {
+			events = g.dwell(events, f, p, fix.Time)
+			continue
+		}
+		kind := GeofenceEnter
+		if !inside {
+			kind = GeofenceExit
+		}
+		events = g.raise(events, f, p, kind, fix.Time)
+		p.inside, p.since, p.dwelt = inside, fix.Time, false
+	}
+	return events
+}
+
+// CheckDwell returns dwell events for users who have stayed inside a
+// fence since their last fix
+func (g *Geofences) CheckDwell(now time.Time) []GeofenceEvent {
+	var events []GeofenceEvent
+	for _, p := range g.presence {
+		events = g.dwell(events, p.fence, p, now)
+	}
+	return events
+}
+
+func (g *Geofences) dwell(events []GeofenceEvent, f *Fence, p *presence, now time.Time) []GeofenceEvent {
+	if f.Dwell <= 0 || !p.inside || p.dwelt || now.Sub(p.since) < f.Dwell {
+		return events
+	}
+	p.dwelt = true
+	return g.raise(events, f, p, GeofenceDwell, now)
+}
+
+func (g *Geofences) raise(events []GeofenceEvent, f *Fence, p *presence, kind string, now time.Time) []GeofenceEvent {
+	if !f.alerts(kind) {
+		return events
+	}
+	e := GeofenceEvent{
+		Time:        now.UTC(),
+		Kind:        kind,
+		Fence:       f.Name,
+		Severity:    f.Severity,
+		User:        p.last.User,
+		Channel:     p.last.Channel,
+		Latitude:    p.last.Latitude,
+		Longitude:   p.last.Longitude,
+		Accuracy:    p.last.Accuracy,
+		Place:       p.last.Place,
+		Coordinates: Convert(p.last.Latitude, p.last.Longitude),
+		sendText:    f.Text,
+	}
+	if kind != GeofenceEnter {
+		e.Inside = now.Sub(p.since)
+	}
+	return append(events, e)
+}
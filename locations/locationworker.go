@@ -6,26 +6,35 @@ package locations
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
+	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/heard"
 	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
 	"github.com/jcmurray/monitor/protocolapp"
-	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/sequence"
+	"github.com/jcmurray/monitor/texts"
 	"github.com/jcmurray/monitor/worker"
-	w3w "github.com/jcmurray/what3words"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-const ()
+const (
+	trackPruneInterval = time.Hour
+)
 
 // LocationWorker stream worker
 type LocationWorker struct {
 	sync.Mutex
-	command chan int
-	log     *log.Entry
-	id      int
-	label   string
-	workers *worker.Workers
+	command   chan int
+	log       *log.Entry
+	id        int
+	label     string
+	workers   *worker.Workers
+	pending   map[int]chan error
+	beaconSeq int
+	tracks    *TrackStore
 }
 
 // NewLocationWorker create a new LocationWorker
@@ -36,6 +45,7 @@ func NewLocationWorker(workers *worker.Workers, id int, label string) *LocationW
 		label:   label,
 		log:     log.WithFields(log.Fields{"Label": label, "ID": id}),
 		workers: workers,
+		pending: make(map[int]chan error),
 	}
 }
 
@@ -47,13 +57,67 @@ func (w *LocationWorker) Run(wg *sync.WaitGroup, term *chan int) {
 	nw := w.findNetWorker()
 	locationChannel := nw.Subscribe(w.id, protocolapp.OnLocationEvent, w.label).Channel
 	errorChannel := nw.Subscribe(w.id, protocolapp.OnErrorEvent, w.label).Channel
+	responseChannel := nw.Subscribe(w.id, protocolapp.OnResponseEvent, w.label).Channel
+
+	beacon, err := beaconFromConfig()
+	if err != nil {
+		w.log.Errorf("Location configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+	tracks, err := OpenTrackStore(viper.GetString("location.tracks.file"), viper.GetDuration("location.tracks.retention"))
+	if err != nil {
+		w.log.Errorf("Location track error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+	w.Lock()
+	w.tracks = tracks
+	w.Unlock()
+	prune := time.NewTicker(trackPruneInterval)
+	defer prune.Stop()
+
+	geocoder, err := NewGeocoder()
+	if err != nil {
+		w.log.Errorf("Location configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+	if geocoder != nil {
+		w.log.Infof("Naming positions with %s", geocoder.Name())
+	}
+
+	geofences, err := NewGeofences()
+	if err != nil {
+		w.log.Errorf("Location configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+	var dwellCheck <-chan time.Time
+	if geofences.Len() > 0 {
+		w.log.Infof("Watching %d geofences", geofences.Len())
+		ticker := time.NewTicker(geofenceDwellCheck)
+		defer ticker.Stop()
+		dwellCheck = ticker.C
+	}
+
+	var beaconTimer <-chan time.Time
+	if beacon != nil {
+		w.log.Infof("Sending position Lat: %.7f Lon: %.7f every %s", beacon.Position.Latitude, beacon.Position.Longitude, beacon.Interval)
+		beaconTimer = time.After(beaconStartDelay)
+	}
 
 waitloop:
 	for {
 		w.log.Debugf("Entering Select")
 		select {
 		case errorMessage := <-errorChannel:
-			w.log.Debugf("Response: %s", errorcodes.Description(string(errorMessage.([]byte))))
+			e, err := errorcodes.FromMessage(errorMessage.([]byte))
+			if err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.onError(e)
 
 		case locationMessage := <-locationChannel:
 
@@ -64,19 +128,85 @@ waitloop:
 				continue
 			}
 
-			w.log.Infof("Location message (ID: %d) on channel '%s' from '%s' for '%s'", c.MessageID, c.Channel, c.From, c.For)
+			heard.Located(c.From, c.Channel, heard.Position{
+				Latitude:  c.Latitude,
+				Longitude: c.Longitude,
+				Accuracy:  c.Accuracy,
+				Address:   c.FormattedAddress,
+			})
+			var place string
+			if geocoder != nil {
+				if place, err = geocoder.Geocode(c.Latitude, c.Longitude); err != nil {
+					w.log.Warnf("Location message (ID: %d) %s lookup failed: %s", c.MessageID, geocoder.Name(), err)
+				}
+			}
+			fix, err := tracks.Add(Fix{
+				Time:      time.Now(),
+				User:      c.From,
+				Channel:   c.Channel,
+				Latitude:  c.Latitude,
+				Longitude: c.Longitude,
+				Accuracy:  c.Accuracy,
+				Address:   c.FormattedAddress,
+				Place:     place,
+			})
+			if err != nil {
+				w.log.Errorf("Location track error: %s", err)
+			}
+			w.geofence(nw, geofences.Evaluate(fix))
+
+			if private.IsPrivate(c.For) {
+				private.Log(w.log, "Private location message (ID: %d) on channel '%s' from '%s' for '%s'", c.MessageID, c.Channel, c.From, c.For)
+				private.Notify(nw, private.Received(private.KindLocation, c.Channel, c.From, c.For, c.MessageID, c.FormattedAddress))
+			} else {
+				w.log.Infof("Location message (ID: %d) on channel '%s' from '%s' for '%s'", c.MessageID, c.Channel, c.From, c.For)
+			}
 			w.log.Infof("Location message (ID: %d) Lat: %.7f Lon: %.7f Accuracy: %.f m", c.MessageID, c.Latitude, c.Longitude, c.Accuracy)
 			w.log.Infof("Location message (ID: %d) Address: %s", c.MessageID, c.FormattedAddress)
+			coords := Convert(c.Latitude, c.Longitude)
+			w.log.Infof("Location message (ID: %d) DMS: %s Grid: %s MGRS: %s", c.MessageID, coords.DMS, coords.Maidenhead, coords.MGRS)
+			if place != "" {
+				w.log.Infof("Location message (ID: %d) %s: %s", c.MessageID, geocoder.Name(), place)
+			}
+			if fix.Distance > 0 {
+				w.log.Infof("Location message (ID: %d) Moved: %.f m Speed: %.1f km/h Heading: %.f", c.MessageID, fix.Distance, fix.Speed*3.6, fix.Heading)
+			}
 
-			var what3WordsAddress string
+		case response := <-responseChannel:
+			resp := protocolapp.NewResponse()
+			if err := json.Unmarshal(response.([]byte), resp); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			if !sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.LocationSendRequest) {
+				continue
+			}
+			sequence.RemoveEntry(w.id, resp.Seq)
+			err := errorcodes.FromResponse(resp, protocolapp.LocationSendRequest)
+			w.Lock()
+			c, ok := w.pending[resp.Seq]
+			delete(w.pending, resp.Seq)
+			w.Unlock()
+			switch {
+			case ok && c != nil:
+				c <- err
+			case err != nil:
+				w.log.Errorf("Error response to Location: %s", err)
+			default:
+				w.log.Infof("Successful response to Location")
+			}
 
-			if viper.GetBool("location.what3words") {
-				what3WordsAddress, err = w.what3WordsFromLatLon(c.Latitude, c.Longitude)
-				if err == nil {
-					log.Infof("Location message (ID: %d) What3WordsAddress: ///%s", c.MessageID, what3WordsAddress)
-				}
+		case <-prune.C:
+			if err := tracks.Prune(time.Now()); err != nil {
+				w.log.Errorf("Location track error: %s", err)
 			}
 
+		case now := <-dwellCheck:
+			w.geofence(nw, geofences.CheckDwell(now))
+
+		case <-beaconTimer:
+			beaconTimer = time.After(w.sendBeacon(beacon))
+
 		case textMessageCommand, more := <-w.command:
 			if more {
 				w.log.Debugf("Received command %d", textMessageCommand)
@@ -94,9 +224,18 @@ waitloop:
 		}
 	}
 
+	nw.UnSubscribe(w.id, protocolapp.OnResponseEvent)
 	nw.UnSubscribe(w.id, protocolapp.OnLocationEvent)
 	nw.UnSubscribe(w.id, protocolapp.OnErrorEvent)
 
+	if err := tracks.Close(); err != nil {
+		w.log.Errorf("Location track error: %s", err)
+	}
+	if cache, ok := geocoder.(*CachedGeocoder); ok {
+		hits, misses := cache.Stats()
+		w.log.Infof("Geocoder cache answered %d of %d lookups", hits, hits+misses)
+	}
+
 	w.log.Debug("Finished")
 }
 
@@ -110,6 +249,33 @@ func (w *LocationWorker) Terminate() {
 	w.Command(worker.Terminate)
 }
 
+// Tracks returns the location tracks, nil until the worker has started
+func (w *LocationWorker) Tracks() *TrackStore {
+	w.Lock()
+	defer w.Unlock()
+	return w.tracks
+}
+
+// geofence logs and publishes geofence events, and sends those of
+// fences that ask for it to the channel
+func (w *LocationWorker) geofence(nw *network.Networker, events []GeofenceEvent) {
+	for _, e := range events {
+		w.log.Infof("Geofence %s: %s", e.Kind, e)
+		buff, err := json.Marshal(e)
+		if err != nil {
+			w.log.Errorf("Marshal error: %s", err)
+			continue
+		}
+		nw.Publish(SubscriptionTypeGeofence, buff)
+		if !e.sendText {
+			continue
+		}
+		if tmw := w.findTextWorker(); tmw != nil {
+			tmw.Enqueue(protocolapp.InternalTextMessageRequest{Message: "Geofence: " + e.String()})
+		}
+	}
+}
+
 // FindNetWorker find Net worker
 func (w *LocationWorker) findNetWorker() *network.Networker {
 	for i := range *w.workers {
@@ -121,17 +287,15 @@ func (w *LocationWorker) findNetWorker() *network.Networker {
 	return nil
 }
 
-func (w *LocationWorker) what3WordsFromLatLon(lat float64, lon float64) (string, error) {
-	api := w3w.NewGeocoder(viper.GetString("location.what3wordsapikey"))
-	coords, err := w3w.NewCoordinates(lat, lon)
-	if err != nil {
-		return "", err
-	}
-	resp, err := api.ConvertTo3wa(coords)
-	if err != nil {
-		return "", err
+// findTextWorker find Text Message worker
+func (w *LocationWorker) findTextWorker() *texts.TextMessageWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *texts.TextMessageWorker:
+			return (*w.workers)[i].(*texts.TextMessageWorker)
+		}
 	}
-	return resp.Words, nil
+	return nil
 }
 
 // Label return label of worker
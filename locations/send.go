@@ -0,0 +1,185 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package locations
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/heard"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/sequence"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	responseTimeout     = 10 * time.Second
+	beaconStartDelay    = 10 * time.Second
+	beaconRetryInterval = 30 * time.Second
+)
+
+// ErrNoResponse Zello did not answer a send_location request in time
+var ErrNoResponse = errors.New("no response to send location")
+
+// Beacon is a fixed position sent periodically, so that a stationary
+// base shows on members' maps
+type Beacon struct {
+	Position heard.Position
+	For      string
+	Interval time.Duration
+}
+
+// beaconFromConfig reads the 'location.beacon' config section. It
+// returns nil when no interval is set.
+func beaconFromConfig() (*Beacon, error) {
+	interval := viper.GetDuration("location.beacon.interval")
+	if interval == 0 {
+		return nil, nil
+	}
+	if interval < time.Minute {
+		return nil, errors.Errorf("location.beacon.interval %s must be at least 1m", interval)
+	}
+	b := &Beacon{
+		Position: heard.Position{
+			Latitude:  viper.GetFloat64("location.beacon.latitude"),
+			Longitude: viper.GetFloat64("location.beacon.longitude"),
+			Accuracy:  viper.GetFloat64("location.beacon.accuracy"),
+			Address:   viper.GetString("location.beacon.address"),
+		},
+		For:      viper.GetString("location.beacon.for"),
+		Interval: interval,
+	}
+	if err := validPosition(b.Position); err != nil {
+		return nil, errors.Annotate(err, "location.beacon")
+	}
+	return b, nil
+}
+
+// validPosition checks a position can be sent
+func validPosition(p heard.Position) error {
+	switch {
+	case math.IsNaN(p.Latitude) || p.Latitude < -90 || p.Latitude > 90:
+		return errors.NotValidf("latitude %g", p.Latitude)
+	case math.IsNaN(p.Longitude) || p.Longitude < -180 || p.Longitude > 180:
+		return errors.NotValidf("longitude %g", p.Longitude)
+	case math.IsNaN(p.Accuracy) || p.Accuracy < 0:
+		return errors.NotValidf("accuracy %g", p.Accuracy)
+	}
+	return nil
+}
+
+// Send a position to the channel, or privately to forUser, and wait for
+// Zello to accept it
+func (w *LocationWorker) Send(p heard.Position, forUser string) error {
+	if err := validPosition(p); err != nil {
+		return err
+	}
+	if err := capabilities.CheckLocation(); err != nil {
+		return err
+	}
+
+	response := make(chan error, 1)
+	seq, err := w.send(p, forUser, response)
+	if err != nil {
+		return err
+	}
+	timeout := time.NewTimer(responseTimeout)
+	defer timeout.Stop()
+	select {
+	case err := <-response:
+		return err
+	case <-timeout.C:
+		w.forget(seq)
+		return errors.Annotatef(ErrNoResponse, "within %s", responseTimeout)
+	}
+}
+
+// send a send_location request. The error from its response, nil for
+// success, is given to response unless that is nil.
+func (w *LocationWorker) send(p heard.Position, forUser string, response chan error) (int, error) {
+	location := protocolapp.NewSendLocation()
+	location.Seq = sequence.GetNextSequenceNumber(w.id, protocolapp.LocationSendRequest)
+	location.Latitude = p.Latitude
+	location.Longitude = p.Longitude
+	location.Accuracy = p.Accuracy
+	location.FormattedAddress = p.Address
+	location.For = forUser
+
+	buff, err := json.Marshal(location)
+	if err != nil {
+		sequence.RemoveEntry(w.id, location.Seq)
+		return 0, errors.Annotate(err, "Marshal failure for Zello send location request")
+	}
+
+	w.Lock()
+	w.pending[location.Seq] = response
+	w.Unlock()
+
+	w.log.Tracef("Sending: %s", buff)
+	w.findNetWorker().Data(buff)
+	return location.Seq, nil
+}
+
+// onError reacts to an on_error event. Positions waiting for a response
+// will not get one once the session ends, so their senders are told now.
+func (w *LocationWorker) onError(e *errorcodes.Error) {
+	reaction := errorcodes.React(e)
+	w.log.Debugf("Error: %s (%s, %s)", e.Description, e.Category, reaction)
+	if reaction != errorcodes.ReactReset {
+		return
+	}
+	w.Lock()
+	pending := w.pending
+	w.pending = make(map[int]chan error)
+	w.Unlock()
+	for seq, c := range pending {
+		sequence.RemoveEntry(w.id, seq)
+		if c != nil {
+			c <- e
+		}
+	}
+	w.beaconSeq = 0
+}
+
+// forget a request whose response is no longer wanted, returning
+// whether it was still waiting
+func (w *LocationWorker) forget(seq int) bool {
+	w.Lock()
+	_, ok := w.pending[seq]
+	delete(w.pending, seq)
+	w.Unlock()
+	sequence.RemoveEntry(w.id, seq)
+	return ok
+}
+
+// sendBeacon sends the fixed position and returns when to send it next,
+// sooner while the channel is not yet online
+func (w *LocationWorker) sendBeacon(b *Beacon) time.Duration {
+	if w.beaconSeq != 0 && w.forget(w.beaconSeq) {
+		w.log.Warnf("No response to the previous position sent")
+	}
+	w.beaconSeq = 0
+
+	if err := capabilities.CheckLocation(); err != nil {
+		if e, ok := err.(*capabilities.Error); ok && (e.Kind == capabilities.KindUnknown || e.Kind == capabilities.KindOffline) {
+			w.log.Debugf("Position not sent yet: %s", err)
+			return beaconRetryInterval
+		}
+		w.log.Warnf("Position not sent: %s", err)
+		return b.Interval
+	}
+
+	seq, err := w.send(b.Position, b.For, nil)
+	if err != nil {
+		w.log.Errorf("Error on sending position: %s", err)
+		return b.Interval
+	}
+	w.beaconSeq = seq
+	w.log.Infof("Position Lat: %.7f Lon: %.7f sent for '%s'", b.Position.Latitude, b.Position.Longitude, b.For)
+	return b.Interval
+}
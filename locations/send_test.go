@@ -0,0 +1,39 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package locations
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/worker"
+)
+
+func TestSessionErrorFailsPendingSends(t *testing.T) {
+	var workers worker.Workers
+	w := NewLocationWorker(&workers, 2, "Location Worker")
+	response := make(chan error, 1)
+	w.pending[7] = response
+
+	// A transient error leaves the send waiting for its response
+	w.onError(errorcodes.New("failed to send data", ""))
+	if len(w.pending) != 1 {
+		t.Fatal("pending send dropped on a transient error")
+	}
+
+	// The session is ending, so no response will come
+	w.onError(errorcodes.New("not authorized", ""))
+	if len(w.pending) != 0 {
+		t.Errorf("%d sends still pending", len(w.pending))
+	}
+	select {
+	case err := <-response:
+		if !errors.Is(err, errorcodes.ErrNotAuthorized) {
+			t.Errorf("send failed with %v", err)
+		}
+	default:
+		t.Error("sender not told")
+	}
+}
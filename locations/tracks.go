@@ -0,0 +1,309 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package locations
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// earthRadius is the mean radius of the Earth in metres
+const earthRadius = 6371008.8
+
+// Fix is one position reported by a user, with the distance, speed and
+// heading from that user's previous fix. The first fix of a user has
+// none.
+type Fix struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Channel   string    `json:"channel,omitempty"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Accuracy  float64   `json:"accuracy,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	Place     string    `json:"place,omitempty"` // from the geocoder, such as a what3words address
+	Distance  float64   `json:"distance"`        // metres
+	Speed     float64   `json:"speed"`           // metres a second
+	Heading   float64   `json:"heading"`         // degrees clockwise from true north
+}
+
+// Track is the fixes of one user, oldest first
+type Track struct {
+	User  string
+	Fixes []Fix
+}
+
+// Distance travelled in metres between the first and last fix
+func (t Track) Distance() float64 {
+	var d float64
+	for i := 1; i < len(t.Fixes); i++ {
+		d += t.Fixes[i].Distance
+	}
+	return d
+}
+
+// Duration between the first and last fix
+func (t Track) Duration() time.Duration {
+	if len(t.Fixes) == 0 {
+		return 0
+	}
+	return t.Fixes[len(t.Fixes)-1].Time.Sub(t.Fixes[0].Time)
+}
+
+// AverageSpeed in metres a second between the first and last fix
+func (t Track) AverageSpeed() float64 {
+	if d := t.Duration(); d > 0 {
+		return t.Distance() / d.Seconds()
+	}
+	return 0
+}
+
+// MaxSpeed in metres a second between any two fixes
+func (t Track) MaxSpeed() float64 {
+	var s float64
+	for i := 1; i < len(t.Fixes); i++ {
+		s = math.Max(s, t.Fixes[i].Speed)
+	}
+	return s
+}
+
+// TrackQuery selects fixes from a TrackStore. Empty fields match
+// everything.
+type TrackQuery struct {
+	User  string
+	Since time.Time
+	Until time.Time
+}
+
+// TrackStore keeps the fixes of every user in memory and in an
+// append-only JSON lines file
+type TrackStore struct {
+	sync.Mutex
+	fileName  string
+	file      *os.File
+	retention time.Duration
+	fixes     map[string][]Fix
+}
+
+// OpenTrackStore loads the fixes kept in fileName, dropping those older
+// than retention. An empty fileName keeps the tracks in memory only and
+// a zero retention keeps fixes for ever.
+func OpenTrackStore(fileName string, retention time.Duration) (*TrackStore, error) {
+	s := &TrackStore{
+		fileName:  fileName,
+		retention: retention,
+		fixes:     make(map[string][]Fix),
+	}
+	if fileName == "" {
+		return s, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.Prune(time.Now()); err != nil {
+		return nil, err
+	}
+	if s.file == nil {
+		f, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Annotate(err, "open track file")
+		}
+		s.file = f
+	}
+	return s, nil
+}
+
+func (s *TrackStore) load() error {
+	f, err := os.Open(s.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Annotate(err, "open track file")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var fix Fix
+		if err := json.Unmarshal(scanner.Bytes(), &fix); err != nil {
+			return errors.Annotatef(err, "track file %s line %d", s.fileName, line)
+		}
+		key := strings.ToLower(fix.User)
+		s.fixes[key] = append(s.fixes[key], fix)
+	}
+	return errors.Annotate(scanner.Err(), "read track file")
+}
+
+// Add a fix, working out the distance, speed and heading from the user's
+// previous fix, and write it to the file
+func (s *TrackStore) Add(fix Fix) (Fix, error) {
+	s.Lock()
+	defer s.Unlock()
+	fix.Time = fix.Time.UTC()
+	key := strings.ToLower(fix.User)
+	if fixes := s.fixes[key]; len(fixes) > 0 {
+		previous := fixes[len(fixes)-1]
+		fix.Distance = distance(previous.Latitude, previous.Longitude, fix.Latitude, fix.Longitude)
+		if fix.Distance > 0 {
+			fix.Heading = bearing(previous.Latitude, previous.Longitude, fix.Latitude, fix.Longitude)
+		}
+		if elapsed := fix.Time.Sub(previous.Time); elapsed > 0 {
+			fix.Speed = fix.Distance / elapsed.Seconds()
+		}
+	}
+	s.fixes[key] = append(s.fixes[key], fix)
+	if s.file == nil {
+		return fix, nil
+	}
+	buff, err := json.Marshal(fix)
+	if err != nil {
+		return fix, errors.Annotate(err, "marshal fix")
+	}
+	_, err = s.file.Write(append(buff, '\n'))
+	return fix, errors.Annotate(err, "write track file")
+}
+
+// Prune drops fixes older than the retention period and rewrites the
+// file without them
+func (s *TrackStore) Prune(now time.Time) error {
+	if s.retention <= 0 {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	cutoff := now.Add(-s.retention)
+	pruned := false
+	for key, fixes := range s.fixes {
+		keep := sort.Search(len(fixes), func(i int) bool {
+			return !fixes[i].Time.Before(cutoff)
+		})
+		switch {
+		case keep == 0:
+			continue
+		case keep == len(fixes):
+			delete(s.fixes, key)
+		default:
+			s.fixes[key] = append([]Fix(nil), fixes[keep:]...)
+		}
+		pruned = true
+	}
+	if !pruned || s.fileName == "" {
+		return nil
+	}
+	return s.rewrite()
+}
+
+// rewrite the file from the fixes in memory, oldest first
+func (s *TrackStore) rewrite() error {
+	var all []Fix
+	for _, fixes := range s.fixes {
+		all = append(all, fixes...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Time.Before(all[j].Time) })
+
+	temp := s.fileName + ".tmp"
+	f, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Annotate(err, "rewrite track file")
+	}
+	encoder := json.NewEncoder(f)
+	for _, fix := range all {
+		if err := encoder.Encode(fix); err != nil {
+			f.Close()
+			return errors.Annotate(err, "rewrite track file")
+		}
+	}
+	if err := f.Close(); err != nil {
+		return errors.Annotate(err, "rewrite track file")
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if err := os.Rename(temp, s.fileName); err != nil {
+		return errors.Annotate(err, "rewrite track file")
+	}
+	s.file, err = os.OpenFile(s.fileName, os.O_APPEND|os.O_WRONLY, 0600)
+	return errors.Annotate(err, "open track file")
+}
+
+// Query returns the track of each matching user, ordered by user. The
+// distance, speed and heading of the first fix in each track are from a
+// fix before the query, if there was one.
+func (s *TrackStore) Query(q TrackQuery) []Track {
+	s.Lock()
+	defer s.Unlock()
+
+	var tracks []Track
+	for key, fixes := range s.fixes {
+		if q.User != "" && key != strings.ToLower(q.User) {
+			continue
+		}
+		from := 0
+		if !q.Since.IsZero() {
+			from = sort.Search(len(fixes), func(i int) bool { return !fixes[i].Time.Before(q.Since) })
+		}
+		to := len(fixes)
+		if !q.Until.IsZero() {
+			to = sort.Search(len(fixes), func(i int) bool { return !fixes[i].Time.Before(q.Until) })
+		}
+		if from >= to {
+			continue
+		}
+		t := Track{User: fixes[to-1].User, Fixes: append([]Fix(nil), fixes[from:to]...)}
+		tracks = append(tracks, t)
+	}
+	sort.Slice(tracks, func(i, j int) bool {
+		return strings.ToLower(tracks[i].User) < strings.ToLower(tracks[j].User)
+	})
+	return tracks
+}
+
+// Close the track file
+func (s *TrackStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// distance in metres between two points on the great circle
+func distance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	phi1, phi2 := radians(lat1), radians(lat2)
+	dPhi, dLambda := radians(lat2-lat1), radians(lon2-lon1)
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// bearing in degrees clockwise from true north at the first point
+func bearing(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	phi1, phi2 := radians(lat1), radians(lat2)
+	dLambda := radians(lon2 - lon1)
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	return math.Mod(degrees(math.Atan2(y, x))+360, 360)
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
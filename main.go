@@ -4,22 +4,28 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jcmurray/monitor/alerts"
 	"github.com/jcmurray/monitor/audiodecoder"
 	"github.com/jcmurray/monitor/authenticate"
 	"github.com/jcmurray/monitor/channelstatus"
 	"github.com/jcmurray/monitor/clientrpc"
+	"github.com/jcmurray/monitor/ctl"
 	"github.com/jcmurray/monitor/images"
 	"github.com/jcmurray/monitor/locations"
 	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/schedule"
+	"github.com/jcmurray/monitor/secrets"
 	"github.com/jcmurray/monitor/streams"
 	"github.com/jcmurray/monitor/texts"
 	"github.com/jcmurray/monitor/util"
+	"github.com/jcmurray/monitor/voice"
 	"github.com/jcmurray/monitor/worker"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
@@ -34,7 +40,7 @@ var (
 
 func init() {
 	clog := new(logrus.TextFormatter)
-	log.SetFormatter(clog)
+	log.SetFormatter(&secrets.RedactingFormatter{Formatter: clog})
 	clog.TimestampFormat = "2006-01-02 15:04:05"
 	clog.FullTimestamp = true
 	log.SetLevel(log.DebugLevel)
@@ -42,6 +48,10 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl.Run(os.Args[2:]))
+	}
+
 	var (
 		done             chan struct{}
 		terminateRequest chan int
@@ -57,9 +67,13 @@ func main() {
 
 	var config string
 	var loglevel string
+	var generateKey bool
+	var encrypt string
 
 	pflag.StringVarP(&config, "config", "c", "config", "Name of configuration to use, without the .yaml or .json etc. suffix")
 	pflag.StringVarP(&loglevel, "loglevel", "l", "info", "Log level to set: info, warn, error, debug, trace, fatal, panic")
+	pflag.BoolVar(&generateKey, "generate-key", false, "Create the key file named by secrets.keyfile and exit")
+	pflag.StringVar(&encrypt, "encrypt", "", "Print an enc: reference for this value, using the key in secrets.keyfile, and exit")
 	pflag.Parse()
 
 	viper.SetConfigName(config)
@@ -71,9 +85,20 @@ func main() {
 	viper.SetDefault("server.host", util.DefaultHostname)
 	viper.SetDefault("server.port", util.DefaultPort)
 	viper.SetDefault("log.listen_only", util.DefaultListenOnly)
+	viper.SetDefault("logon.token_lifetime", util.DefaultTokenLifetime)
+	viper.SetDefault("logon.token_refresh_before", util.DefaultTokenRefreshBefore)
+	viper.SetDefault("logon.state_file", util.DefaultStateFile)
 
 	viper.SetDefault("location.what3wordsapikey", util.DefaulW3WAPIKey)
 	viper.SetDefault("location.what3words", util.DefaultUseW3W)
+	viper.SetDefault("location.offline", util.DefaultLocationOffline)
+	viper.SetDefault("location.geocoder", util.DefaultLocationGeocoder)
+	viper.SetDefault("location.geocoder_cache.size", util.DefaultLocationGeocoderCacheSize)
+	viper.SetDefault("location.geocoder_cache.ttl", util.DefaultLocationGeocoderCacheTTL)
+	viper.SetDefault("location.beacon.interval", util.DefaultLocationBeaconInterval)
+	viper.SetDefault("location.tracks.file", util.DefaultLocationTracksFile)
+	viper.SetDefault("location.tracks.retention", util.DefaultLocationTracksRetention)
+	viper.SetDefault("location.geofences.hysteresis", util.DefaultLocationGeofenceHysteresis)
 
 	viper.SetDefault("image.logging", util.DefaultImageLogging)
 
@@ -83,6 +108,53 @@ func main() {
 	viper.SetDefault("audio.channels", util.DefaultChannels)
 	viper.SetDefault("audio.framesperpacket", util.DefaultFramesPerPacket)
 
+	viper.SetDefault("network.reconnect.initial_interval", util.DefaultReconnectInitialInterval)
+	viper.SetDefault("network.reconnect.max_interval", util.DefaultReconnectMaxInterval)
+	viper.SetDefault("network.reconnect.multiplier", util.DefaultReconnectMultiplier)
+	viper.SetDefault("network.reconnect.jitter", util.DefaultReconnectJitter)
+	viper.SetDefault("network.reconnect.max_attempts", util.DefaultReconnectMaxAttempts)
+	viper.SetDefault("network.reconnect.reset_after", util.DefaultReconnectResetAfter)
+	viper.SetDefault("network.closecodes.default", util.DefaultCloseCodeAction)
+	viper.SetDefault("network.heartbeat.interval", util.DefaultHeartbeatInterval)
+	viper.SetDefault("network.heartbeat.timeout", util.DefaultHeartbeatTimeout)
+	viper.SetDefault("network.capture.format", util.DefaultCaptureFormat)
+	viper.SetDefault("network.replay.speed", util.DefaultReplaySpeed)
+
+	viper.SetDefault("channel.policy.closed", util.DefaultChannelClosedAction)
+	viper.SetDefault("channel.policy.blocked", util.DefaultChannelBlockedAction)
+	viper.SetDefault("channel.policy.error", util.DefaultChannelErrorAction)
+	viper.SetDefault("channel.history.size", util.DefaultChannelHistorySize)
+
+	viper.SetDefault("secrets.keyfile", util.DefaultSecretsKeyFile)
+
+	viper.SetDefault("texts.history.file", util.DefaultTextHistoryFile)
+	viper.SetDefault("texts.history.retention", util.DefaultTextHistoryRetention)
+	viper.SetDefault("texts.commands.enabled", util.DefaultTextCommandsEnabled)
+	viper.SetDefault("texts.commands.prefix", util.DefaultTextCommandsPrefix)
+	viper.SetDefault("texts.commands.reply", util.DefaultTextCommandsReply)
+	viper.SetDefault("texts.commands.rate_limit", util.DefaultTextCommandsRateLimit)
+	viper.SetDefault("texts.commands.rate_window", util.DefaultTextCommandsRateWindow)
+	viper.SetDefault("texts.outbound.max_length", util.DefaultTextOutboundMaxLength)
+	viper.SetDefault("texts.outbound.interval", util.DefaultTextOutboundInterval)
+	viper.SetDefault("texts.outbound.burst", util.DefaultTextOutboundBurst)
+	viper.SetDefault("texts.outbound.recipient_interval", util.DefaultTextOutboundRecipientInterval)
+	viper.SetDefault("texts.outbound.recipient_burst", util.DefaultTextOutboundRecipientBurst)
+
+	viper.SetDefault("alerts.journal", util.DefaultAlertJournal)
+	viper.SetDefault("alerts.dedup_window", util.DefaultAlertDedupWindow)
+	viper.SetDefault("alerts.webhook_timeout", util.DefaultAlertWebhookTimeout)
+
+	viper.SetDefault("schedule.file", util.DefaultScheduleFile)
+
+	viper.SetDefault("voice.quiet_period", util.DefaultVoiceQuietPeriod)
+	viper.SetDefault("voice.max_wait", util.DefaultVoiceMaxWait)
+
+	viper.SetDefault("private.log_level", util.DefaultPrivateLogLevel)
+	viper.SetDefault("private.image_directory", util.DefaultPrivateImageDirectory)
+	viper.SetDefault("private.play_audio", util.DefaultPrivatePlayAudio)
+	viper.SetDefault("private.conversations", util.DefaultPrivateConversations)
+	viper.SetDefault("private.alert_severity", util.DefaultPrivateAlertSeverity)
+
 	viper.SetDefault("rpc.apienabled", util.DefaultRPCServerEnabled)
 	viper.SetDefault("rpc.apiport", util.DefaultRPCServerPort)
 
@@ -90,6 +162,25 @@ func main() {
 		mlog.Fatalf("Config file error: %s", err)
 	}
 
+	if generateKey {
+		if err := secrets.GenerateKey(viper.GetString("secrets.keyfile")); err != nil {
+			mlog.Fatalf("Key generation error: %s", err)
+		}
+		mlog.Infof("Key written to %s", viper.GetString("secrets.keyfile"))
+		return
+	}
+	if encrypt != "" {
+		reference, err := secrets.Encrypt(encrypt, viper.GetString("secrets.keyfile"))
+		if err != nil {
+			mlog.Fatalf("Encryption error: %s", err)
+		}
+		fmt.Println(reference)
+		return
+	}
+	if err := secrets.ResolveConfig(); err != nil {
+		mlog.Fatalf("Config secret error: %s", err)
+	}
+
 	configLogLevel := viper.GetString("loglevel")
 	foundLogLevel := false
 	for i := range util.LogLevelStrings {
@@ -158,6 +249,21 @@ func main() {
 	waitGroup.Add(1)
 	go textworker.Run(&waitGroup, &terminateRequest)
 
+	alertworker := alerts.NewAlertWorker(&workers, util.NewID(workers), "Alert Worker")
+	workers = append(workers, alertworker)
+	waitGroup.Add(1)
+	go alertworker.Run(&waitGroup, &terminateRequest)
+
+	voiceworker := voice.NewVoiceWorker(&workers, util.NewID(workers), "Voice Worker")
+	workers = append(workers, voiceworker)
+	waitGroup.Add(1)
+	go voiceworker.Run(&waitGroup, &terminateRequest)
+
+	schedulerworker := schedule.NewSchedulerWorker(&workers, util.NewID(workers), "Scheduler Worker")
+	workers = append(workers, schedulerworker)
+	waitGroup.Add(1)
+	go schedulerworker.Run(&waitGroup, &terminateRequest)
+
 	locationworker := locations.NewLocationWorker(&workers, util.NewID(workers), "Location Worker")
 	workers = append(workers, locationworker)
 	waitGroup.Add(1)
@@ -192,8 +298,14 @@ waitLoop:
 			mlog.Debug("Terminated audioworker")
 			imageworker.Terminate()
 			mlog.Debug("Terminated imageworker")
+			schedulerworker.Terminate()
+			mlog.Debug("Terminated schedulerworker")
+			voiceworker.Terminate()
+			mlog.Debug("Terminated voiceworker")
 			textworker.Terminate()
 			mlog.Debug("Terminated textworker")
+			alertworker.Terminate()
+			mlog.Debug("Terminated alertworker")
 			locationworker.Terminate()
 			mlog.Debug("Terminated locationworker")
 			statusworker.Terminate()
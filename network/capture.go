@@ -0,0 +1,357 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jcmurray/monitor/secrets"
+	"github.com/juju/errors"
+)
+
+// Capture formats and frame directions
+const (
+	CaptureFormatJSONL  = "jsonl"
+	CaptureFormatBinary = "binary"
+	DirectionIn         = "in"
+	DirectionOut        = "out"
+	FrameTypeText       = "text"
+	FrameTypeBinary     = "binary"
+)
+
+// captureMagic starts every binary capture file
+var captureMagic = []byte("ZCAP1\n")
+
+// maxCaptureFrameLength is the largest payload read from a binary capture,
+// so that a corrupt length cannot exhaust memory
+const maxCaptureFrameLength = 16 << 20
+
+// CapturedFrame is one WebSocket frame in a capture file
+type CapturedFrame struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"dir"`
+	Type      string    `json:"type"`
+	Text      string    `json:"text,omitempty"`
+	Binary    []byte    `json:"binary,omitempty"`
+}
+
+// Payload returns the frame contents
+func (f CapturedFrame) Payload() []byte {
+	if f.Type == FrameTypeBinary {
+		return f.Binary
+	}
+	return []byte(f.Text)
+}
+
+// NewCapturedFrame builds a frame from a WebSocket message type and payload
+func NewCapturedFrame(t time.Time, direction string, messageType int, data []byte) CapturedFrame {
+	f := CapturedFrame{Time: t.UTC(), Direction: direction}
+	if messageType == websocket.BinaryMessage {
+		f.Type = FrameTypeBinary
+		f.Binary = append([]byte(nil), data...)
+	} else {
+		f.Type = FrameTypeText
+		f.Text = string(data)
+	}
+	return f
+}
+
+// CaptureWriter records frames
+type CaptureWriter interface {
+	WriteFrame(CapturedFrame) error
+	Close() error
+}
+
+// CaptureReader reads recorded frames, returning io.EOF at the end
+type CaptureReader interface {
+	ReadFrame() (CapturedFrame, error)
+	Close() error
+}
+
+// CreateCaptureFile create a new timestamped capture file in directory
+func CreateCaptureFile(directory string, format string) (CaptureWriter, string, error) {
+	extension := "jsonl"
+	if format == CaptureFormatBinary {
+		extension = "zcap"
+	} else if format != CaptureFormatJSONL {
+		return nil, "", errors.Errorf("unknown capture format '%s'", format)
+	}
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, "", errors.Annotate(err, "create capture directory")
+	}
+	name := filepath.Join(directory,
+		fmt.Sprintf("capture-%s.%s", time.Now().UTC().Format("20060102T150405Z"), extension))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, "", errors.Annotate(err, "create capture file")
+	}
+	if format == CaptureFormatBinary {
+		w, err := NewBinaryCaptureWriter(f)
+		return w, name, err
+	}
+	return NewJSONLCaptureWriter(f), name, nil
+}
+
+// OpenCaptureFile opens a capture file in either format
+func OpenCaptureFile(name string) (CaptureReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "open capture file")
+	}
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(len(captureMagic))
+	if string(magic) == string(captureMagic) {
+		r.Discard(len(captureMagic))
+		return &binaryCaptureReader{file: f, r: r}, nil
+	}
+	return &jsonlCaptureReader{file: f, decoder: json.NewDecoder(r)}, nil
+}
+
+type jsonlCaptureWriter struct {
+	sync.Mutex
+	w       io.WriteCloser
+	encoder *json.Encoder
+}
+
+// NewJSONLCaptureWriter writes one JSON object per frame per line
+func NewJSONLCaptureWriter(w io.WriteCloser) CaptureWriter {
+	return &jsonlCaptureWriter{w: w, encoder: json.NewEncoder(w)}
+}
+
+func (c *jsonlCaptureWriter) WriteFrame(f CapturedFrame) error {
+	c.Lock()
+	defer c.Unlock()
+	return c.encoder.Encode(f)
+}
+
+func (c *jsonlCaptureWriter) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.w.Close()
+}
+
+type jsonlCaptureReader struct {
+	file    io.Closer
+	decoder *json.Decoder
+}
+
+func (c *jsonlCaptureReader) ReadFrame() (CapturedFrame, error) {
+	var f CapturedFrame
+	err := c.decoder.Decode(&f)
+	return f, err
+}
+
+func (c *jsonlCaptureReader) Close() error {
+	return c.file.Close()
+}
+
+// The binary format is the magic string followed by, for each frame, the
+// time in Unix nanoseconds (int64), the direction and frame type (one byte
+// each), the payload length (uint32) and the payload. Integers are big endian.
+type binaryCaptureWriter struct {
+	sync.Mutex
+	w   io.WriteCloser
+	buf *bufio.Writer
+}
+
+// NewBinaryCaptureWriter writes the compact binary format
+func NewBinaryCaptureWriter(w io.WriteCloser) (CaptureWriter, error) {
+	c := &binaryCaptureWriter{w: w, buf: bufio.NewWriter(w)}
+	if _, err := c.buf.Write(captureMagic); err != nil {
+		return nil, err
+	}
+	return c, c.buf.Flush()
+}
+
+func (c *binaryCaptureWriter) WriteFrame(f CapturedFrame) error {
+	c.Lock()
+	defer c.Unlock()
+	payload := f.Payload()
+	header := make([]byte, 14)
+	binary.BigEndian.PutUint64(header[0:8], uint64(f.Time.UnixNano()))
+	if f.Direction == DirectionOut {
+		header[8] = 1
+	}
+	if f.Type == FrameTypeBinary {
+		header[9] = 1
+	}
+	binary.BigEndian.PutUint32(header[10:14], uint32(len(payload)))
+	if _, err := c.buf.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.buf.Write(payload); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *binaryCaptureWriter) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.buf.Flush()
+	return c.w.Close()
+}
+
+type binaryCaptureReader struct {
+	file io.Closer
+	r    *bufio.Reader
+}
+
+func (c *binaryCaptureReader) ReadFrame() (CapturedFrame, error) {
+	header := make([]byte, 14)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return CapturedFrame{}, errors.New("truncated capture frame header")
+		}
+		return CapturedFrame{}, err
+	}
+	length := binary.BigEndian.Uint32(header[10:14])
+	if length > maxCaptureFrameLength {
+		return CapturedFrame{}, errors.Errorf("capture frame payload of %d bytes is longer than %d, the capture is corrupt", length, maxCaptureFrameLength)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return CapturedFrame{}, errors.New("truncated capture frame payload")
+	}
+	messageType := websocket.TextMessage
+	if header[9] == 1 {
+		messageType = websocket.BinaryMessage
+	}
+	direction := DirectionIn
+	if header[8] == 1 {
+		direction = DirectionOut
+	}
+	return NewCapturedFrame(time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))), direction, messageType, payload), nil
+}
+
+func (c *binaryCaptureReader) Close() error {
+	return c.file.Close()
+}
+
+// Replayer plays the inbound frames of a capture back at the original
+// pace multiplied by Speed. A Speed of 0 replays as fast as possible.
+type Replayer struct {
+	reader CaptureReader
+	Speed  float64
+}
+
+// NewReplayer create a new Replayer
+func NewReplayer(reader CaptureReader, speed float64) *Replayer {
+	return &Replayer{reader: reader, Speed: speed}
+}
+
+// Run delivers each inbound frame until the capture ends or done is
+// closed. It returns nil at the end of the capture.
+func (r *Replayer) Run(done <-chan struct{}, deliver func(CapturedFrame)) error {
+	var previous time.Time
+	for {
+		f, err := r.reader.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if f.Direction != DirectionIn {
+			continue
+		}
+		if r.Speed > 0 && !previous.IsZero() {
+			if gap := f.Time.Sub(previous); gap > 0 {
+				select {
+				case <-time.After(time.Duration(float64(gap) / r.Speed)):
+				case <-done:
+					return nil
+				}
+			}
+		}
+		previous = f.Time
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		deliver(f)
+	}
+}
+
+// ParseCaptureFormat validates a capture format name
+func ParseCaptureFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", CaptureFormatJSONL:
+		return CaptureFormatJSONL, nil
+	case CaptureFormatBinary:
+		return CaptureFormatBinary, nil
+	}
+	return "", errors.Errorf("unknown capture format '%s'", format)
+}
+
+// errReplayFinished marks the end of a replayed capture
+var errReplayFinished = errors.New("replay finished")
+
+// record a frame if the session is being captured. Secrets are masked in
+// text frames; binary frames carry only media.
+func (w *Networker) record(direction string, messageType int, data []byte) {
+	if w.capture == nil {
+		return
+	}
+	if messageType == websocket.TextMessage {
+		data = secrets.RedactBytes(data)
+	}
+	if err := w.capture.WriteFrame(NewCapturedFrame(time.Now(), direction, messageType, data)); err != nil {
+		w.log.Errorf("Capture write error: %s", err)
+	}
+}
+
+// startReplay stands in for dialling when a capture is being replayed
+func (w *Networker) startReplay() error {
+	reader, err := OpenCaptureFile(w.replayFile)
+	if err != nil {
+		return err
+	}
+	w.setState(StateConnecting, fmt.Sprintf("opening %s", w.replayFile))
+	w.setState(StateConnected, fmt.Sprintf("replaying %s", w.replayFile))
+	go w.replayLoop(reader)
+	return nil
+}
+
+func (w *Networker) replayLoop(reader CaptureReader) {
+	defer reader.Close()
+	err := NewReplayer(reader, w.replaySpeed).Run(w.done, func(f CapturedFrame) {
+		select {
+		case w.incoming <- frame{data: f.Payload()}:
+		case <-w.done:
+		}
+	})
+	if err == nil {
+		err = errReplayFinished
+	}
+	select {
+	case w.incoming <- frame{err: err}:
+	case <-w.done:
+	}
+}
+
+// replayEnded handles the end of a replay. It returns false when the
+// worker should stop.
+func (w *Networker) replayEnded(err error, term *chan int) bool {
+	if err == errReplayFinished {
+		w.log.Infof("Replay of %s finished", w.replayFile)
+		w.setDisconnected("replay finished")
+		return true
+	}
+	w.log.Errorf("Replay error: %s", err)
+	w.setFatal(fmt.Sprintf("replay: %s", err))
+	*term <- 1
+	return false
+}
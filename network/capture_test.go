@@ -0,0 +1,171 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBinaryCaptureFrameLengthLimited(t *testing.T) {
+	header := make([]byte, 14)
+	binary.BigEndian.PutUint32(header[10:14], 0xffffffff)
+	r := &binaryCaptureReader{file: io.NopCloser(nil), r: bufio.NewReader(bytes.NewReader(header))}
+	_, err := r.ReadFrame()
+	if err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Fatalf("corrupt frame length read with error %v", err)
+	}
+}
+
+// frames of a short session, one second apart
+func testFrames() []CapturedFrame {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []CapturedFrame{
+		NewCapturedFrame(start, DirectionOut, websocket.TextMessage, []byte(`{"command":"logon","seq":1}`)),
+		NewCapturedFrame(start.Add(time.Second), DirectionIn, websocket.TextMessage, []byte(`{"seq":1,"success":true}`)),
+		NewCapturedFrame(start.Add(2*time.Second), DirectionIn, websocket.BinaryMessage, []byte{1, 0, 0, 0, 1, 0, 0, 0, 0, 0xfc}),
+		NewCapturedFrame(start.Add(3*time.Second), DirectionOut, websocket.TextMessage, []byte(`{"command":"send_text_message","seq":2}`)),
+		NewCapturedFrame(start.Add(4*time.Second), DirectionIn, websocket.TextMessage, []byte(`{"command":"on_text_message","text":"hello"}`)),
+	}
+}
+
+// sliceReader reads frames from memory
+type sliceReader struct {
+	frames []CapturedFrame
+}
+
+func (r *sliceReader) ReadFrame() (CapturedFrame, error) {
+	if len(r.frames) == 0 {
+		return CapturedFrame{}, io.EOF
+	}
+	f := r.frames[0]
+	r.frames = r.frames[1:]
+	return f, nil
+}
+
+func (r *sliceReader) Close() error {
+	return nil
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	for _, format := range []string{CaptureFormatJSONL, CaptureFormatBinary} {
+		w, name, err := CreateCaptureFile(t.TempDir(), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range testFrames() {
+			if err := w.WriteFrame(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := OpenCaptureFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		for i, want := range testFrames() {
+			f, err := r.ReadFrame()
+			if err != nil {
+				t.Fatalf("%s frame %d: %s", format, i, err)
+			}
+			if !f.Time.Equal(want.Time) || f.Direction != want.Direction || f.Type != want.Type || !bytes.Equal(f.Payload(), want.Payload()) {
+				t.Errorf("%s frame %d is %+v, wrote %+v", format, i, f, want)
+			}
+		}
+		if _, err := r.ReadFrame(); err != io.EOF {
+			t.Errorf("%s read past the end with %v", format, err)
+		}
+	}
+}
+
+func TestReplayerDeliversInboundFramesInOrder(t *testing.T) {
+	var delivered []string
+	err := NewReplayer(&sliceReader{frames: testFrames()}, 0).Run(make(chan struct{}), func(f CapturedFrame) {
+		delivered = append(delivered, string(f.Payload()))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, f := range testFrames() {
+		if f.Direction == DirectionIn {
+			want = append(want, string(f.Payload()))
+		}
+	}
+	if strings.Join(delivered, "|") != strings.Join(want, "|") {
+		t.Errorf("delivered %q, want %q", delivered, want)
+	}
+}
+
+func TestReplayerKeepsPace(t *testing.T) {
+	// Inbound frames are 1s then 2s apart, the outbound frame between
+	// them setting no pace
+	const speed = 20
+	var times []time.Time
+	err := NewReplayer(&sliceReader{frames: testFrames()}, speed).Run(make(chan struct{}), func(f CapturedFrame) {
+		times = append(times, time.Now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 3 {
+		t.Fatalf("%d frames delivered", len(times))
+	}
+	for i, gap := range []time.Duration{time.Second / speed, 2 * time.Second / speed} {
+		if got := times[i+1].Sub(times[i]); got < gap {
+			t.Errorf("frame %d delivered %s after the one before, want at least %s", i+1, got, gap)
+		}
+	}
+}
+
+func TestReplayerStopsWhenDone(t *testing.T) {
+	done := make(chan struct{})
+	delivered := 0
+	// At the original pace the second inbound frame is a second away
+	err := NewReplayer(&sliceReader{frames: testFrames()}, 1).Run(done, func(f CapturedFrame) {
+		delivered++
+		close(done)
+	})
+	if err != nil || delivered != 1 {
+		t.Errorf("stopped with %v after %d frames", err, delivered)
+	}
+}
+
+func TestReplayerReportsCorruptCapture(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewBinaryCaptureWriter(nopWriteCloser{&buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteFrame(testFrames()[1])
+	header := make([]byte, 14)
+	binary.BigEndian.PutUint32(header[10:14], maxCaptureFrameLength+1)
+	buf.Write(header)
+
+	r := &binaryCaptureReader{file: io.NopCloser(nil), r: bufio.NewReader(bytes.NewReader(buf.Bytes()[len(captureMagic):]))}
+	delivered := 0
+	err = NewReplayer(r, 0).Run(make(chan struct{}), func(f CapturedFrame) { delivered++ })
+	if err == nil || !strings.Contains(err.Error(), "corrupt") || delivered != 1 {
+		t.Errorf("corrupt capture replayed %d frames with error %v", delivered, err)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,114 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultHeartbeatInterval = 30 * time.Second
+	controlWriteWait         = 10 * time.Second
+)
+
+// Liveness of the current connection
+type Liveness struct {
+	LastSeen time.Time     `json:"last_seen"`
+	LastPing time.Time     `json:"last_ping"`
+	LastPong time.Time     `json:"last_pong"`
+	RTT      time.Duration `json:"rtt"`
+}
+
+// frame read from the WebSocket, or the error that ended the connection
+type frame struct {
+	conn *websocket.Conn
+	data []byte
+	err  error
+}
+
+// Liveness returns the heartbeat measurements for the current connection
+func (w *Networker) Liveness() Liveness {
+	w.livenessLock.Lock()
+	defer w.livenessLock.Unlock()
+	return w.liveness
+}
+
+// readLoop reads frames from c until it fails. Each frame received pushes
+// the read deadline out by the heartbeat timeout, so a connection that
+// goes silent, without even answering pings, ends with a timeout error.
+func (w *Networker) readLoop(c *websocket.Conn) {
+	defer c.Close()
+	for {
+		messageType, message, err := c.ReadMessage()
+		if err == nil {
+			now := time.Now()
+			w.seen(now)
+			c.SetReadDeadline(now.Add(w.heartbeatTimeout))
+			w.record(DirectionIn, messageType, message)
+		}
+		select {
+		case w.incoming <- frame{conn: c, data: message, err: err}:
+		case <-w.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ping sends a ping carrying the time it was sent so that the pong
+// handler can measure the round trip time
+func (w *Networker) ping() {
+	c := w.currentWebSocket()
+	if c == nil {
+		return
+	}
+	now := time.Now()
+
+	w.livenessLock.Lock()
+	if !w.liveness.LastPing.IsZero() && w.liveness.LastPong.Before(w.liveness.LastPing) {
+		w.log.Warnf("No pong received for ping sent at %s", w.liveness.LastPing.Format(time.RFC3339))
+	}
+	w.liveness.LastPing = now
+	w.livenessLock.Unlock()
+
+	w.log.Debug("Sending Ping")
+	err := c.WriteControl(websocket.PingMessage, []byte(strconv.FormatInt(now.UnixNano(), 10)), now.Add(controlWriteWait))
+	if err != nil {
+		w.log.Errorf("Ping write error: %s", err)
+	}
+}
+
+// pong is called from the read loop when a pong arrives
+func (w *Networker) pong(c *websocket.Conn, appData string) error {
+	now := time.Now()
+
+	w.livenessLock.Lock()
+	w.liveness.LastSeen = now
+	w.liveness.LastPong = now
+	if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+		w.liveness.RTT = now.Sub(time.Unix(0, sent))
+	}
+	rtt := w.liveness.RTT
+	w.livenessLock.Unlock()
+
+	w.log.Debugf("Pong received, RTT %s", rtt)
+	return c.SetReadDeadline(now.Add(w.heartbeatTimeout))
+}
+
+func (w *Networker) seen(t time.Time) {
+	w.livenessLock.Lock()
+	defer w.livenessLock.Unlock()
+	w.liveness.LastSeen = t
+}
+
+func (w *Networker) currentWebSocket() *websocket.Conn {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	return w.webSocket
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/secrets"
 	"github.com/jcmurray/monitor/sequence"
 	"github.com/jcmurray/monitor/worker"
 	"github.com/juju/errors"
@@ -20,32 +21,41 @@ import (
 
 // SubscriptionTypeConection for use by other go routines
 const (
-	defaultRetryCount         = 8
-	defaultRetryInterval      = 2
-	defaultMaxRetryInterval   = 5 * 60
-	oneDayInSeconds           = 24 * 60 * 60
+	idleRetryInterval         = 24 * time.Hour
 	SubscriptionTypeConection = "connection"
+	SubscriptionTypeState     = "connection_state"
 	Connected                 = "connected"
 	Disconnected              = "disconnected"
+	Reauthenticate            = "reauthenticate"
 )
 
 // Networker network worker
 type Networker struct {
 	sync.Mutex
 	command           chan int
-	hostname          string
-	port              int
+	transport         *TransportConfig
+	dialer            *websocket.Dialer
 	log               *log.Entry
 	id                int
 	label             string
-	connected         bool
+	state             *StateMachine
 	webSocket         *websocket.Conn
+	writeLock         sync.Mutex
 	url               string
-	retryCount        int
-	retryInterval     time.Duration // seconds
-	inRetryProcess    bool
-	thisInterval      time.Duration
-	message           []byte
+	policy            *ReconnectPolicy
+	backoff           *backoff
+	retryTimer        *time.Timer
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	livenessLock      sync.Mutex
+	liveness          Liveness
+	incoming          chan frame
+	done              chan struct{}
+	fatal             chan struct{}
+	fatalOnce         sync.Once
+	capture           CaptureWriter
+	replayFile        string
+	replaySpeed       float64
 	subscriptionsLock sync.Mutex
 	subscriptions     *worker.Subscription
 	workers           *worker.Workers
@@ -53,18 +63,17 @@ type Networker struct {
 
 // NewNetworker create a new Networker
 func NewNetworker(workers *worker.Workers, id int, label string) *Networker {
-	return &Networker{
-		connected:      false,
-		command:        make(chan int, 10),
-		id:             id,
-		label:          label,
-		log:            log.WithFields(log.Fields{"Label": label, "ID": id}),
-		inRetryProcess: false,
-		retryCount:     defaultRetryCount,
-		retryInterval:  defaultRetryInterval,
-		workers:        workers,
-		subscriptions:  nil,
+	w := &Networker{
+		command:       make(chan int, 10),
+		fatal:         make(chan struct{}),
+		id:            id,
+		label:         label,
+		log:           log.WithFields(log.Fields{"Label": label, "ID": id}),
+		workers:       workers,
+		subscriptions: nil,
 	}
+	w.state = NewStateMachine(w.stateChanged)
+	return w
 }
 
 // Run is main finction of this worker
@@ -78,196 +87,246 @@ func (w *Networker) Run(wg *sync.WaitGroup, term *chan int) {
 
 	w.log.Debugf("Worker Started")
 
-	w.hostname = viper.GetString("server.host")
-	w.port = viper.GetInt("server.port")
-	w.url = w.urlString(w.hostname, w.port)
+	transport, err := NewTransportConfig()
+	if err == nil {
+		w.dialer, err = transport.Dialer()
+	}
+	if err != nil {
+		w.log.Errorf("Transport configuration error: %s", err)
+		w.setFatal(fmt.Sprintf("transport: %s", err))
+		*term <- 1
+		return
+	}
+	w.transport = transport
+	w.url = transport.URL
+
+	w.replayFile = viper.GetString("network.replay.file")
+	w.replaySpeed = viper.GetFloat64("network.replay.speed")
+	if w.replayFile != "" {
+		w.log.Infof("Replaying: %s at speed %.2f, no network connection will be made", w.replayFile, w.replaySpeed)
+	} else {
+		w.log.Infof("Server: %s", transport)
+	}
+
+	if directory := viper.GetString("network.capture.directory"); directory != "" && w.replayFile == "" {
+		format, err := ParseCaptureFormat(viper.GetString("network.capture.format"))
+		var name string
+		if err == nil {
+			w.capture, name, err = CreateCaptureFile(directory, format)
+		}
+		if err != nil {
+			w.log.Errorf("Capture error: %s", err)
+			w.setFatal(fmt.Sprintf("capture: %s", err))
+			*term <- 1
+			return
+		}
+		defer w.capture.Close()
+		w.log.Infof("Recording session to: %s", name)
+	}
 
-	w.log.Infof("Hostname: %s, Port: %d", w.hostname, w.port)
+	policy, err := NewReconnectPolicy()
+	if err != nil {
+		w.log.Errorf("Reconnect policy error: %s", err)
+		w.setFatal(fmt.Sprintf("reconnect policy: %s", err))
+		*term <- 1
+		return
+	}
+	w.policy = policy
+	w.backoff = newBackoff(policy)
+
+	w.heartbeatInterval = viper.GetDuration("network.heartbeat.interval")
+	w.heartbeatTimeout = viper.GetDuration("network.heartbeat.timeout")
+	if w.heartbeatInterval <= 0 {
+		w.heartbeatInterval = defaultHeartbeatInterval
+	}
+	if w.heartbeatTimeout <= w.heartbeatInterval {
+		w.heartbeatTimeout = 3 * w.heartbeatInterval
+	}
+	w.log.Debugf("Heartbeat interval %s, timeout %s", w.heartbeatInterval, w.heartbeatTimeout)
 
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(w.heartbeatInterval)
 	defer ticker.Stop()
-	w.resetRetryCounters()
+	retryTimer := time.NewTimer(idleRetryInterval)
+	defer retryTimer.Stop()
+	w.retryTimer = retryTimer
+	fatal := w.fatal
+
+	w.incoming = make(chan frame, 16)
+	w.done = make(chan struct{})
+	defer close(w.done)
 
 waitloop:
 	for {
-		var err error
-		if w.isConnected() {
-			w.log.Trace("About to: w.webSocket.ReadMessage()")
-			_, w.message, err = w.webSocket.ReadMessage()
-			if ce, ok := err.(*websocket.CloseError); ok {
-				switch ce.Code {
-				case websocket.CloseNormalClosure,
-					websocket.CloseGoingAway,
-					websocket.CloseNoStatusReceived:
-					w.log.Trace("WebSocket Normal Close")
-					w.setDisconnected()
+		w.log.Tracef("Entering Select")
+		select {
+		case f := <-w.incoming:
+			if f.conn != w.currentWebSocket() || !w.isConnected() {
+				w.log.Tracef("Discarding frame from previous connection")
+				continue
+			}
+			if f.err != nil && w.replayFile != "" {
+				if !w.replayEnded(f.err, term) {
 					break waitloop
-				case websocket.CloseProtocolError,
-					websocket.CloseUnsupportedData,
-					websocket.CloseAbnormalClosure,
-					websocket.CloseInvalidFramePayloadData,
-					websocket.ClosePolicyViolation,
-					websocket.CloseMessageTooBig,
-					websocket.CloseMandatoryExtension,
-					websocket.CloseInternalServerErr,
-					websocket.CloseServiceRestart,
-					websocket.CloseTryAgainLater,
-					websocket.CloseTLSHandshake:
-					w.log.Trace("WebSocket Abnormal Close")
-					w.setDisconnected()
-					if !w.isRetrying() {
-						w.resetRetryCounters()
-						w.setRetrying()
-					}
-					w.thisInterval = w.getPollingInterval()
-					w.log.Infof("Retry %d in %d seconds", w.retryCount, w.thisInterval)
-
-				case 3001:
-					w.log.Trace("Exiting due to channel closed")
-					w.setDisconnected()
-					w.Command(worker.Terminate)
-					*term <- 1
-
-				case 3002:
-					w.log.Trace("Exiting due to invalid credentials")
-					w.setDisconnected()
-					w.Command(worker.Terminate)
-					*term <- 1
-
-				default:
-					w.log.Tracef("Exiting due to unrecognised websocket close code=%s", err)
-					w.setDisconnected()
-					w.Command(worker.Terminate)
-					*term <- 1
 				}
-			} else {
-				w.log.Tracef("WebSocket exited with non-close indication, err=%s", err)
+				continue
 			}
-		} else {
-			w.log.Debugf("Entering Select")
-			select {
-			case netCommand, more := <-w.command:
-				w.log.Tracef("netCommand, more := <-w.command:")
-				if more {
-					w.log.Debugf("Received command %d", netCommand)
-					switch netCommand {
-					case worker.Connect:
-						w.log.Debugf("Connecting")
-						err := connect(w)
-						if err != nil {
-							w.log.Errorf("Connection error: %v", err)
-							w.setDisconnected()
-							if !w.isRetrying() {
-								w.resetRetryCounters()
-								w.setRetrying()
-							} else {
-								w.thisInterval = w.getPollingInterval()
-							}
-						}
-						w.log.Debugf("Connected")
-					case worker.Terminate:
-						w.log.Debugf("Terminating")
-						break waitloop
-					default:
-						continue
-					}
-				} else {
-					w.log.Info("Channel closed")
+			if f.err != nil {
+				if !w.readFailed(f.err, term) {
 					break waitloop
 				}
-			case <-ticker.C:
-				w.log.Tracef("case <-ticker.C:")
-				if w.isConnected() {
-					w.log.Debug("Sending Ping")
-					err := w.webSocket.WriteMessage(websocket.PingMessage, []byte(""))
+				continue
+			}
+			w.dispatch(f.data)
+
+		case netCommand, more := <-w.command:
+			w.log.Tracef("netCommand, more := <-w.command:")
+			if more {
+				w.log.Debugf("Received command %d", netCommand)
+				switch netCommand {
+				case worker.Connect:
+					if w.state.Current() == StateFatal {
+						w.log.Warnf("Not connecting, the connection has failed for good")
+						continue
+					}
+					w.log.Debugf("Connecting")
+					err := connect(w)
 					if err != nil {
-						w.log.Errorf("Write error: %s", err)
+						w.log.Errorf("Connection error: %v", err)
+						if !w.retry(fmt.Sprintf("connection error: %s", err), term) {
+							break waitloop
+						}
+						continue
 					}
+					w.log.Debugf("Connected")
+				case worker.Terminate:
+					w.log.Debugf("Terminating")
+					break waitloop
+				default:
+					continue
 				}
-				continue
+			} else {
+				w.log.Info("Channel closed")
+				break waitloop
+			}
 
-			case <-time.After(time.Second * w.thisInterval):
-				w.log.Tracef("time.Second * w.thisInterval")
-				if w.isRetrying() {
-					if err := connect(w); err != nil {
-						w.log.Error("Connection failure")
-						w.setDisconnected()
-						if !w.isRetrying() {
-							w.resetRetryCounters()
-							w.setRetrying()
-						} else {
-							w.thisInterval = w.getPollingInterval()
-						}
+		case <-fatal:
+			w.log.Debugf("Connection failed for good, stopping the heartbeat and retry timers")
+			ticker.Stop()
+			retryTimer.Stop()
+			fatal = nil
+
+		case <-ticker.C:
+			w.log.Tracef("case <-ticker.C:")
+			if w.isConnected() {
+				w.ping()
+			}
+
+		case <-retryTimer.C:
+			w.log.Tracef("case <-retryTimer.C:")
+			if w.isRetrying() {
+				if err := connect(w); err != nil {
+					w.log.Errorf("Connection failure: %s", err)
+					if !w.retry(fmt.Sprintf("connection error: %s", err), term) {
+						break waitloop
 					}
 				}
 			}
 		}
+	}
+	w.log.Debug("Finished")
+}
 
-		if len(w.message) == 0 {
-			continue
-		}
+// readFailed handles the end of a connection. It returns false when the
+// worker should stop.
+func (w *Networker) readFailed(err error, term *chan int) bool {
+	ce, ok := err.(*websocket.CloseError)
+	if !ok {
+		w.log.Tracef("WebSocket exited with non-close indication, err=%s", err)
+		return w.retry(fmt.Sprintf("read error: %s", err), term)
+	}
 
-		if w.message[0] == 0x01 {
-			w.log.Trace("Data message")
-			w.sendToSubscribersByType(protocolapp.OnStreamDataEvent, w.message)
-			continue
-		}
+	action := w.policy.ActionFor(ce.Code)
+	w.log.Tracef("WebSocket closed, code %d, action %s", ce.Code, action)
+	switch action {
+	case CloseActionReauthenticate:
+		w.log.Infof("Server closed connection (%s), re-authenticating", ce)
+		w.sendToSubscribersByType(SubscriptionTypeConection, []byte(Reauthenticate))
+		return w.retry(fmt.Sprintf("close requires re-authentication: %s", ce), term)
+	case CloseActionTerminate:
+		w.log.Errorf("Exiting due to websocket close: %s", ce)
+		w.setFatal(fmt.Sprintf("close: %s", ce))
+		*term <- 1
+		return false
+	default:
+		w.log.Infof("Server closed connection (%s), retrying", ce)
+		return w.retry(fmt.Sprintf("close: %s", ce), term)
+	}
+}
 
-		if w.message[0] == 0x02 {
-			w.log.Trace("Image message")
-			w.sendToSubscribersByType(protocolapp.OnImageDataEvent, w.message)
-			continue
-		}
+// dispatch a message received from Zello to the subscribed workers
+func (w *Networker) dispatch(message []byte) {
+	if len(message) == 0 {
+		return
+	}
 
-		command := protocolapp.Command{}
-		err = json.Unmarshal(w.message, &command)
-		if err != nil {
-			w.log.Errorf("Unmarshal error: %s", err)
-			continue
-		}
+	if message[0] == 0x01 {
+		w.log.Trace("Data message")
+		w.sendToSubscribersByType(protocolapp.OnStreamDataEvent, message)
+		return
+	}
 
-		switch {
-		case command.Command == protocolapp.OnErrorEvent:
-			w.log.Debugf("Command received: %s", command.Command)
-			w.log.Tracef("Error Message: %s", string(w.message))
-			w.sendToSubscribersByType(protocolapp.OnErrorEvent, w.message)
-			continue
-		case command.Command == protocolapp.OnChannelStatusEvent:
-			w.log.Debugf("Command received: %s", command.Command)
-			w.log.Tracef("Status Message: %s", string(w.message))
-			w.sendToSubscribersByType(protocolapp.OnChannelStatusEvent, w.message)
-			continue
-		case command.Command == protocolapp.OnStreamStartEvent:
-			w.log.Debugf("Command received: %s", command.Command)
-			w.log.Tracef("Stream Start Message: %s", string(w.message))
-			w.sendToSubscribersByType(protocolapp.OnStreamStartEvent, w.message)
-			continue
-		case command.Command == protocolapp.OnStreamStopEvent:
-			w.log.Debugf("Command received: %s", command.Command)
-			w.log.Tracef("Stream Stop Message: %s", string(w.message))
-			w.sendToSubscribersByType(protocolapp.OnStreamStopEvent, w.message)
-			continue
-		case command.Command == protocolapp.OnImageEvent:
-			w.log.Debugf("Command received: %s", command.Command)
-			w.log.Tracef("Image Message: %s", string(w.message))
-			w.sendToSubscribersByType(protocolapp.OnImageEvent, w.message)
-			continue
-		case command.Command == protocolapp.OnTextMessageEvent:
-			w.log.Debugf("Command received: %s", command.Command)
-			w.log.Tracef("Text Message: %s", string(w.message))
-			w.sendToSubscribersByType(protocolapp.OnTextMessageEvent, w.message)
-			continue
-		case command.Command == protocolapp.OnLocationEvent:
-			w.log.Debugf("Command received: %s", command.Command)
-			w.log.Tracef("Location Message: %s", string(w.message))
-			w.sendToSubscribersByType(protocolapp.OnLocationEvent, w.message)
-			continue
-		}
+	if message[0] == 0x02 {
+		w.log.Trace("Image message")
+		w.sendToSubscribersByType(protocolapp.OnImageDataEvent, message)
+		return
+	}
 
-		w.sendToSubscribersByResponseExpected(protocolapp.OnResponseEvent, w.message)
+	command := protocolapp.Command{}
+	err := json.Unmarshal(message, &command)
+	if err != nil {
+		w.log.Errorf("Unmarshal error: %s", err)
+		return
+	}
 
+	switch {
+	case command.Command == protocolapp.OnErrorEvent:
+		w.log.Debugf("Command received: %s", command.Command)
+		w.log.Tracef("Error Message: %s", string(message))
+		w.sendToAllSubscribersByType(protocolapp.OnErrorEvent, message)
+		return
+	case command.Command == protocolapp.OnChannelStatusEvent:
+		w.log.Debugf("Command received: %s", command.Command)
+		w.log.Tracef("Status Message: %s", string(message))
+		w.sendToSubscribersByType(protocolapp.OnChannelStatusEvent, message)
+		return
+	case command.Command == protocolapp.OnStreamStartEvent:
+		w.log.Debugf("Command received: %s", command.Command)
+		w.log.Tracef("Stream Start Message: %s", string(message))
+		w.sendToSubscribersByType(protocolapp.OnStreamStartEvent, message)
+		return
+	case command.Command == protocolapp.OnStreamStopEvent:
+		w.log.Debugf("Command received: %s", command.Command)
+		w.log.Tracef("Stream Stop Message: %s", string(message))
+		w.sendToSubscribersByType(protocolapp.OnStreamStopEvent, message)
+		return
+	case command.Command == protocolapp.OnImageEvent:
+		w.log.Debugf("Command received: %s", command.Command)
+		w.log.Tracef("Image Message: %s", string(message))
+		w.sendToSubscribersByType(protocolapp.OnImageEvent, message)
+		return
+	case command.Command == protocolapp.OnTextMessageEvent:
+		w.log.Debugf("Command received: %s", command.Command)
+		w.log.Tracef("Text Message: %s", string(message))
+		w.sendToAllSubscribersByType(protocolapp.OnTextMessageEvent, message)
+		return
+	case command.Command == protocolapp.OnLocationEvent:
+		w.log.Debugf("Command received: %s", command.Command)
+		w.log.Tracef("Location Message: %s", string(message))
+		w.sendToSubscribersByType(protocolapp.OnLocationEvent, message)
+		return
 	}
-	w.log.Debug("Finished")
+
+	w.sendToSubscribersByResponseExpected(protocolapp.OnResponseEvent, message)
 }
 
 // Command sent to this worker
@@ -277,17 +336,41 @@ func (w *Networker) Command(c int) {
 
 // Data sent to this worker
 func (w *Networker) Data(d []byte) {
-	if w.isConnected() {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	if w.replayFile != "" {
+		w.log.Tracef("Replaying, discarding: %s", secrets.RedactBytes(d))
+		return
+	}
+	if w.isConnected() && w.webSocket != nil {
 		err := w.webSocket.WriteMessage(websocket.TextMessage, d)
 		if err != nil {
 			w.log.Errorf("write: %s", err)
 		}
-		w.log.Tracef("writen: %v", d)
+		w.log.Tracef("Written: %s", secrets.RedactBytes(d))
+		w.record(DirectionOut, websocket.TextMessage, d)
 	} else {
 		w.log.Warn("Attempt to send data on disconnected websocket")
 	}
 }
 
+// BinaryData sent to this worker, such as outgoing stream packets
+func (w *Networker) BinaryData(d []byte) error {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	if w.replayFile != "" {
+		return errors.New("replaying a capture, not connected")
+	}
+	if !w.isConnected() || w.webSocket == nil {
+		return errors.New("websocket is disconnected")
+	}
+	if err := w.webSocket.WriteMessage(websocket.BinaryMessage, d); err != nil {
+		return errors.Annotate(err, "write")
+	}
+	w.record(DirectionOut, websocket.BinaryMessage, d)
+	return nil
+}
+
 // Disconnect the websocket
 func (w *Networker) Disconnect() {
 	w.log.Trace("in: func (w *Networker) Disconnect()")
@@ -311,16 +394,32 @@ func connect(w *Networker) error {
 		w.log.Debugf("State error, unable to connect when already connected")
 		return nil
 	}
+	if w.replayFile != "" {
+		return w.startReplay()
+	}
 	w.log.Debugf("Connecting to: %s", w.url)
-	c, _, err := websocket.DefaultDialer.Dial(w.url, nil)
+	w.setState(StateConnecting, fmt.Sprintf("dialling %s", w.url))
+	c, _, err := w.dialer.Dial(w.url, w.transport.Headers)
 	if err != nil {
-		w.setDisconnected()
 		return err
 	}
 	w.log.Debugf("Connected to %s", w.url)
-	w.sendToSubscribersByType(SubscriptionTypeConection, []byte(Connected))
+
+	now := time.Now()
+	w.livenessLock.Lock()
+	w.liveness = Liveness{LastSeen: now}
+	w.livenessLock.Unlock()
+	c.SetReadDeadline(now.Add(w.heartbeatTimeout))
+	c.SetPongHandler(func(appData string) error {
+		return w.pong(c, appData)
+	})
+
+	w.writeLock.Lock()
 	w.webSocket = c
-	w.setConnected()
+	w.writeLock.Unlock()
+
+	go w.readLoop(c)
+	w.setState(StateConnected, fmt.Sprintf("connected to %s", w.url))
 	return nil
 }
 
@@ -336,67 +435,135 @@ func disconnect(w *Networker) error {
 	}
 	w.log.Debugf("Disconnecting from: %s", w.url)
 
+	if w.webSocket == nil {
+		w.setDisconnected("client disconnected")
+		return nil
+	}
+	w.writeLock.Lock()
 	err := w.webSocket.WriteMessage(websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	w.webSocket.Close()
+	w.writeLock.Unlock()
 	if err != nil {
 		w.log.Errorf("Connection close error: %s", err)
-		w.setDisconnected()
+		w.setDisconnected("close error")
 		return errors.Annotate(err, "Error on disconnecting Zello WebSocket")
 	}
-	w.sendToSubscribersByType(SubscriptionTypeConection, []byte(Disconnected))
-	w.setDisconnected()
+	w.setDisconnected("client disconnected")
 	w.log.Debugf("Disconnected from %s", w.url)
 	return nil
 }
 
-func (w *Networker) setConnected() {
-	w.connected = true
+// ConnectionState returns the current state of the connection
+func (w *Networker) ConnectionState() ConnectionState {
+	return w.state.Current()
 }
 
-func (w *Networker) setDisconnected() {
-	w.connected = false
+// StateSnapshot returns the current state and recent transitions
+func (w *Networker) StateSnapshot() StateSnapshot {
+	return w.state.Snapshot()
 }
 
-func (w *Networker) urlString(hostname string, port int) string {
-	return fmt.Sprintf("wss://%s:%d/ws", hostname, port)
+// SetAuthenticating records that a logon is in progress
+func (w *Networker) SetAuthenticating(reason string) {
+	w.setState(StateAuthenticating, reason)
 }
 
-func (w *Networker) isRetrying() bool {
-	return w.inRetryProcess
+// SetOnline records that a logon has succeeded
+func (w *Networker) SetOnline(reason string) {
+	w.setState(StateOnline, reason)
 }
 
-func (w *Networker) setRetrying() {
-	w.inRetryProcess = true
-	w.thisInterval = defaultRetryInterval
-	w.log.Debugf("setRetrying(): setRetrying %v, inRetryProcess %d", w.inRetryProcess, w.thisInterval)
+// SetFatal records an unrecoverable error
+func (w *Networker) SetFatal(reason string) {
+	w.setFatal(reason)
 }
 
-func (w *Networker) isConnected() bool {
-	return w.connected
+func (w *Networker) setState(s ConnectionState, reason string) {
+	if err := w.state.Transition(s, reason); err != nil {
+		w.log.Debugf("State error: %s (%s)", err, reason)
+	}
 }
 
-func (w *Networker) resetRetryCounters() {
-	w.retryCount = defaultRetryCount
-	w.retryInterval = defaultRetryInterval
-	w.inRetryProcess = false
-	w.thisInterval = oneDayInSeconds
-	w.log.Debugf("resetRetryCounters(): w.retryCount = %d, retryInterval %d, inRetryProcess %v, thisInterval %d,",
-		w.retryCount, w.retryInterval, w.inRetryProcess, w.thisInterval)
+func (w *Networker) stateChanged(t StateTransition) {
+	w.log.Infof("Connection state %s -> %s: %s", t.From, t.To, t.Reason)
+	switch {
+	case t.To == StateConnected:
+		w.sendToSubscribersByType(SubscriptionTypeConection, []byte(Connected))
+	case t.From.IsConnected() && !t.To.IsConnected():
+		w.sendToSubscribersByType(SubscriptionTypeConection, []byte(Disconnected))
+	}
+	buff, err := json.Marshal(t)
+	if err != nil {
+		w.log.Errorf("Marshal error: %s", err)
+		return
+	}
+	w.sendToAllSubscribersByType(SubscriptionTypeState, buff)
 }
 
-func (w *Networker) getPollingInterval() time.Duration {
-	if w.retryCount > 0 {
-		w.retryCount--
-		w.retryInterval *= 2
-	} else {
-		w.retryInterval = defaultMaxRetryInterval
+func (w *Networker) setDisconnected(reason string) {
+	w.setState(StateDisconnected, reason)
+}
+
+// setFatal records an unrecoverable error. Fatal is never left, so the
+// connection is closed and the worker's timers are stopped.
+func (w *Networker) setFatal(reason string) {
+	w.setState(StateFatal, reason)
+	w.writeLock.Lock()
+	if w.webSocket != nil {
+		w.webSocket.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(controlWriteWait))
+		w.webSocket.Close()
+		w.webSocket = nil
 	}
-	w.log.Debugf("getPollingInterval(): retryInterval %d", w.retryInterval)
-	return w.retryInterval
+	w.writeLock.Unlock()
+	w.fatalOnce.Do(func() { close(w.fatal) })
+}
+
+func (w *Networker) isRetrying() bool {
+	return w.state.Current() == StateBackoff
+}
+
+// retry schedules the next connection attempt. The attempt count is reset
+// if the connection had been stable for the policy's reset period. It
+// returns false, having requested termination, when attempts run out.
+func (w *Networker) retry(reason string, term *chan int) bool {
+	if state, since := w.state.CurrentSince(); state == StateOnline && time.Since(since) >= w.policy.ResetAfter {
+		w.log.Debugf("Connection was stable since %s, resetting retry attempts", since.Format(time.RFC3339))
+		w.backoff.reset()
+	}
+
+	delay, ok := w.backoff.next()
+	if !ok {
+		w.log.Errorf("Giving up after %d connection attempts", w.backoff.attempts)
+		w.setFatal(fmt.Sprintf("%s; gave up after %d attempts", reason, w.backoff.attempts))
+		*term <- 1
+		return false
+	}
+	w.setState(StateBackoff, reason)
+	w.resetRetryTimer(delay)
+	w.log.Infof("Retry %d in %s", w.backoff.attempts, delay.Round(time.Millisecond))
+	return true
+}
+
+func (w *Networker) resetRetryTimer(d time.Duration) {
+	if !w.retryTimer.Stop() {
+		select {
+		case <-w.retryTimer.C:
+		default:
+		}
+	}
+	w.retryTimer.Reset(d)
+}
+
+func (w *Networker) isConnected() bool {
+	return w.state.Current().IsConnected()
 }
 
 // Subscribe to this worker
 func (w *Networker) Subscribe(id int, sType string, label string) *worker.Subscription {
+	w.subscriptionsLock.Lock()
+	defer w.subscriptionsLock.Unlock()
 
 	subscription := worker.NewSubscription(id, sType, label)
 	subscription.Next = w.subscriptions
@@ -423,6 +590,7 @@ func (w *Networker) UnSubscribe(id int, sType string) {
 	if located == nil {
 		return
 	}
+	close(located.Done)
 
 	if located == w.subscriptions {
 		w.subscriptions = located.Next
@@ -439,25 +607,65 @@ func (w *Networker) UnSubscribe(id int, sType string) {
 	prior.Next = located.Next
 }
 
-func (w *Networker) sendToSubscribersByType(sType string, message []byte) {
+// subscribersByType returns the current subscribers of sType, most recent
+// first. Messages are sent to them without holding subscriptionsLock, as
+// a subscriber may itself be waiting to publish.
+func (w *Networker) subscribersByType(sType string) []*worker.Subscription {
 	w.subscriptionsLock.Lock()
 	defer w.subscriptionsLock.Unlock()
-	w.log.Tracef("sendToSubscribersByType(): type %s, %v", sType, message)
+	var subscribers []*worker.Subscription
 	for s := w.subscriptions; s != nil; s = s.Next {
 		if s.Type == sType {
-			s.Channel <- message
-			return
+			subscribers = append(subscribers, s)
 		}
 	}
+	return subscribers
+}
+
+// deliver a message to a subscriber, unless it unsubscribes first
+func deliver(s *worker.Subscription, message []byte) {
+	select {
+	case s.Channel <- message:
+	case <-s.Done:
+	}
+}
+
+func (w *Networker) sendToSubscribersByType(sType string, message []byte) {
+	w.log.Tracef("sendToSubscribersByType(): type %s, %d bytes", sType, len(message))
+	if subscribers := w.subscribersByType(sType); len(subscribers) > 0 {
+		deliver(subscribers[0], message)
+	}
+}
+
+func (w *Networker) sendToAllSubscribersByType(sType string, message []byte) {
+	w.log.Tracef("sendToAllSubscribersByType(): type %s, %d bytes", sType, len(message))
+	for _, s := range w.subscribersByType(sType) {
+		deliver(s, message)
+	}
+}
+
+// Publish sends an event raised by another worker to every subscriber of sType
+func (w *Networker) Publish(sType string, message []byte) {
+	w.sendToAllSubscribersByType(sType, message)
 }
 
 func (w *Networker) sendToSubscribersByResponseExpected(sType string, message []byte) {
-	w.subscriptionsLock.Lock()
-	defer w.subscriptionsLock.Unlock()
-	w.log.Tracef("sendToSubscribersByResponseExpected(): type %s, %v", sType, message)
-	for s := w.subscriptions; s != nil; s = s.Next {
-		if sequence.IsAnyExpected(s.ID) && s.Type == sType {
-			s.Channel <- message
+	w.log.Tracef("sendToSubscribersByResponseExpected(): type %s, %d bytes", sType, len(message))
+	subscribers := w.subscribersByType(sType)
+	// Prefer the worker that sent the request, as more than one may be
+	// waiting for a response
+	response := protocolapp.NewResponse()
+	if json.Unmarshal(message, response) == nil && response.Seq != 0 {
+		for _, s := range subscribers {
+			if sequence.IsSeqExpected(s.ID, response.Seq) {
+				deliver(s, message)
+				return
+			}
+		}
+	}
+	for _, s := range subscribers {
+		if sequence.IsAnyExpected(s.ID) {
+			deliver(s, message)
 			return
 		}
 	}
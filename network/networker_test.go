@@ -0,0 +1,111 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/worker"
+)
+
+func TestTextMessageReachesEverySubscriber(t *testing.T) {
+	var workers worker.Workers
+	nw := NewNetworker(&workers, 1, "Network Worker")
+	texts := nw.Subscribe(2, protocolapp.OnTextMessageEvent, "Text Worker").Channel
+	alerts := nw.Subscribe(3, protocolapp.OnTextMessageEvent, "Alert Worker").Channel
+
+	message, err := json.Marshal(&protocolapp.OnTextMessage{
+		Command:   protocolapp.OnTextMessageEvent,
+		Channel:   "test",
+		From:      "alice",
+		MessageID: 1,
+		Text:      "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nw.dispatch(message)
+
+	for name, c := range map[string]chan interface{}{"text worker": texts, "alert worker": alerts} {
+		select {
+		case m := <-c:
+			if string(m.([]byte)) != string(message) {
+				t.Errorf("%s received %s, want %s", name, m, message)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s received nothing", name)
+		}
+	}
+}
+
+func TestPublishBurstWhileFramesArrive(t *testing.T) {
+	const frames = 200
+	var workers worker.Workers
+	nw := NewNetworker(&workers, 1, "Network Worker")
+	texts := nw.Subscribe(2, protocolapp.OnTextMessageEvent, "Publisher").Channel
+	events := nw.Subscribe(3, "burst", "Consumer").Channel
+
+	// The publisher raises several events for each text it receives, as
+	// the private message and geofence code does
+	go func() {
+		for range texts {
+			for i := 0; i < 5; i++ {
+				nw.Publish("burst", []byte("event"))
+			}
+		}
+	}()
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range events {
+			if n++; n == frames*5 {
+				received <- n
+			}
+		}
+	}()
+
+	message, err := json.Marshal(&protocolapp.OnTextMessage{Command: protocolapp.OnTextMessageEvent, Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for i := 0; i < frames; i++ {
+			nw.dispatch(message)
+		}
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event bus deadlocked")
+	}
+}
+
+func TestUnsubscribeReleasesPendingDelivery(t *testing.T) {
+	var workers worker.Workers
+	nw := NewNetworker(&workers, 1, "Network Worker")
+	s := nw.Subscribe(2, "event", "Gone")
+	sent := make(chan struct{})
+	go func() {
+		// More than the channel holds, and nothing reads it
+		for i := 0; i < 5; i++ {
+			nw.Publish("event", []byte("event"))
+		}
+		close(sent)
+	}()
+	// Once the channel is full the publisher is waiting to deliver
+	for len(s.Channel) < cap(s.Channel) {
+		runtime.Gosched()
+	}
+	nw.UnSubscribe(2, "event")
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("publisher still waiting for an unsubscribed worker")
+	}
+}
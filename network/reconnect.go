@@ -0,0 +1,192 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// CloseAction to take when the server closes the WebSocket
+type CloseAction int
+
+// Close actions
+const (
+	CloseActionRetry CloseAction = iota
+	CloseActionReauthenticate
+	CloseActionTerminate
+)
+
+var closeActionStrings = [...]string{
+	CloseActionRetry:          "retry",
+	CloseActionReauthenticate: "reauthenticate",
+	CloseActionTerminate:      "terminate",
+}
+
+// defaultCloseActions applies to close codes not named in config.
+// Zello uses 3001 when the channel is closed and 3002 for invalid credentials.
+var defaultCloseActions = map[int]CloseAction{
+	websocket.CloseNormalClosure:     CloseActionRetry,
+	websocket.CloseGoingAway:         CloseActionRetry,
+	websocket.CloseNoStatusReceived:  CloseActionRetry,
+	websocket.CloseAbnormalClosure:   CloseActionRetry,
+	websocket.CloseServiceRestart:    CloseActionRetry,
+	websocket.CloseTryAgainLater:     CloseActionRetry,
+	websocket.CloseInternalServerErr: CloseActionRetry,
+	3001:                             CloseActionRetry,
+	3002:                             CloseActionReauthenticate,
+}
+
+// String returns the name of the action
+func (a CloseAction) String() string {
+	if a < 0 || int(a) >= len(closeActionStrings) {
+		return "unknown"
+	}
+	return closeActionStrings[a]
+}
+
+// ParseCloseAction returns the action with the given name
+func ParseCloseAction(name string) (CloseAction, error) {
+	for i := range closeActionStrings {
+		if strings.EqualFold(closeActionStrings[i], strings.TrimSpace(name)) {
+			return CloseAction(i), nil
+		}
+	}
+	return CloseActionRetry, errors.Errorf("unknown close action '%s'", name)
+}
+
+// ReconnectPolicy controls how a lost connection is retried
+type ReconnectPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64 // fraction of the interval, 0 to 1
+	MaxAttempts     int     // 0 retries for ever
+	ResetAfter      time.Duration
+	CloseActions    map[int]CloseAction
+	DefaultAction   CloseAction
+}
+
+// NewReconnectPolicy create a ReconnectPolicy from the 'network' config section
+func NewReconnectPolicy() (*ReconnectPolicy, error) {
+	p := &ReconnectPolicy{
+		InitialInterval: viper.GetDuration("network.reconnect.initial_interval"),
+		MaxInterval:     viper.GetDuration("network.reconnect.max_interval"),
+		Multiplier:      viper.GetFloat64("network.reconnect.multiplier"),
+		Jitter:          viper.GetFloat64("network.reconnect.jitter"),
+		MaxAttempts:     viper.GetInt("network.reconnect.max_attempts"),
+		ResetAfter:      viper.GetDuration("network.reconnect.reset_after"),
+		CloseActions:    make(map[int]CloseAction),
+	}
+
+	if p.InitialInterval <= 0 {
+		return nil, errors.New("network.reconnect.initial_interval must be positive")
+	}
+	if p.MaxInterval < p.InitialInterval {
+		p.MaxInterval = p.InitialInterval
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 1
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return nil, errors.Errorf("network.reconnect.jitter %.2f must be between 0 and 1", p.Jitter)
+	}
+
+	for code, action := range defaultCloseActions {
+		p.CloseActions[code] = action
+	}
+	defaultAction, err := ParseCloseAction(viper.GetString("network.closecodes.default"))
+	if err != nil {
+		return nil, errors.Annotate(err, "network.closecodes.default")
+	}
+	p.DefaultAction = defaultAction
+
+	for key, value := range viper.GetStringMapString("network.closecodes") {
+		if key == "default" {
+			continue
+		}
+		code, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, errors.Errorf("network.closecodes: invalid close code '%s'", key)
+		}
+		action, err := ParseCloseAction(value)
+		if err != nil {
+			return nil, errors.Annotatef(err, "network.closecodes.%s", key)
+		}
+		p.CloseActions[code] = action
+	}
+	return p, nil
+}
+
+// ActionFor returns the action for a close code
+func (p *ReconnectPolicy) ActionFor(code int) CloseAction {
+	if action, ok := p.CloseActions[code]; ok {
+		return action
+	}
+	return p.DefaultAction
+}
+
+// Interval returns the delay, without jitter, before retry number attempt
+// counting from zero. Workers use it to back off requests the server
+// could not handle.
+func (p *ReconnectPolicy) Interval(attempt int) time.Duration {
+	interval := p.InitialInterval
+	for i := 0; i < attempt && interval < p.MaxInterval; i++ {
+		interval = time.Duration(float64(interval) * p.Multiplier)
+	}
+	if interval > p.MaxInterval {
+		interval = p.MaxInterval
+	}
+	return interval
+}
+
+// backoff tracks successive retries under a ReconnectPolicy
+type backoff struct {
+	policy   *ReconnectPolicy
+	attempts int
+	interval time.Duration
+	rand     *rand.Rand
+}
+
+func newBackoff(policy *ReconnectPolicy) *backoff {
+	return &backoff{
+		policy: policy,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the delay before the next attempt, or false when the
+// maximum number of attempts has been made
+func (b *backoff) next() (time.Duration, bool) {
+	if b.policy.MaxAttempts > 0 && b.attempts >= b.policy.MaxAttempts {
+		return 0, false
+	}
+	if b.attempts == 0 {
+		b.interval = b.policy.InitialInterval
+	} else {
+		b.interval = time.Duration(float64(b.interval) * b.policy.Multiplier)
+		if b.interval > b.policy.MaxInterval {
+			b.interval = b.policy.MaxInterval
+		}
+	}
+	b.attempts++
+
+	delay := b.interval
+	if b.policy.Jitter > 0 {
+		spread := float64(delay) * b.policy.Jitter
+		delay += time.Duration(spread * (2*b.rand.Float64() - 1))
+	}
+	return delay, true
+}
+
+func (b *backoff) reset() {
+	b.attempts = 0
+	b.interval = 0
+}
@@ -0,0 +1,141 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network_test
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/jcmurray/monitor/zellotest"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// writeCapture records frames to a new capture file in a temporary directory
+func writeCapture(t *testing.T, format string, frames ...network.CapturedFrame) string {
+	t.Helper()
+	w, name, err := network.CreateCaptureFile(t.TempDir(), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// replay runs a network worker replaying a capture file, returning it
+// with its state changes and the termination channel
+func replay(t *testing.T, name string) (*network.Networker, chan interface{}, chan int) {
+	t.Helper()
+	s := zellotest.NewServer()
+	t.Cleanup(s.Close)
+	s.Configure(testChannel, "monitor", "secret", "token")
+	viper.Set("network.replay.file", name)
+	viper.Set("network.replay.speed", 0)
+	t.Cleanup(func() { viper.Set("network.replay.file", "") })
+
+	var (
+		workers worker.Workers
+		wg      sync.WaitGroup
+	)
+	term := make(chan int, 10)
+	nw := network.NewNetworker(&workers, zellotest.NetworkWorkerID, "Network Worker")
+	workers = append(workers, nw)
+	states := nw.Subscribe(zellotest.FirstWorkerID, network.SubscriptionTypeState, "Test").Channel
+	wg.Add(1)
+	go nw.Run(&wg, &term)
+	t.Cleanup(func() {
+		nw.UnSubscribe(zellotest.FirstWorkerID, network.SubscriptionTypeState)
+		nw.Terminate()
+		wg.Wait()
+	})
+	return nw, states, term
+}
+
+// waitForState reads state changes until the connection moves to state
+func waitForState(t *testing.T, states chan interface{}, state network.ConnectionState) network.StateTransition {
+	t.Helper()
+	for {
+		var transition network.StateTransition
+		if err := json.Unmarshal(receive(t, states, state.String()+" state"), &transition); err != nil {
+			t.Fatal(err)
+		}
+		if transition.To == state {
+			return transition
+		}
+	}
+}
+
+func TestReplayDeliversCapturedFrames(t *testing.T) {
+	logs := zellotest.WatchLogs(t)
+	log.SetLevel(log.TraceLevel)
+	start := time.Now().Add(-time.Hour)
+	name := writeCapture(t, network.CaptureFormatJSONL,
+		network.NewCapturedFrame(start, network.DirectionIn, websocket.TextMessage, []byte(`{"command":"on_text_message","text":"first"}`)),
+		network.NewCapturedFrame(start.Add(time.Second), network.DirectionOut, websocket.TextMessage, []byte(`{"command":"send_text_message","text":"not replayed"}`)),
+		network.NewCapturedFrame(start.Add(2*time.Second), network.DirectionIn, websocket.TextMessage, []byte(`{"command":"on_text_message","text":"second"}`)),
+	)
+	nw, states, _ := replay(t, name)
+	texts := nw.Subscribe(zellotest.FirstWorkerID+1, protocolapp.OnTextMessageEvent, "Test").Channel
+
+	nw.Command(worker.Connect)
+	waitForState(t, states, network.StateConnected)
+
+	// Frames written while replaying are logged, with secrets masked,
+	// and dropped
+	nw.Data([]byte(`{"command":"logon","seq":1,"password":"hunter2"}`))
+	if m := logs.Expect(t, "Replaying, discarding"); strings.Contains(m, "hunter2") {
+		t.Errorf("password logged: %s", m)
+	}
+	if err := nw.BinaryData([]byte{1}); err == nil {
+		t.Error("binary data written while replaying")
+	}
+
+	for _, want := range []string{"first", "second"} {
+		text := protocolapp.NewOnTextMessage()
+		if err := json.Unmarshal(receive(t, texts, want+" text"), text); err != nil || text.Text != want {
+			t.Fatalf("text %+v, %v, want '%s'", text, err, want)
+		}
+	}
+	if transition := waitForState(t, states, network.StateDisconnected); transition.Reason != "replay finished" {
+		t.Errorf("disconnected: %s", transition.Reason)
+	}
+}
+
+func TestReplayOfCorruptCaptureIsFatal(t *testing.T) {
+	name := writeCapture(t, network.CaptureFormatBinary,
+		network.NewCapturedFrame(time.Now(), network.DirectionIn, websocket.TextMessage, []byte(`{"command":"on_text_message","text":"first"}`)))
+	// A frame header whose payload length is far too long
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+	f.Close()
+
+	nw, states, term := replay(t, name)
+	nw.Command(worker.Connect)
+	if transition := waitForState(t, states, network.StateFatal); !strings.Contains(transition.Reason, "corrupt") {
+		t.Errorf("fatal: %s", transition.Reason)
+	}
+	select {
+	case <-term:
+	case <-time.After(5 * time.Second):
+		t.Fatal("termination not requested")
+	}
+}
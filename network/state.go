@@ -0,0 +1,194 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// ConnectionState of the Zello WebSocket connection
+type ConnectionState int
+
+// Connection states
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateAuthenticating
+	StateOnline
+	StateBackoff
+	StateFatal
+)
+
+const (
+	defaultStateHistoryLength = 32
+)
+
+var connectionStateStrings = [...]string{
+	StateDisconnected:   "disconnected",
+	StateConnecting:     "connecting",
+	StateConnected:      "connected",
+	StateAuthenticating: "authenticating",
+	StateOnline:         "online",
+	StateBackoff:        "backoff",
+	StateFatal:          "fatal",
+}
+
+// validTransitions lists the states that may follow each state
+var validTransitions = map[ConnectionState][]ConnectionState{
+	StateDisconnected:   {StateConnecting, StateBackoff, StateFatal},
+	StateConnecting:     {StateConnected, StateDisconnected, StateBackoff, StateFatal},
+	StateConnected:      {StateAuthenticating, StateDisconnected, StateBackoff, StateFatal},
+	StateAuthenticating: {StateOnline, StateDisconnected, StateBackoff, StateFatal},
+	StateOnline:         {StateAuthenticating, StateDisconnected, StateBackoff, StateFatal},
+	StateBackoff:        {StateConnecting, StateDisconnected, StateFatal},
+	StateFatal:          {},
+}
+
+// String returns the name of the state
+func (s ConnectionState) String() string {
+	if s < 0 || int(s) >= len(connectionStateStrings) {
+		return "unknown"
+	}
+	return connectionStateStrings[s]
+}
+
+// MarshalText encodes the state as its name
+func (s ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a state from its name
+func (s *ConnectionState) UnmarshalText(text []byte) error {
+	for i := range connectionStateStrings {
+		if connectionStateStrings[i] == string(text) {
+			*s = ConnectionState(i)
+			return nil
+		}
+	}
+	return errors.Errorf("unknown connection state '%s'", string(text))
+}
+
+// IsConnected is true when the WebSocket is open
+func (s ConnectionState) IsConnected() bool {
+	return s == StateConnected || s == StateAuthenticating || s == StateOnline
+}
+
+// StateTransition records a single change of connection state
+type StateTransition struct {
+	From   ConnectionState `json:"from"`
+	To     ConnectionState `json:"to"`
+	Reason string          `json:"reason,omitempty"`
+	At     time.Time       `json:"at"`
+}
+
+// StateSnapshot is a copy of the current state and recent transitions
+type StateSnapshot struct {
+	State       ConnectionState   `json:"state"`
+	Reason      string            `json:"reason,omitempty"`
+	Since       time.Time         `json:"since"`
+	Transitions []StateTransition `json:"transitions,omitempty"`
+}
+
+// StateMachine tracks the connection state and validates every transition
+type StateMachine struct {
+	sync.Mutex
+	current    ConnectionState
+	reason     string
+	since      time.Time
+	history    []StateTransition
+	maxHistory int
+	now        func() time.Time
+	notify     func(StateTransition)
+}
+
+// NewStateMachine create a new StateMachine in the Disconnected state.
+// notify, if not nil, is called after every transition.
+func NewStateMachine(notify func(StateTransition)) *StateMachine {
+	return newStateMachineWithClock(notify, time.Now)
+}
+
+func newStateMachineWithClock(notify func(StateTransition), now func() time.Time) *StateMachine {
+	return &StateMachine{
+		current:    StateDisconnected,
+		since:      now(),
+		maxHistory: defaultStateHistoryLength,
+		now:        now,
+		notify:     notify,
+	}
+}
+
+// Current returns the current state
+func (m *StateMachine) Current() ConnectionState {
+	m.Lock()
+	defer m.Unlock()
+	return m.current
+}
+
+// CurrentSince returns the current state and the time it was entered
+func (m *StateMachine) CurrentSince() (ConnectionState, time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	return m.current, m.since
+}
+
+// Snapshot returns a copy of the current state and recent transitions
+func (m *StateMachine) Snapshot() StateSnapshot {
+	m.Lock()
+	defer m.Unlock()
+	history := make([]StateTransition, len(m.history))
+	copy(history, m.history)
+	return StateSnapshot{
+		State:       m.current,
+		Reason:      m.reason,
+		Since:       m.since,
+		Transitions: history,
+	}
+}
+
+// Transition moves to a new state. A transition to the current state is
+// ignored; a transition not permitted from the current state is an error.
+func (m *StateMachine) Transition(to ConnectionState, reason string) error {
+	m.Lock()
+	from := m.current
+	if from == to {
+		m.Unlock()
+		return nil
+	}
+	if !transitionAllowed(from, to) {
+		m.Unlock()
+		return errors.Errorf("invalid connection state transition from %s to %s", from, to)
+	}
+	t := StateTransition{
+		From:   from,
+		To:     to,
+		Reason: reason,
+		At:     m.now(),
+	}
+	m.current = to
+	m.reason = reason
+	m.since = t.At
+	m.history = append(m.history, t)
+	if len(m.history) > m.maxHistory {
+		m.history = m.history[len(m.history)-m.maxHistory:]
+	}
+	m.Unlock()
+
+	if m.notify != nil {
+		m.notify(t)
+	}
+	return nil
+}
+
+func transitionAllowed(from ConnectionState, to ConnectionState) bool {
+	for _, s := range validTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// fakeClock advances a second each time it is read
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.now = c.now.Add(time.Second)
+	return c.now
+}
+
+func newTestStateMachine(notify func(StateTransition)) (*StateMachine, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2022, 10, 12, 9, 0, 0, 0, time.UTC)}
+	return newStateMachineWithClock(notify, clock.Now), clock
+}
+
+func TestStateAllowedTransitions(t *testing.T) {
+	m, clock := newTestStateMachine(nil)
+	for _, to := range []ConnectionState{StateConnecting, StateConnected, StateAuthenticating, StateOnline, StateBackoff, StateConnecting} {
+		if err := m.Transition(to, "test"); err != nil {
+			t.Fatalf("transition to %s: %s", to, err)
+		}
+		state, since := m.CurrentSince()
+		if state != to {
+			t.Fatalf("state %s, want %s", state, to)
+		}
+		if !since.Equal(clock.now) {
+			t.Errorf("%s since %s, want %s", to, since, clock.now)
+		}
+	}
+}
+
+func TestStateRejectedTransitions(t *testing.T) {
+	for _, c := range []struct {
+		path []ConnectionState
+		to   ConnectionState
+	}{
+		{nil, StateOnline},
+		{nil, StateAuthenticating},
+		{[]ConnectionState{StateConnecting}, StateOnline},
+		{[]ConnectionState{StateConnecting, StateConnected}, StateConnecting},
+		{[]ConnectionState{StateBackoff}, StateOnline},
+		{[]ConnectionState{StateFatal}, StateConnecting},
+		{[]ConnectionState{StateFatal}, StateDisconnected},
+	} {
+		m, _ := newTestStateMachine(nil)
+		for _, s := range c.path {
+			if err := m.Transition(s, "setup"); err != nil {
+				t.Fatalf("setup transition to %s: %s", s, err)
+			}
+		}
+		from := m.Current()
+		if err := m.Transition(c.to, "test"); err == nil {
+			t.Errorf("transition from %s to %s allowed", from, c.to)
+		}
+		if m.Current() != from {
+			t.Errorf("rejected transition moved state from %s to %s", from, m.Current())
+		}
+	}
+}
+
+func TestStateSameStateIgnored(t *testing.T) {
+	calls := 0
+	m, _ := newTestStateMachine(func(StateTransition) { calls++ })
+	if err := m.Transition(StateDisconnected, "again"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 || len(m.Snapshot().Transitions) != 0 {
+		t.Errorf("transition to the current state recorded, %d notifications", calls)
+	}
+}
+
+func TestStateHistoryBounded(t *testing.T) {
+	m, _ := newTestStateMachine(nil)
+	// Connecting and Backoff alternate indefinitely
+	total := defaultStateHistoryLength + 10
+	for i := 0; i < total; i++ {
+		to := StateConnecting
+		if i%2 == 1 {
+			to = StateBackoff
+		}
+		if err := m.Transition(to, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := m.Snapshot()
+	if len(s.Transitions) != defaultStateHistoryLength {
+		t.Fatalf("%d transitions kept, want %d", len(s.Transitions), defaultStateHistoryLength)
+	}
+	for i := 1; i < len(s.Transitions); i++ {
+		if !s.Transitions[i].At.After(s.Transitions[i-1].At) {
+			t.Fatalf("transition %d at %s is not after %s", i, s.Transitions[i].At, s.Transitions[i-1].At)
+		}
+	}
+	last := s.Transitions[len(s.Transitions)-1]
+	if last.To != s.State || !last.At.Equal(s.Since) {
+		t.Errorf("last transition %+v does not match state %s since %s", last, s.State, s.Since)
+	}
+	// The oldest kept is the first of the final defaultStateHistoryLength
+	if s.Transitions[0].From != StateBackoff || s.Transitions[0].To != StateConnecting {
+		t.Errorf("oldest kept transition %s -> %s", s.Transitions[0].From, s.Transitions[0].To)
+	}
+}
+
+func TestStateNotify(t *testing.T) {
+	var notified []StateTransition
+	var m *StateMachine
+	m, clock := newTestStateMachine(func(t StateTransition) {
+		// Called without the lock held, so the machine can be read
+		if m.Current() != t.To {
+			panic("notified before the state changed")
+		}
+		notified = append(notified, t)
+	})
+	m.Transition(StateConnecting, "dialling")
+	at := clock.now
+	m.Transition(StateOnline, "not allowed")
+	m.Transition(StateConnected, "connected")
+
+	if len(notified) != 2 {
+		t.Fatalf("%d notifications, want 2", len(notified))
+	}
+	want := StateTransition{From: StateDisconnected, To: StateConnecting, Reason: "dialling", At: at}
+	if notified[0] != want {
+		t.Errorf("notified %+v, want %+v", notified[0], want)
+	}
+	if notified[1].From != StateConnecting || notified[1].To != StateConnected {
+		t.Errorf("notified %s -> %s", notified[1].From, notified[1].To)
+	}
+
+	buff, err := json.Marshal(notified[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded StateTransition
+	if err := json.Unmarshal(buff, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.From != want.From || decoded.To != want.To || decoded.Reason != want.Reason || !decoded.At.Equal(want.At) {
+		t.Errorf("%s decoded as %+v", buff, decoded)
+	}
+}
@@ -0,0 +1,112 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/jcmurray/monitor/zellotest"
+	log "github.com/sirupsen/logrus"
+)
+
+// syncBuffer is a log output safe to read while workers write to it
+type syncBuffer struct {
+	sync.Mutex
+	buff bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buff.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buff.String()
+}
+
+func TestTraceLogHasNoLogonSecrets(t *testing.T) {
+	const (
+		password     = "zellotest-password"
+		authToken    = "zellotest-auth-token"
+		refreshToken = "zellotest-refresh-token"
+	)
+	s := zellotest.NewServer()
+	defer s.Close()
+	s.Configure("Test Channel", "monitor", password, authToken)
+
+	// The plain formatter, so that only what the worker logs is checked
+	output := &syncBuffer{}
+	level, out, formatter := log.GetLevel(), log.StandardLogger().Out, log.StandardLogger().Formatter
+	log.SetLevel(log.TraceLevel)
+	log.SetOutput(output)
+	log.SetFormatter(&log.TextFormatter{DisableColors: true})
+	defer func() {
+		log.SetLevel(level)
+		log.SetOutput(out)
+		log.SetFormatter(formatter)
+	}()
+
+	var (
+		workers worker.Workers
+		wg      sync.WaitGroup
+	)
+	term := make(chan int, 1)
+	nw := network.NewNetworker(&workers, 1, "Network Worker")
+	workers = append(workers, nw)
+	connections := nw.Subscribe(2, network.SubscriptionTypeConection, "Test").Channel
+	wg.Add(1)
+	go nw.Run(&wg, &term)
+	defer func() {
+		nw.UnSubscribe(2, network.SubscriptionTypeConection)
+		nw.Terminate()
+		wg.Wait()
+	}()
+	nw.Command(worker.Connect)
+	select {
+	case c := <-connections:
+		if string(c.([]byte)) != network.Connected {
+			t.Fatalf("connection notice %s", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not connected")
+	}
+
+	logon := protocolapp.NewLogon()
+	logon.Channel = "Test Channel"
+	logon.Username = "monitor"
+	logon.Password = password
+	logon.AuthToken = authToken
+	logon.RefreshToken = refreshToken
+	logon.Seq = 1
+	buff, err := json.Marshal(logon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nw.Data(buff)
+	s.ExpectLogon(t, "Test Channel")
+
+	logged := output.String()
+	if !strings.Contains(logged, "Written") {
+		t.Fatalf("logon frame not traced:\n%s", logged)
+	}
+	for _, secret := range []string{password, authToken, refreshToken} {
+		// Either as text or as the decimal bytes of a %v
+		decimal := strings.Trim(fmt.Sprint([]byte(secret)), "[]")
+		if strings.Contains(logged, secret) || strings.Contains(logged, decimal) {
+			t.Errorf("trace log holds %s:\n%s", secret, logged)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultHandshakeTimeout = 45 * time.Second
+)
+
+// TransportConfig describes how the WebSocket to Zello is dialled
+type TransportConfig struct {
+	URL              string
+	CAFile           string
+	CertFile         string
+	KeyFile          string
+	ServerName       string
+	Proxy            string
+	Headers          http.Header
+	HandshakeTimeout time.Duration
+}
+
+// NewTransportConfig create a TransportConfig from the 'server' and
+// 'transport' config sections. server.url, when set, replaces the URL
+// built from server.host and server.port.
+func NewTransportConfig() (*TransportConfig, error) {
+	t := &TransportConfig{
+		URL:              viper.GetString("server.url"),
+		CAFile:           viper.GetString("transport.tls.ca_file"),
+		CertFile:         viper.GetString("transport.tls.cert_file"),
+		KeyFile:          viper.GetString("transport.tls.key_file"),
+		ServerName:       viper.GetString("transport.tls.server_name"),
+		Proxy:            viper.GetString("transport.proxy"),
+		Headers:          make(http.Header),
+		HandshakeTimeout: viper.GetDuration("transport.handshake_timeout"),
+	}
+
+	if t.URL == "" {
+		t.URL = fmt.Sprintf("wss://%s:%d/ws", viper.GetString("server.host"), viper.GetInt("server.port"))
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, errors.Annotate(err, "server.url")
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, errors.Errorf("server.url '%s' must use ws:// or wss://", t.URL)
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, errors.New("transport.tls.cert_file and transport.tls.key_file must be set together")
+	}
+
+	for name, value := range viper.GetStringMapString("transport.headers") {
+		t.Headers.Set(name, value)
+	}
+
+	if t.HandshakeTimeout <= 0 {
+		t.HandshakeTimeout = defaultHandshakeTimeout
+	}
+	return t, nil
+}
+
+// Dialer returns a websocket.Dialer for this transport
+func (t *TransportConfig) Dialer() (*websocket.Dialer, error) {
+	tlsConfig, err := t.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if t.Proxy != "" {
+		proxyURL, err := t.proxyURL()
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &websocket.Dialer{
+		Proxy:            proxy,
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: t.HandshakeTimeout,
+	}, nil
+}
+
+// proxyURL parses the configured proxy. http:// is tunnelled with HTTP
+// CONNECT, socks5:// uses SOCKS5; either may carry user:password.
+func (t *TransportConfig) proxyURL() (*url.URL, error) {
+	u, err := url.Parse(t.Proxy)
+	if err != nil {
+		return nil, errors.Annotate(err, "transport.proxy")
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "socks5":
+		return u, nil
+	default:
+		return nil, errors.Errorf("transport.proxy '%s' must use http:// or socks5://", redactURL(u))
+	}
+}
+
+func (t *TransportConfig) tlsConfig() (*tls.Config, error) {
+	if t.CAFile == "" && t.CertFile == "" && t.ServerName == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName: t.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "transport.tls.ca_file")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("transport.tls.ca_file '%s' contains no certificates", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "transport.tls client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Describe the transport for logging, without proxy credentials
+func (t *TransportConfig) String() string {
+	description := t.URL
+	if t.Proxy != "" {
+		if u, err := url.Parse(t.Proxy); err == nil {
+			description += " via " + redactURL(u)
+		}
+	}
+	return description
+}
+
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	c := *u
+	c.User = url.User(u.User.Username())
+	return c.String()
+}
@@ -0,0 +1,120 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package network_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/jcmurray/monitor/zellotest"
+	"github.com/spf13/viper"
+)
+
+const testChannel = "Test Channel"
+
+// receive waits for a message on a subscription
+func receive(t *testing.T, c chan interface{}, what string) []byte {
+	t.Helper()
+	select {
+	case m := <-c:
+		return m.([]byte)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s received", what)
+	}
+	return nil
+}
+
+func TestFramesReachSubscribers(t *testing.T) {
+	s := zellotest.NewServer()
+	defer s.Close()
+	s.Configure(testChannel, "monitor", "secret", "token")
+	viper.Set("logon.state_file", filepath.Join(t.TempDir(), "state.json"))
+
+	c := s.NewClient()
+	nw := c.Network
+	id := zellotest.FirstWorkerID
+	texts := nw.Subscribe(id, protocolapp.OnTextMessageEvent, "Test").Channel
+	starts := nw.Subscribe(id, protocolapp.OnStreamStartEvent, "Test").Channel
+	packets := nw.Subscribe(id, protocolapp.OnStreamDataEvent, "Test").Channel
+	stops := nw.Subscribe(id, protocolapp.OnStreamStopEvent, "Test").Channel
+	statuses := nw.Subscribe(id, protocolapp.OnChannelStatusEvent, "Test").Channel
+	c.Start(t)
+
+	s.ExpectLogon(t, testChannel)
+	c.WaitForState(t, network.StateOnline)
+	status := protocolapp.NewOnChannelStatus()
+	if err := json.Unmarshal(receive(t, statuses, "channel status"), status); err != nil || status.Status != "online" {
+		t.Fatalf("channel status %+v, %v", status, err)
+	}
+
+	messageID, err := s.SendText(testChannel, "alice", "", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := protocolapp.NewOnTextMessage()
+	if err := json.Unmarshal(receive(t, texts, "text"), text); err != nil || text.MessageID != messageID || text.Text != "hello" {
+		t.Fatalf("text %+v, %v", text, err)
+	}
+
+	streamID, err := s.SendStream(testChannel, "alice", "", [][]byte{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := protocolapp.NewOnStreamStart()
+	if err := json.Unmarshal(receive(t, starts, "stream start"), start); err != nil || start.StreamID != streamID {
+		t.Fatalf("stream start %+v, %v", start, err)
+	}
+	for i := 0; i < 2; i++ {
+		if packet := receive(t, packets, "stream packet"); packet[len(packet)-1] != byte(i+1) {
+			t.Errorf("packet %d is %v", i, packet)
+		}
+	}
+	receive(t, stops, "stream stop")
+
+	// A dropped connection is made again and logged on
+	if err := s.CloseConnection(1001, "going away"); err != nil {
+		t.Fatal(err)
+	}
+	s.ExpectLogon(t, testChannel)
+	c.WaitForState(t, network.StateOnline)
+	receive(t, statuses, "channel status")
+	if _, err := s.SendText(testChannel, "alice", "", "again"); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, texts, "text after reconnecting")
+}
+
+func TestFatalClosesConnection(t *testing.T) {
+	s := zellotest.NewServer()
+	defer s.Close()
+	s.Configure(testChannel, "monitor", "secret", "token")
+	viper.Set("logon.state_file", filepath.Join(t.TempDir(), "state.json"))
+
+	c := s.NewClient()
+	c.Start(t)
+	if err := s.WaitForConnection(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.ExpectLogon(t, testChannel)
+	c.WaitForState(t, network.StateOnline)
+
+	c.Network.SetFatal("test")
+	if err := s.WaitForDisconnection(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fatal is never left, so nothing connects again
+	c.Network.Command(worker.Connect)
+	if err := s.WaitForConnection(200 * time.Millisecond); err == nil {
+		t.Error("connected again after a fatal error")
+	}
+	if state := c.Network.ConnectionState(); state != network.StateFatal {
+		t.Errorf("connection %s", state)
+	}
+}
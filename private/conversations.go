@@ -0,0 +1,100 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package private
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Conversation summarises the private messages exchanged with one user
+type Conversation struct {
+	Peer     string    `json:"peer"`
+	Channel  string    `json:"channel,omitempty"`
+	Last     time.Time `json:"last"`
+	LastKind Kind      `json:"last_kind"`
+	LastText string    `json:"last_text,omitempty"`
+	Received int       `json:"received"`
+	Sent     int       `json:"sent"`
+}
+
+var (
+	lock          sync.Mutex
+	conversations = map[string]*Conversation{}
+)
+
+// Record a private message against its conversation. Only the most
+// recent 'private.conversations' conversations are kept.
+func Record(m Message) {
+	if m.Peer == "" {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	key := strings.ToLower(m.Peer)
+	c, ok := conversations[key]
+	if !ok {
+		c = &Conversation{Peer: m.Peer}
+		conversations[key] = c
+	}
+	if m.Channel != "" {
+		c.Channel = m.Channel
+	}
+	c.Last = m.Time
+	c.LastKind = m.Kind
+	c.LastText = m.Text
+	if m.Outgoing {
+		c.Sent++
+	} else {
+		c.Received++
+	}
+
+	size := viper.GetInt("private.conversations")
+	if size < 1 {
+		size = 1
+	}
+	for len(conversations) > size {
+		oldest := ""
+		for k, v := range conversations {
+			if oldest == "" || v.Last.Before(conversations[oldest].Last) {
+				oldest = k
+			}
+		}
+		delete(conversations, oldest)
+	}
+}
+
+// Recent returns conversations, most recent first. A positive limit
+// returns only that many.
+func Recent(limit int) []Conversation {
+	lock.Lock()
+	defer lock.Unlock()
+	recent := make([]Conversation, 0, len(conversations))
+	for _, c := range conversations {
+		recent = append(recent, *c)
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].Last.After(recent[j].Last)
+	})
+	if limit > 0 && len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent
+}
+
+// LastPeer returns the user of the most recent conversation with a
+// received message, the one a reply with no recipient goes to
+func LastPeer() (string, bool) {
+	for _, c := range Recent(0) {
+		if c.Received > 0 {
+			return c.Peer, true
+		}
+	}
+	return "", false
+}
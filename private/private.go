@@ -0,0 +1,119 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package private detects messages addressed to our user rather than the
+// whole channel and decides how they are routed.
+package private
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jcmurray/monitor/network"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// SubscriptionTypePrivateMessage carries a Message for each private text,
+// voice stream, image or location received or sent
+const SubscriptionTypePrivateMessage = "private_message"
+
+// Kind of private message
+type Kind string
+
+// Private message kinds
+const (
+	KindText     Kind = "text"
+	KindVoice    Kind = "voice"
+	KindImage    Kind = "image"
+	KindLocation Kind = "location"
+)
+
+// Message describes one private message. Peer is the other user in the
+// conversation: the sender of a received message or the recipient of a
+// sent one.
+type Message struct {
+	Kind     Kind      `json:"kind"`
+	Channel  string    `json:"channel,omitempty"`
+	From     string    `json:"from,omitempty"`
+	For      string    `json:"for"`
+	Peer     string    `json:"peer"`
+	ID       int       `json:"id,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	Outgoing bool      `json:"outgoing,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// IsPrivate reports whether a message with the given 'for' field is
+// private. Zello only delivers a message with a recipient to that
+// recipient, so any non-empty value is our user.
+func IsPrivate(forUser string) bool {
+	return strings.TrimSpace(forUser) != ""
+}
+
+// Received builds the Message for private traffic arriving on a channel
+func Received(kind Kind, channel string, from string, forUser string, id int, text string) Message {
+	return Message{
+		Kind:    kind,
+		Channel: channel,
+		From:    from,
+		For:     forUser,
+		Peer:    from,
+		ID:      id,
+		Text:    text,
+		Time:    time.Now().UTC(),
+	}
+}
+
+// Sent builds the Message for private traffic we send
+func Sent(kind Kind, channel string, forUser string, id int, text string) Message {
+	return Message{
+		Kind:     kind,
+		Channel:  channel,
+		From:     viper.GetString("logon.username"),
+		For:      forUser,
+		Peer:     forUser,
+		ID:       id,
+		Text:     text,
+		Outgoing: true,
+		Time:     time.Now().UTC(),
+	}
+}
+
+// Route says how private messages are handled
+type Route struct {
+	Level          log.Level // level private messages are logged at
+	ImageDirectory string    // private images are saved here instead of with the channel's
+	PlayAudio      bool      // play private voice on the speakers
+}
+
+// GetRoute reads the 'private' config section
+func GetRoute() Route {
+	level, err := log.ParseLevel(viper.GetString("private.log_level"))
+	if err != nil {
+		level = log.WarnLevel
+	}
+	return Route{
+		Level:          level,
+		ImageDirectory: viper.GetString("private.image_directory"),
+		PlayAudio:      viper.GetBool("private.play_audio"),
+	}
+}
+
+// Log a private message at the configured level
+func Log(entry *log.Entry, format string, args ...interface{}) {
+	entry.Logf(GetRoute().Level, format, args...)
+}
+
+// Notify records m in the recent conversations and publishes it to
+// subscribers of SubscriptionTypePrivateMessage
+func Notify(nw *network.Networker, m Message) {
+	Record(m)
+	buff, err := json.Marshal(m)
+	if err != nil {
+		log.Errorf("Marshal error: %s", err)
+		return
+	}
+	nw.Publish(SubscriptionTypePrivateMessage, buff)
+}
@@ -7,6 +7,9 @@ package protocolapp
 const (
 	LogonRequest           string = "logon"
 	TextMessageSendRequest string = "send_text_message"
+	StreamStartRequest     string = "start_stream"
+	StreamStopRequest      string = "stop_stream"
+	LocationSendRequest    string = "send_location"
 	OnChannelStatusEvent   string = "on_channel_status"
 	OnErrorEvent           string = "on_error"
 	OnStreamStartEvent     string = "on_stream_start"
@@ -0,0 +1,17 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package protocolapp
+
+// OnError describes an on error message for Zello Websoocket interface
+type OnError struct {
+	Command string `json:"command,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NewOnError returns a new OnError structure
+func NewOnError() *OnError {
+	return &OnError{
+		Command: OnErrorEvent,
+	}
+}
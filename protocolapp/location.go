@@ -22,3 +22,21 @@ func NewOnLocation() *OnLocation {
 		Command: OnLocationEvent,
 	}
 }
+
+// SendLocation message for network worker
+type SendLocation struct {
+	Command          string  `json:"command,omitempty"`
+	Seq              int     `json:"seq,omitempty"`
+	Latitude         float64 `json:"latitude"`
+	Longitude        float64 `json:"longitude"`
+	Accuracy         float64 `json:"accuracy,omitempty"`
+	FormattedAddress string  `json:"formatted_address,omitempty"`
+	For              string  `json:"for,omitempty"`
+}
+
+// NewSendLocation returns a new Zello SendLocation structure
+func NewSendLocation() *SendLocation {
+	return &SendLocation{
+		Command: LocationSendRequest,
+	}
+}
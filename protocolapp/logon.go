@@ -5,14 +5,15 @@ package protocolapp
 
 // Logon describes a logon message for Zello Websoocket interface
 type Logon struct {
-	Command      string `json:"command,omitempty"`
-	Seq          int    `json:"seq,omitempty"`
-	AuthToken    string `json:"auth_token,omitempty"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	Username     string `json:"username,omitempty"`
-	Password     string `json:"password,omitempty"`
-	Channel      string `json:"channel,omitempty"`
-	ListenOnly   bool   `json:"listen_only,omitempty"`
+	Command      string   `json:"command,omitempty"`
+	Seq          int      `json:"seq,omitempty"`
+	AuthToken    string   `json:"auth_token,omitempty"`
+	RefreshToken string   `json:"refresh_token,omitempty"`
+	Username     string   `json:"username,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	Channel      string   `json:"channel,omitempty"`
+	Channels     []string `json:"channels,omitempty"`
+	ListenOnly   bool     `json:"listen_only,omitempty"`
 }
 
 // NewLogon returns a template logon message
@@ -25,5 +26,5 @@ func NewLogon() *Logon {
 
 // IncompleteCredentials checks completeness of logon details
 func (p Logon) IncompleteCredentials() bool {
-	return (p.Channel == "" || p.AuthToken == "")
+	return ((p.Channel == "" && len(p.Channels) == 0) || p.AuthToken == "")
 }
@@ -22,3 +22,24 @@ func NewOnStreamStart() *OnStreamStart {
 		Command: OnStreamStartEvent,
 	}
 }
+
+// StartStream message for network worker
+type StartStream struct {
+	Command        string `json:"command,omitempty"`
+	Seq            int    `json:"seq,omitempty"`
+	Type           string `json:"type,omitempty"`
+	Codec          string `json:"codec,omitempty"`
+	CodecHeader    string `json:"codec_header,omitempty"`
+	PacketDuration int    `json:"packet_duration,omitempty"`
+	For            string `json:"for,omitempty"`
+}
+
+// NewStartStream returns a new Zello StartStream structure for an Opus
+// audio stream
+func NewStartStream() *StartStream {
+	return &StartStream{
+		Command: StreamStartRequest,
+		Type:    "audio",
+		Codec:   "opus",
+	}
+}
@@ -15,3 +15,16 @@ func NewOnStreamStop() *OnStreamStop {
 		Command: OnStreamStopEvent,
 	}
 }
+
+// StopStream message for network worker
+type StopStream struct {
+	Command  string `json:"command,omitempty"`
+	StreamID int    `json:"stream_id,omitempty"`
+}
+
+// NewStopStream returns a new Zello StopStream structure
+func NewStopStream() *StopStream {
+	return &StopStream{
+		Command: StreamStopRequest,
+	}
+}
@@ -0,0 +1,251 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package schedule
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	maxHolidaySkips = 1000
+)
+
+var (
+	// ErrNotFound no announcement has the id
+	ErrNotFound = errors.New("announcement not found")
+	// ErrExists an announcement already has the id
+	ErrExists = errors.New("announcement already exists")
+)
+
+// Announcement is a text message, an audio file or both, sent on a cron
+// schedule, at a fixed interval or after a period of channel activity
+type Announcement struct {
+	ID            string    `json:"id" mapstructure:"id"`
+	Text          string    `json:"text,omitempty" mapstructure:"text"`
+	Audio         string    `json:"audio,omitempty" mapstructure:"audio"`
+	For           string    `json:"for,omitempty" mapstructure:"for"`
+	Cron          string    `json:"cron,omitempty" mapstructure:"cron"`
+	Every         string    `json:"every,omitempty" mapstructure:"every"`
+	AfterActivity string    `json:"after_activity,omitempty" mapstructure:"after_activity"`
+	TimeZone      string    `json:"time_zone,omitempty" mapstructure:"time_zone"`
+	SkipHolidays  bool      `json:"skip_holidays,omitempty" mapstructure:"skip_holidays"`
+	Paused        bool      `json:"paused,omitempty" mapstructure:"paused"`
+	Start         time.Time `json:"start" mapstructure:"-"`
+	LastSent      time.Time `json:"last_sent" mapstructure:"-"`
+	Next          time.Time `json:"next" mapstructure:"-"`
+
+	cron          *CronSchedule
+	every         time.Duration
+	afterActivity time.Duration
+	loc           *time.Location
+	activityFrom  time.Time
+	activitySeen  time.Time
+	sending       bool
+}
+
+// compile checks the announcement and parses its schedule
+func (a *Announcement) compile() error {
+	if a.Text == "" && a.Audio == "" {
+		return errors.Errorf("announcement '%s' has no text or audio", a.ID)
+	}
+	triggers := 0
+	for _, t := range []string{a.Cron, a.Every, a.AfterActivity} {
+		if t != "" {
+			triggers++
+		}
+	}
+	if triggers != 1 {
+		return errors.Errorf("announcement '%s' needs one of cron, every or after_activity", a.ID)
+	}
+	loc, err := time.LoadLocation(a.TimeZone)
+	if err != nil {
+		return errors.Annotatef(err, "announcement '%s' time zone", a.ID)
+	}
+	a.loc = loc
+	switch {
+	case a.Cron != "":
+		if a.cron, err = ParseCron(a.Cron); err != nil {
+			return errors.Annotatef(err, "announcement '%s'", a.ID)
+		}
+		return nil
+	case a.AfterActivity != "":
+		if a.afterActivity, err = time.ParseDuration(a.AfterActivity); err != nil {
+			return errors.Annotatef(err, "announcement '%s' after_activity", a.ID)
+		}
+		if a.afterActivity < time.Minute {
+			return errors.Errorf("announcement '%s' after_activity %s is under a minute", a.ID, a.afterActivity)
+		}
+		return nil
+	}
+	if a.every, err = time.ParseDuration(a.Every); err != nil {
+		return errors.Annotatef(err, "announcement '%s' every", a.ID)
+	}
+	if a.every < time.Minute {
+		return errors.Errorf("announcement '%s' interval %s is under a minute", a.ID, a.every)
+	}
+	return nil
+}
+
+// NextAfter returns when the announcement is next due after now, skipping
+// holidays if asked to, or the zero time if it never is or is sent after
+// channel activity rather than at a time
+func (a *Announcement) NextAfter(now time.Time, holidays Holidays) time.Time {
+	if a.afterActivity > 0 {
+		return time.Time{}
+	}
+	t := now.In(a.loc)
+	for i := 0; i < maxHolidaySkips; i++ {
+		if a.cron != nil {
+			t = a.cron.Next(t)
+		} else {
+			t = a.nextInterval(t)
+		}
+		if t.IsZero() || !a.SkipHolidays || !holidays.Contains(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// nextInterval is the first multiple of the interval from Start after t
+func (a *Announcement) nextInterval(t time.Time) time.Time {
+	if a.Start.After(t) {
+		return a.Start.In(a.loc)
+	}
+	n := t.Sub(a.Start)/a.every + 1
+	return a.Start.Add(n * a.every).In(a.loc)
+}
+
+// Interval between sends, zero for a cron announcement
+func (a Announcement) Interval() time.Duration {
+	return a.every
+}
+
+// ActivityPeriod of channel activity after which the announcement is
+// sent, zero unless it is sent after activity
+func (a Announcement) ActivityPeriod() time.Duration {
+	return a.afterActivity
+}
+
+// activityDue records the first stream heard since the announcement was
+// last due and reports whether the channel has been active for long
+// enough since
+func (a *Announcement) activityDue(lastStart time.Time, now time.Time) bool {
+	if a.activityFrom.IsZero() {
+		if !lastStart.After(a.LastSent) || !lastStart.After(a.activitySeen) {
+			return false
+		}
+		a.activityFrom = lastStart
+	}
+	if now.Sub(a.activityFrom) < a.afterActivity {
+		return false
+	}
+	a.activityFrom = time.Time{}
+	a.activitySeen = now
+	return true
+}
+
+// Holidays are dates on which announcements that skip holidays are not
+// sent. Dates are YYYY-MM-DD for one day or MM-DD for every year.
+type Holidays struct {
+	dates map[string]bool
+}
+
+// NewHolidays parses a list of holiday dates
+func NewHolidays(dates []string) (Holidays, error) {
+	h := Holidays{dates: make(map[string]bool)}
+	for _, d := range dates {
+		d = strings.TrimSpace(d)
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			if _, err := time.Parse("01-02", d); err != nil {
+				return h, errors.Errorf("holiday '%s' is not YYYY-MM-DD or MM-DD", d)
+			}
+		}
+		h.dates[d] = true
+	}
+	return h, nil
+}
+
+// Contains reports whether t falls on a holiday in its own time zone
+func (h Holidays) Contains(t time.Time) bool {
+	return h.dates[t.Format("2006-01-02")] || h.dates[t.Format("01-02")]
+}
+
+// Store holds the announcements and persists them to a JSON file
+type Store struct {
+	file          string
+	announcements map[string]*Announcement
+}
+
+// OpenStore loads the announcements in file, which need not exist yet
+func OpenStore(file string) (*Store, error) {
+	s := &Store{file: file, announcements: make(map[string]*Announcement)}
+	buff, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "read announcements")
+	}
+	var list []*Announcement
+	if err := json.Unmarshal(buff, &list); err != nil {
+		return nil, errors.Annotatef(err, "parse %s", file)
+	}
+	for _, a := range list {
+		if err := a.compile(); err != nil {
+			return nil, err
+		}
+		s.announcements[a.ID] = a
+	}
+	return s, nil
+}
+
+// List returns the announcements sorted by id
+func (s *Store) List() []*Announcement {
+	list := make([]*Announcement, 0, len(s.announcements))
+	for _, a := range s.announcements {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Get an announcement by id
+func (s *Store) Get(id string) (*Announcement, bool) {
+	a, ok := s.announcements[id]
+	return a, ok
+}
+
+// Put adds or replaces an announcement
+func (s *Store) Put(a *Announcement) {
+	s.announcements[a.ID] = a
+}
+
+// Delete an announcement
+func (s *Store) Delete(id string) {
+	delete(s.announcements, id)
+}
+
+// Save writes the announcements to the file, replacing it only once
+// written in full
+func (s *Store) Save() error {
+	if s.file == "" {
+		return nil
+	}
+	buff, err := json.MarshalIndent(s.List(), "", "  ")
+	if err != nil {
+		return errors.Annotate(err, "marshal announcements")
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, append(buff, '\n'), 0600); err != nil {
+		return errors.Annotate(err, "write announcements")
+	}
+	return errors.Annotate(os.Rename(tmp, s.file), "replace announcements")
+}
@@ -0,0 +1,192 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// CronSchedule is a parsed five field cron expression: minute, hour, day
+// of month, month and day of week
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseCron parses a cron expression. Each field may be '*', a value, a
+// range 'a-b', a list 'a,b', and may have a step '/n'. Months and days of
+// the week may be given by their first three letters, and Sunday is 0 or
+// 7. The macros @yearly, @monthly, @weekly, @daily and @hourly are also
+// accepted.
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron expression '%s' needs 5 fields", spec)
+	}
+	var (
+		c   CronSchedule
+		err error
+	)
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, errors.Annotate(err, "minute")
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, errors.Annotate(err, "hour")
+	}
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, errors.Annotate(err, "day of month")
+	}
+	if c.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, errors.Annotate(err, "month")
+	}
+	if c.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, errors.Annotate(err, "day of week")
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return &c, nil
+}
+
+func parseField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.Errorf("invalid step in '%s'", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			value, err := parseValue(part, names)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			if step == 1 {
+				high = value
+			}
+		}
+		if low > high {
+			return 0, errors.Errorf("range '%s' is backwards", part)
+		}
+		if low < min || high > max {
+			return 0, errors.Errorf("'%s' is outside %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Errorf("invalid value '%s'", value)
+	}
+	return n, nil
+}
+
+// Next returns the first time after t, in t's location, matching the
+// schedule, or the zero time if there is none within five years. A wall
+// clock time repeated when the clocks go back matches only once.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	from := t.Truncate(time.Minute)
+	t = from.Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 || sameWallClock(t, from) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron: when both day fields are restricted either
+// may match
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// forward returns next unless a midnight skipped by the clocks going
+// forward was normalised to before t, when it returns the hour after
+func forward(t time.Time, next time.Time) time.Time {
+	for !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+func sameWallClock(a time.Time, b time.Time) bool {
+	return a.Format("2006-01-02 15:04") == b.Format("2006-01-02 15:04")
+}
@@ -0,0 +1,469 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package schedule sends text and voice announcements on cron schedules,
+// at fixed intervals or after a period of channel activity.
+// Announcements come from the config file and can be listed, added,
+// paused and deleted at run time; all are persisted.
+package schedule
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/streams"
+	"github.com/jcmurray/monitor/texts"
+	"github.com/jcmurray/monitor/voice"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	idleWait             = 24 * time.Hour
+	activityPollInterval = 10 * time.Second
+)
+
+// ErrNotRunning the scheduler has not loaded its announcements
+var ErrNotRunning = errors.New("scheduler is not running")
+
+// SchedulerWorker announcement scheduler worker
+type SchedulerWorker struct {
+	sync.Mutex
+	command  chan int
+	log      *log.Entry
+	id       int
+	label    string
+	workers  *worker.Workers
+	store    *Store
+	holidays Holidays
+	wake     chan struct{}
+}
+
+// NewSchedulerWorker create a new SchedulerWorker
+func NewSchedulerWorker(workers *worker.Workers, id int, label string) *SchedulerWorker {
+	return &SchedulerWorker{
+		command: make(chan int, 10),
+		id:      id,
+		label:   label,
+		log:     log.WithFields(log.Fields{"Label": label, "ID": id}),
+		workers: workers,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Run is main function of this worker
+func (w *SchedulerWorker) Run(wg *sync.WaitGroup, term *chan int) {
+	defer wg.Done()
+	w.log.Debugf("Worker Started")
+
+	if err := w.configure(); err != nil {
+		w.log.Errorf("Schedule configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+
+waitloop:
+	for {
+		timer := time.NewTimer(w.untilDue(time.Now()))
+		w.log.Debugf("Entering Select")
+		select {
+		case <-timer.C:
+			w.sendDue(time.Now())
+
+		case <-w.wake:
+
+		case scheduleCommand, more := <-w.command:
+			if more {
+				w.log.Debugf("Received command %d", scheduleCommand)
+				switch scheduleCommand {
+				case worker.Terminate:
+					w.log.Debugf("Terminating")
+					timer.Stop()
+					break waitloop
+				default:
+				}
+			} else {
+				w.log.Info("Channel closed")
+				timer.Stop()
+				break waitloop
+			}
+		}
+		timer.Stop()
+	}
+
+	w.log.Debug("Finished")
+}
+
+// configure reads the 'schedule' config section, loads the persisted
+// announcements and adds any configured ones not already stored
+func (w *SchedulerWorker) configure() error {
+	holidays, err := NewHolidays(viper.GetStringSlice("schedule.holidays"))
+	if err != nil {
+		return errors.Annotate(err, "schedule.holidays")
+	}
+	store, err := OpenStore(viper.GetString("schedule.file"))
+	if err != nil {
+		return err
+	}
+	var configured []*Announcement
+	if err := viper.UnmarshalKey("schedule.announcements", &configured); err != nil {
+		return errors.Annotate(err, "schedule.announcements")
+	}
+
+	now := time.Now()
+	for i, a := range configured {
+		if a.ID == "" {
+			return errors.Errorf("schedule.announcements[%d] has no id", i)
+		}
+		if _, ok := store.Get(a.ID); ok {
+			continue
+		}
+		if err := a.compile(); err != nil {
+			return err
+		}
+		if err := checkAudio(a); err != nil {
+			return err
+		}
+		a.Start = now.Truncate(time.Minute)
+		store.Put(a)
+	}
+	for _, a := range store.List() {
+		if !a.Next.IsZero() && a.Next.Before(now) && !a.Paused {
+			w.log.Warnf("Announcement '%s' due at %s was missed", a.ID, a.Next.Format(time.RFC3339))
+		}
+		a.Next = a.NextAfter(now, holidays)
+	}
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	w.store = store
+	w.holidays = holidays
+	w.log.Infof("Scheduled %d announcements", len(store.announcements))
+	return nil
+}
+
+// untilDue is how long until the next active announcement is due
+func (w *SchedulerWorker) untilDue(now time.Time) time.Duration {
+	w.Lock()
+	defer w.Unlock()
+	wait := idleWait
+	for _, a := range w.store.List() {
+		if a.Paused {
+			continue
+		}
+		if a.afterActivity > 0 && activityPollInterval < wait {
+			wait = activityPollInterval
+		}
+		if a.Next.IsZero() {
+			continue
+		}
+		if d := a.Next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// dueText is a text announcement to hand to the text message worker
+type dueText struct {
+	id      string
+	forUser string
+	text    string
+}
+
+// sendDue sends every active announcement that is due and schedules its
+// next time. Voice announcements are sent in the background, as they may
+// wait for the channel to be quiet. Texts are queued without holding the
+// lock.
+func (w *SchedulerWorker) sendDue(now time.Time) {
+	var lastStart time.Time
+	if sw := w.findStreamWorker(); sw != nil {
+		lastStart = sw.Activity().LastStart
+	}
+	due := w.takeDue(now, lastStart)
+	if len(due) == 0 {
+		return
+	}
+
+	var sent []string
+	for _, d := range due {
+		if err := w.send(d.forUser, d.text); err != nil {
+			w.log.Errorf("Announcement '%s' not sent: %s", d.id, err)
+			continue
+		}
+		w.log.Infof("Announcement '%s' sent for '%s': %s", d.id, d.forUser, d.text)
+		sent = append(sent, d.id)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	for _, id := range sent {
+		if a, ok := w.store.Get(id); ok {
+			a.LastSent = now.UTC()
+		}
+	}
+	if err := w.store.Save(); err != nil {
+		w.log.Errorf("Error saving announcements: %s", err)
+	}
+}
+
+// takeDue schedules the next time of every active announcement that is
+// due, starts the voice ones and returns the text ones
+func (w *SchedulerWorker) takeDue(now time.Time, lastStart time.Time) []dueText {
+	w.Lock()
+	defer w.Unlock()
+	var due []dueText
+	for _, a := range w.store.List() {
+		switch {
+		case a.Paused || a.sending:
+			continue
+		case a.afterActivity > 0:
+			if !a.activityDue(lastStart, now) {
+				continue
+			}
+		case a.Next.IsZero() || a.Next.After(now):
+			continue
+		}
+		a.Next = a.NextAfter(now, w.holidays)
+		if a.Audio != "" {
+			a.sending = true
+			go w.transmit(a.ID, a.Audio, a.For, a.Text)
+			continue
+		}
+		due = append(due, dueText{id: a.ID, forUser: a.For, text: a.Text})
+	}
+	return due
+}
+
+// transmit sends a voice announcement, followed by its text if it has
+// any, and records the outcome
+func (w *SchedulerWorker) transmit(id string, audio string, forUser string, text string) {
+	err := w.sendVoice(audio, forUser)
+	if err == nil && text != "" {
+		err = w.send(forUser, text)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	a, ok := w.store.Get(id)
+	if !ok {
+		return
+	}
+	a.sending = false
+	if err != nil {
+		w.log.Errorf("Announcement '%s' not sent: %s", id, err)
+		return
+	}
+	w.log.Infof("Announcement '%s' sent for '%s': %s", id, forUser, audio)
+	a.LastSent = time.Now().UTC()
+	if err := w.store.Save(); err != nil {
+		w.log.Errorf("Error saving announcements: %s", err)
+	}
+}
+
+// sendVoice hands an audio file to the voice worker
+func (w *SchedulerWorker) sendVoice(audio string, forUser string) error {
+	vw := w.findVoiceWorker()
+	if vw == nil {
+		return errors.New("voice worker is not running")
+	}
+	return vw.Transmit(audio, forUser)
+}
+
+// send queues a text with the text message worker, without waiting for
+// it to be sent
+func (w *SchedulerWorker) send(forUser string, text string) error {
+	if err := capabilities.CheckText(); err != nil {
+		return err
+	}
+	tmw := w.findTextMessageWorker()
+	if tmw == nil {
+		return errors.New("text message worker is not running")
+	}
+	tmw.Enqueue(protocolapp.InternalTextMessageRequest{For: forUser, Message: text})
+	return nil
+}
+
+// List returns a copy of every announcement
+func (w *SchedulerWorker) List() ([]Announcement, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.store == nil {
+		return nil, ErrNotRunning
+	}
+	list := make([]Announcement, 0, len(w.store.announcements))
+	for _, a := range w.store.List() {
+		list = append(list, *a)
+	}
+	return list, nil
+}
+
+// Add an announcement, choosing an id if it has none. Interval
+// announcements count from now unless given a start time.
+func (w *SchedulerWorker) Add(a Announcement) (Announcement, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.store == nil {
+		return a, ErrNotRunning
+	}
+	if a.ID == "" {
+		a.ID = newID()
+	}
+	if _, ok := w.store.Get(a.ID); ok {
+		return a, errors.Annotatef(ErrExists, "'%s'", a.ID)
+	}
+	if err := a.compile(); err != nil {
+		return a, errors.NewNotValid(err, "")
+	}
+	if err := checkAudio(&a); err != nil {
+		return a, errors.NewNotValid(err, "")
+	}
+	now := time.Now()
+	if a.Start.IsZero() {
+		a.Start = now.Truncate(time.Minute)
+	}
+	a.LastSent = time.Time{}
+	a.Next = a.NextAfter(now, w.holidays)
+	w.store.Put(&a)
+	if err := w.store.Save(); err != nil {
+		w.store.Delete(a.ID)
+		return a, err
+	}
+	w.log.Infof("Announcement '%s' added", a.ID)
+	w.poke()
+	return a, nil
+}
+
+// SetPaused pauses or resumes an announcement
+func (w *SchedulerWorker) SetPaused(id string, paused bool) (Announcement, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.store == nil {
+		return Announcement{}, ErrNotRunning
+	}
+	a, ok := w.store.Get(id)
+	if !ok {
+		return Announcement{}, errors.Annotatef(ErrNotFound, "'%s'", id)
+	}
+	a.Paused = paused
+	a.Next = a.NextAfter(time.Now(), w.holidays)
+	if err := w.store.Save(); err != nil {
+		return *a, err
+	}
+	w.log.Infof("Announcement '%s' paused: %t", id, paused)
+	w.poke()
+	return *a, nil
+}
+
+// Delete an announcement
+func (w *SchedulerWorker) Delete(id string) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.store == nil {
+		return ErrNotRunning
+	}
+	if _, ok := w.store.Get(id); !ok {
+		return errors.Annotatef(ErrNotFound, "'%s'", id)
+	}
+	w.store.Delete(id)
+	if err := w.store.Save(); err != nil {
+		return err
+	}
+	w.log.Infof("Announcement '%s' deleted", id)
+	w.poke()
+	return nil
+}
+
+// checkAudio checks an announcement's audio file can be sent
+func checkAudio(a *Announcement) error {
+	if a.Audio == "" {
+		return nil
+	}
+	_, err := voice.ReadOpusFile(a.Audio)
+	return errors.Annotatef(err, "announcement '%s'", a.ID)
+}
+
+// poke wakes Run to recalculate when the next announcement is due
+func (w *SchedulerWorker) poke() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// newID returns a short random announcement id
+func newID() string {
+	buff := make([]byte, 4)
+	rand.Read(buff)
+	return hex.EncodeToString(buff)
+}
+
+// Command sent to this worker
+func (w *SchedulerWorker) Command(c int) {
+	w.command <- c
+}
+
+// Terminate the worker
+func (w *SchedulerWorker) Terminate() {
+	w.Command(worker.Terminate)
+}
+
+// findTextMessageWorker find Text Message worker
+func (w *SchedulerWorker) findTextMessageWorker() *texts.TextMessageWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *texts.TextMessageWorker:
+			return (*w.workers)[i].(*texts.TextMessageWorker)
+		}
+	}
+	return nil
+}
+
+// findVoiceWorker find Voice worker
+func (w *SchedulerWorker) findVoiceWorker() *voice.VoiceWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *voice.VoiceWorker:
+			return (*w.workers)[i].(*voice.VoiceWorker)
+		}
+	}
+	return nil
+}
+
+// findStreamWorker find Stream worker
+func (w *SchedulerWorker) findStreamWorker() *streams.StreamWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *streams.StreamWorker:
+			return (*w.workers)[i].(*streams.StreamWorker)
+		}
+	}
+	return nil
+}
+
+// Label return label of worker
+func (w *SchedulerWorker) Label() string {
+	return w.label
+}
+
+// ID return label of worker
+func (w *SchedulerWorker) ID() int {
+	return w.id
+}
+
+// Subscriptions return a copy of current scubscriptions
+func (w *SchedulerWorker) Subscriptions() []*worker.Subscription {
+	return make([]*worker.Subscription, 0)
+}
@@ -0,0 +1,73 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package secrets
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mask replaces secrets in redacted output
+const Mask = "********"
+
+const (
+	minimumSecretLength = 4
+)
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]struct{}{}
+
+	// jsonSecretFields matches the credential fields of Zello commands
+	jsonSecretFields = regexp.MustCompile(`"(password|auth_token|refresh_token)"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+)
+
+// Register adds a value to be masked wherever it appears. Very short
+// values are ignored since masking them would mangle ordinary text.
+func Register(secret string) {
+	if len(secret) < minimumSecretLength {
+		return
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[secret] = struct{}{}
+}
+
+// Redact masks registered secrets and credential fields in s
+func Redact(s string) string {
+	return string(RedactBytes([]byte(s)))
+}
+
+// RedactBytes masks registered secrets and credential fields in b. b is
+// returned unchanged if it holds no secrets.
+func RedactBytes(b []byte) []byte {
+	if jsonSecretFields.Match(b) {
+		b = jsonSecretFields.ReplaceAll(b, []byte(`"$1"$2"`+Mask+`"`))
+	}
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	for secret := range registry {
+		if bytes.Contains(b, []byte(secret)) {
+			b = bytes.ReplaceAll(b, []byte(secret), []byte(Mask))
+		}
+	}
+	return b
+}
+
+// RedactingFormatter masks secrets in every log entry written by Formatter
+type RedactingFormatter struct {
+	Formatter log.Formatter
+}
+
+// Format the entry and mask any secrets in the result
+func (f *RedactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	buff, err := f.Formatter.Format(entry)
+	if err != nil {
+		return buff, err
+	}
+	return RedactBytes(buff), nil
+}
@@ -0,0 +1,166 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package secrets resolves secret references in configuration values and
+// masks secrets in log output and session captures.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// Reference prefixes for configuration values
+const (
+	EnvPrefix       = "env:"
+	FilePrefix      = "file:"
+	EncryptedPrefix = "enc:"
+	keyLength       = 32
+)
+
+// SensitiveKeys are always registered for redaction, whether or not they
+// are given as references
+var SensitiveKeys = []string{
+	"logon.password",
+	"logon.auth_token",
+	"location.what3wordsapikey",
+	"transport.proxy",
+}
+
+// IsReference reports whether value refers to a secret held elsewhere
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, EnvPrefix) ||
+		strings.HasPrefix(value, FilePrefix) ||
+		strings.HasPrefix(value, EncryptedPrefix)
+}
+
+// Resolve returns the value a reference refers to. env:NAME reads an
+// environment variable, file:PATH the contents of a file without trailing
+// newlines, and enc:DATA decrypts DATA with the key in keyFile. Any other
+// value is returned unchanged.
+func Resolve(value string, keyFile string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, EnvPrefix):
+		name := strings.TrimPrefix(value, EnvPrefix)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.Errorf("environment variable '%s' is not set", name)
+		}
+		return resolved, nil
+
+	case strings.HasPrefix(value, FilePrefix):
+		name := strings.TrimPrefix(value, FilePrefix)
+		buff, err := os.ReadFile(name)
+		if err != nil {
+			return "", errors.Annotate(err, "secret file")
+		}
+		return strings.TrimRight(string(buff), "\r\n"), nil
+
+	case strings.HasPrefix(value, EncryptedPrefix):
+		return Decrypt(value, keyFile)
+	}
+	return value, nil
+}
+
+// ResolveConfig replaces every reference in the configuration with the
+// value it refers to, and registers resolved and sensitive values for
+// redaction
+func ResolveConfig() error {
+	keyFile := viper.GetString("secrets.keyfile")
+	for _, key := range viper.AllKeys() {
+		value, ok := viper.Get(key).(string)
+		if !ok || !IsReference(value) {
+			continue
+		}
+		resolved, err := Resolve(value, keyFile)
+		if err != nil {
+			return errors.Annotate(err, key)
+		}
+		viper.Set(key, resolved)
+		Register(resolved)
+	}
+	for _, key := range SensitiveKeys {
+		Register(viper.GetString(key))
+	}
+	return nil
+}
+
+// GenerateKey writes a new random key to keyFile, readable only by its owner.
+// An existing file is not overwritten.
+func GenerateKey(keyFile string) error {
+	key := make([]byte, keyLength)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return errors.Annotate(err, "generate key")
+	}
+	f, err := os.OpenFile(keyFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Annotate(err, "key file")
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return errors.Annotate(err, "key file")
+	}
+	return errors.Annotate(f.Close(), "key file")
+}
+
+// Encrypt returns an enc: reference holding plaintext encrypted with
+// AES-256-GCM using the key in keyFile
+func Encrypt(plaintext string, keyFile string) (string, error) {
+	aead, err := newAEAD(keyFile)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Annotate(err, "generate nonce")
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of an enc: reference
+func Decrypt(value string, keyFile string) (string, error) {
+	aead, err := newAEAD(keyFile)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return "", errors.Annotate(err, "encrypted value")
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("encrypted value cannot be decrypted with this key")
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(keyFile string) (cipher.AEAD, error) {
+	if keyFile == "" {
+		return nil, errors.New("secrets.keyfile is not set")
+	}
+	buff, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Annotate(err, "key file")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buff)))
+	if err != nil || len(key) != keyLength {
+		return nil, errors.Errorf("key file '%s' does not hold a base64 encoded %d byte key", keyFile, keyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Annotate(err, "key")
+	}
+	return cipher.NewGCM(block)
+}
@@ -100,3 +100,12 @@ func RemoveEntry(id int, seq int) {
 		}
 	}
 }
+
+// Reset forgets every expected response, for example when the channel
+// changes and responses to earlier requests will never be matched.
+// Sequence numbers continue to increase.
+func Reset() {
+	sequenceLock.Lock()
+	defer sequenceLock.Unlock()
+	expectedResponseList = nil
+}
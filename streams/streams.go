@@ -8,19 +8,41 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/jcmurray/monitor/audiodecoder"
+	"github.com/jcmurray/monitor/heard"
 	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
 	"github.com/jcmurray/monitor/protocolapp"
 	"github.com/jcmurray/monitor/errorcodes"
 	"github.com/jcmurray/monitor/worker"
 	log "github.com/sirupsen/logrus"
 )
 
-const ()
+const (
+	staleStreamAfter = 30 * time.Second
+)
 
 type streamsInfo map[int]*StreamInfo
 
+// Activity of voice streams on the channel
+type Activity struct {
+	Active    int       // streams started and not yet stopped
+	LastStart time.Time // most recent stream start
+	Last      time.Time // most recent stream start, packet or stop
+}
+
+// Quiet reports whether no stream has been heard for period. A stream
+// without packets for a while is taken to have ended without a stop.
+func (a Activity) Quiet(now time.Time, period time.Duration) bool {
+	idle := now.Sub(a.Last)
+	if a.Active > 0 && idle < staleStreamAfter {
+		return false
+	}
+	return idle >= period
+}
+
 // StreamInfo persist stream information
 type StreamInfo struct {
 	Codec          string
@@ -32,6 +54,7 @@ type StreamInfo struct {
 	For            string
 	SampleRate     int
 	FrameSizeMs    int
+	Private        bool
 }
 
 // StreamWorker stream worker
@@ -43,6 +66,8 @@ type StreamWorker struct {
 	label         string
 	workers       *worker.Workers
 	activeStreams streamsInfo
+	activity      Activity
+	finalise      chan worker.FinaliseRequest
 }
 
 // NewStreamWorker create a new Streamworker
@@ -54,6 +79,7 @@ func NewStreamWorker(workers *worker.Workers, id int, label string) *StreamWorke
 		log:           log.WithFields(log.Fields{"Label": label, "ID": id}),
 		workers:       workers,
 		activeStreams: make(streamsInfo),
+		finalise:      make(chan worker.FinaliseRequest),
 	}
 }
 
@@ -73,7 +99,12 @@ waitloop:
 		w.log.Tracef("Entering Select")
 		select {
 		case errorMessage := <-errorChannel:
-			w.log.Debugf("Response: %s", errorcodes.Description(string(errorMessage.([]byte))))
+			e, err := errorcodes.FromMessage(errorMessage.([]byte))
+			if err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.onError(e)
 
 		case streamStart := <-streamStartChannel:
 
@@ -97,8 +128,16 @@ waitloop:
 				CodecHeader:    c.CodecHeader,
 				SampleRate:     int(binary.LittleEndian.Uint16(codecHeader[0:2])),
 				FrameSizeMs:    int(codecHeader[3]),
+				Private:        private.IsPrivate(c.For),
 			}
+			heard.Heard(c.From, c.Channel, heard.KindVoice)
+			w.heard(len(w.activeStreams), true)
 
+			if w.activeStreams[c.StreamID].Private {
+				private.Log(w.log, "Private stream id %d Started - from '%s' on '%s' for '%s'", c.StreamID, c.From, c.Channel, c.For)
+				private.Notify(nw, private.Received(private.KindVoice, c.Channel, c.From, c.For, c.StreamID, ""))
+				continue
+			}
 			w.log.Infof("Stream id %d Started - from '%s' on '%s' for '%s'", c.StreamID, c.From, c.Channel, c.For)
 
 		case streamStop := <-streamStopChannel:
@@ -112,6 +151,7 @@ waitloop:
 			if si, ok := w.activeStreams[int(c.StreamID)]; ok {
 				w.log.Infof("Stream id %d Stopped - from '%s' on '%s' for '%s'", c.StreamID, si.From, si.Channel, si.For)
 				delete(w.activeStreams, c.StreamID)
+				w.heard(len(w.activeStreams), false)
 			}
 			continue
 
@@ -121,7 +161,11 @@ waitloop:
 			packetID := binary.BigEndian.Uint32(message[5:9])
 			data := message[9:]
 
-			if _, ok := w.activeStreams[int(streamID)]; ok {
+			if si, ok := w.activeStreams[int(streamID)]; ok {
+				w.heard(len(w.activeStreams), false)
+				if si.Private && !private.GetRoute().PlayAudio {
+					continue
+				}
 				w.log.Tracef("Start of Opus Packet %d received on stream ID %d: %#v ...", packetID, streamID, data[:6])
 				au := w.findAudioWorker()
 				au.Data(data)
@@ -130,6 +174,10 @@ waitloop:
 			}
 			continue
 
+		case r := <-w.finalise:
+			w.endStreams(r.Reason)
+			close(r.Done)
+
 		case streamCommand, more := <-w.command:
 			if more {
 				w.log.Debugf("Received command %d", streamCommand)
@@ -165,6 +213,51 @@ func (w *StreamWorker) Terminate() {
 	w.Command(worker.Terminate)
 }
 
+// heard records stream activity
+func (w *StreamWorker) heard(active int, started bool) {
+	w.Lock()
+	defer w.Unlock()
+	now := time.Now()
+	w.activity.Active = active
+	w.activity.Last = now
+	if started {
+		w.activity.LastStart = now
+	}
+}
+
+// Activity returns the current voice stream activity
+func (w *StreamWorker) Activity() Activity {
+	w.Lock()
+	defer w.Unlock()
+	return w.activity
+}
+
+// onError reacts to an on_error event. Active streams will not be
+// stopped by Zello once the session ends.
+func (w *StreamWorker) onError(e *errorcodes.Error) {
+	reaction := errorcodes.React(e)
+	w.log.Debugf("Error: %s (%s, %s)", e.Description, e.Category, reaction)
+	if reaction == errorcodes.ReactReset {
+		w.endStreams(e.Code)
+	}
+}
+
+// endStreams finalises every active stream
+func (w *StreamWorker) endStreams(reason string) {
+	for id, si := range w.activeStreams {
+		w.log.Infof("Stream id %d Finalised - from '%s' on '%s' for '%s': %s", id, si.From, si.Channel, si.For, reason)
+		delete(w.activeStreams, id)
+	}
+	w.Lock()
+	w.activity.Active = 0
+	w.Unlock()
+}
+
+// Finalise active streams before the channel changes
+func (w *StreamWorker) Finalise(reason string) bool {
+	return worker.Finalise(w.finalise, reason, worker.FinaliseTimeout)
+}
+
 // FindNetWorker find Net worker
 func (w *StreamWorker) findNetWorker() *network.Networker {
 	for i := range *w.workers {
@@ -0,0 +1,108 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package streams_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/streams"
+	"github.com/jcmurray/monitor/zellotest"
+	"github.com/spf13/viper"
+)
+
+const testChannel = "Test Channel"
+
+// start runs a stream worker against a logged on test server
+func start(t *testing.T) (*zellotest.Server, *zellotest.Client, *streams.StreamWorker) {
+	t.Helper()
+	s := zellotest.NewServer()
+	t.Cleanup(s.Close)
+	s.Configure(testChannel, "monitor", "secret", "token")
+	viper.Set("logon.state_file", filepath.Join(t.TempDir(), "state.json"))
+	viper.Set("private.play_audio", false)
+
+	c := s.NewClient()
+	sw := streams.NewStreamWorker(&c.Workers, zellotest.FirstWorkerID, "Stream Worker")
+	c.Add(sw)
+	c.Start(t)
+	s.ExpectLogon(t, testChannel)
+	c.WaitForState(t, network.StateOnline)
+	return s, c, sw
+}
+
+func TestPrivateStream(t *testing.T) {
+	logs := zellotest.WatchLogs(t)
+	s, c, sw := start(t)
+	messages := c.Network.Subscribe(zellotest.FirstWorkerID+1, private.SubscriptionTypePrivateMessage, "Test").Channel
+
+	id, err := s.SendStream(testChannel, "alice", "monitor", [][]byte{{1, 2, 3, 4, 5, 6}, {7, 8, 9, 10, 11, 12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-messages:
+		var p private.Message
+		if err := json.Unmarshal(m.([]byte), &p); err != nil || p.Kind != private.KindVoice || p.ID != id || p.Peer != "alice" {
+			t.Fatalf("private message %+v, %v", p, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no private message published")
+	}
+	logs.Expect(t, fmt.Sprintf("Stream id %d Stopped", id))
+
+	// The worker handles one event at a time, so once Finalise returns
+	// the stop has been recorded
+	if !sw.Finalise("test") {
+		t.Fatal("stream worker not finalised")
+	}
+	if a := sw.Activity(); a.Active != 0 || a.LastStart.IsZero() || a.Last.Before(a.LastStart) {
+		t.Errorf("activity %+v", a)
+	}
+}
+
+func TestStreamEndedBySessionError(t *testing.T) {
+	logs := zellotest.WatchLogs(t)
+	s, _, sw := start(t)
+
+	// A stream is left open, with no on_stream_stop
+	header := base64.StdEncoding.EncodeToString([]byte{0x80, 0x3e, 2, 60})
+	err := s.SendJSON(&protocolapp.OnStreamStart{
+		Command:     protocolapp.OnStreamStartEvent,
+		Type:        "audio",
+		Codec:       "opus",
+		StreamID:    43,
+		Channel:     testChannel,
+		From:        "alice",
+		For:         "monitor",
+		CodecHeader: header,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs.Expect(t, "Private stream id 43 Started")
+	if a := sw.Activity(); a.Active != 1 {
+		t.Fatalf("%d active streams", a.Active)
+	}
+
+	// The session has ended, so Zello will never stop the stream
+	if err := s.SendError("not authorized"); err != nil {
+		t.Fatal(err)
+	}
+	logs.Expect(t, "Stream id 43 Finalised")
+	s.ExpectLogon(t, testChannel)
+	if !sw.Finalise("test") {
+		t.Fatal("stream worker not finalised")
+	}
+	if a := sw.Activity(); a.Active != 0 {
+		t.Errorf("%d active streams after the session error", a.Active)
+	}
+}
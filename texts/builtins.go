@@ -0,0 +1,121 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package texts
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jcmurray/monitor/channelstatus"
+	"github.com/jcmurray/monitor/heard"
+	"github.com/juju/errors"
+)
+
+const (
+	lastHeardCount = 5
+)
+
+// registerBuiltins adds the commands every monitor answers
+func (w *TextMessageWorker) registerBuiltins() {
+	w.commands.Register(Command{Name: "help", Summary: "list the commands you can use", Handler: w.helpCommand})
+	w.commands.Register(Command{Name: "status", Summary: "channel and connection status", Handler: w.statusCommand})
+	w.commands.Register(Command{Name: "last", Usage: "[user]", Summary: "who was heard last, or when a user was", Handler: lastCommand})
+	w.commands.Register(Command{Name: "where", Usage: "<user>", Summary: "last position reported by a user", Handler: whereCommand})
+	w.commands.Register(Command{Name: "uptime", Summary: "how long the monitor has been running", Handler: w.uptimeCommand})
+}
+
+func (w *TextMessageWorker) helpCommand(r CommandRequest) (string, error) {
+	var commands []string
+	for _, c := range w.commands.Commands(r.From) {
+		usage := w.commands.Prefix() + c.Name
+		if c.Usage != "" {
+			usage += " " + c.Usage
+		}
+		commands = append(commands, fmt.Sprintf("%s - %s", usage, c.Summary))
+	}
+	return strings.Join(commands, "\n"), nil
+}
+
+func (w *TextMessageWorker) statusCommand(r CommandRequest) (string, error) {
+	nw := w.findNetWorker()
+	connection := nw.StateSnapshot()
+	text := fmt.Sprintf("Connection %s for %s", connection.State, ago(connection.Since))
+
+	sw := w.findStatusWorker()
+	if sw == nil {
+		return text, nil
+	}
+	current, ok := sw.LatestStatus()
+	if !ok {
+		return text + ", no channel status yet", nil
+	}
+	text = fmt.Sprintf("Channel '%s' %s, %d users online. %s", current.Channel, current.Status, current.UsersOnline, text)
+	if state := sw.ChannelState(); state.Reason != "" {
+		text += fmt.Sprintf(". Channel %s: %s", state.Condition, state.Reason)
+	}
+	return text, nil
+}
+
+func lastCommand(r CommandRequest) (string, error) {
+	if len(r.Args) > 0 {
+		s, ok := heard.Get(r.Args[0])
+		if !ok {
+			return fmt.Sprintf("%s has not been heard", r.Args[0]), nil
+		}
+		return fmt.Sprintf("%s last heard %s ago on '%s' (%s)", s.User, ago(s.Last), s.Channel, s.Kind), nil
+	}
+	recent := heard.Recent(lastHeardCount)
+	if len(recent) == 0 {
+		return "Nobody has been heard yet", nil
+	}
+	var stations []string
+	for _, s := range recent {
+		stations = append(stations, fmt.Sprintf("%s %s ago (%s)", s.User, ago(s.Last), s.Kind))
+	}
+	return "Last heard: " + strings.Join(stations, ", "), nil
+}
+
+func whereCommand(r CommandRequest) (string, error) {
+	if len(r.Args) == 0 {
+		return "", errors.New("give the user to locate")
+	}
+	s, ok := heard.Get(r.Args[0])
+	if !ok || s.Position == nil {
+		return fmt.Sprintf("No position from %s", r.Args[0]), nil
+	}
+	p := s.Position
+	text := fmt.Sprintf("%s at %.5f, %.5f", s.User, p.Latitude, p.Longitude)
+	if p.Accuracy > 0 {
+		text += fmt.Sprintf(" (±%.f m)", p.Accuracy)
+	}
+	if p.Address != "" {
+		text += " " + p.Address
+	}
+	return text + fmt.Sprintf(", %s ago", ago(p.Time)), nil
+}
+
+func (w *TextMessageWorker) uptimeCommand(r CommandRequest) (string, error) {
+	text := fmt.Sprintf("Up %s since %s", ago(w.started), w.started.UTC().Format("2006-01-02 15:04 MST"))
+	if connection := w.findNetWorker().StateSnapshot(); connection.State.IsConnected() {
+		text += fmt.Sprintf(", connected to Zello for %s", ago(connection.Since))
+	}
+	return text, nil
+}
+
+// ago is the time since t, to the second
+func ago(t time.Time) time.Duration {
+	return time.Since(t).Round(time.Second)
+}
+
+// findStatusWorker find Status worker
+func (w *TextMessageWorker) findStatusWorker() *channelstatus.StatusWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *channelstatus.StatusWorker:
+			return (*w.workers)[i].(*channelstatus.StatusWorker)
+		}
+	}
+	return nil
+}
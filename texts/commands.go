@@ -0,0 +1,235 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package texts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// Command failures
+var (
+	ErrCommandDenied      = errors.New("not allowed to use the command")
+	ErrCommandRateLimited = errors.New("too many commands")
+)
+
+// Where command replies are sent
+const (
+	ReplySame    = "same"    // privately for private commands, otherwise to the channel
+	ReplyPrivate = "private" // always privately to the sender
+	ReplyChannel = "channel" // always to the channel
+)
+
+// CommandRequest is a command received in a text message
+type CommandRequest struct {
+	Name    string
+	Args    []string
+	From    string
+	Channel string
+	Private bool
+}
+
+// CommandHandler answers a command. It runs on the text worker's
+// goroutine so must not block. An empty reply sends nothing.
+type CommandHandler func(r CommandRequest) (string, error)
+
+// Command is a chat command
+type Command struct {
+	Name    string // without the prefix
+	Usage   string // arguments, for !help
+	Summary string
+	Handler CommandHandler
+}
+
+// CommandRouter dispatches text messages starting with the command prefix
+// to their handlers, checking the sender is allowed and not sending too
+// many
+type CommandRouter struct {
+	sync.Mutex
+	enabled  bool
+	prefix   string
+	reply    string
+	commands map[string]Command
+	allow    map[string][]string
+	limit    int
+	window   time.Duration
+	used     map[string][]time.Time
+	now      func() time.Time
+}
+
+// NewCommandRouter create a CommandRouter with no commands. It answers
+// nothing until configured.
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{
+		commands: make(map[string]Command),
+		allow:    make(map[string][]string),
+		used:     make(map[string][]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Configure the router from the 'texts.commands' config section
+func (r *CommandRouter) Configure() error {
+	r.Lock()
+	defer r.Unlock()
+	r.enabled = viper.GetBool("texts.commands.enabled")
+	r.prefix = viper.GetString("texts.commands.prefix")
+	r.reply = strings.ToLower(viper.GetString("texts.commands.reply"))
+	r.limit = viper.GetInt("texts.commands.rate_limit")
+	r.window = viper.GetDuration("texts.commands.rate_window")
+	if r.prefix == "" {
+		return errors.New("texts.commands.prefix must not be empty")
+	}
+	switch r.reply {
+	case ReplySame, ReplyPrivate, ReplyChannel:
+	default:
+		return errors.Errorf("texts.commands.reply '%s' must be same, private or channel", r.reply)
+	}
+	if r.limit > 0 && r.window <= 0 {
+		return errors.New("texts.commands.rate_window must be positive")
+	}
+	r.allow = make(map[string][]string)
+	for name, users := range viper.GetStringMapStringSlice("texts.commands.allow") {
+		r.allow[strings.ToLower(name)] = users
+	}
+	return nil
+}
+
+// Register a command, replacing any with the same name
+func (r *CommandRouter) Register(c Command) {
+	r.Lock()
+	defer r.Unlock()
+	r.commands[strings.ToLower(c.Name)] = c
+}
+
+// Dispatch runs the command in a text message and returns the reply to
+// send. It returns nil with no error when the message is not a command.
+func (r *CommandRouter) Dispatch(m *protocolapp.OnTextMessage) (*protocolapp.InternalTextMessageRequest, error) {
+	r.Lock()
+	enabled, prefix, replyTo := r.enabled, r.prefix, r.reply
+	r.Unlock()
+	if !enabled || strings.EqualFold(m.From, viper.GetString("logon.username")) {
+		return nil, nil
+	}
+	text := strings.TrimSpace(m.Text)
+	if !strings.HasPrefix(text, prefix) {
+		return nil, nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(text, prefix))
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	name := strings.ToLower(fields[0])
+
+	r.Lock()
+	c, ok := r.commands[name]
+	allowed := r.allowed(name, m.From)
+	r.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	if !allowed {
+		return nil, errors.Annotatef(ErrCommandDenied, "%s%s from '%s'", prefix, name, m.From)
+	}
+	if !r.take(m.From) {
+		return nil, errors.Annotatef(ErrCommandRateLimited, "%s%s from '%s'", prefix, name, m.From)
+	}
+
+	request := CommandRequest{
+		Name:    name,
+		Args:    fields[1:],
+		From:    m.From,
+		Channel: m.Channel,
+		Private: m.For != "",
+	}
+	text, err := c.Handler(request)
+	if err != nil {
+		text = fmt.Sprintf("%s%s: %s", prefix, name, err)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	reply := &protocolapp.InternalTextMessageRequest{Message: text}
+	if replyTo == ReplyPrivate || (replyTo == ReplySame && request.Private) {
+		reply.For = m.From
+	}
+	return reply, nil
+}
+
+// Allowed reports whether user may use the command. A command without
+// its own list uses the 'default' list; with neither anyone may use it.
+func (r *CommandRouter) Allowed(name string, user string) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.allowed(name, user)
+}
+
+// allowed checks the permission lists. lock must be held.
+func (r *CommandRouter) allowed(name string, user string) bool {
+	users, ok := r.allow[strings.ToLower(name)]
+	if !ok {
+		users, ok = r.allow["default"]
+	}
+	if !ok {
+		return true
+	}
+	for _, u := range users {
+		if u == "*" || strings.EqualFold(u, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// take uses one of user's commands in the rate limit window, returning
+// false if none are left
+func (r *CommandRouter) take(user string) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.limit <= 0 {
+		return true
+	}
+	now := r.now()
+	key := strings.ToLower(user)
+	var recent []time.Time
+	for _, t := range r.used[key] {
+		if now.Sub(t) < r.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= r.limit {
+		r.used[key] = recent
+		return false
+	}
+	r.used[key] = append(recent, now)
+	return true
+}
+
+// Commands returns the commands user may use, sorted by name
+func (r *CommandRouter) Commands(user string) []Command {
+	r.Lock()
+	defer r.Unlock()
+	var commands []Command
+	for name, c := range r.commands {
+		if r.allowed(name, user) {
+			commands = append(commands, c)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// Prefix commands start with
+func (r *CommandRouter) Prefix() string {
+	r.Lock()
+	defer r.Unlock()
+	return r.prefix
+}
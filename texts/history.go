@@ -0,0 +1,367 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package texts
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/juju/errors"
+)
+
+// Directions of a TextRecord
+const (
+	DirectionReceived = "received"
+	DirectionSent     = "sent"
+)
+
+// TextRecord is one text message received or sent
+type TextRecord struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Channel   string    `json:"channel,omitempty"`
+	From      string    `json:"from,omitempty"`
+	For       string    `json:"for,omitempty"`
+	MessageID int       `json:"message_id,omitempty"`
+	Text      string    `json:"text"`
+}
+
+// TextQuery selects records from a TextHistory. Empty fields match
+// everything. Text matches records containing every word given, a word
+// ending in '*' matching any word starting with it.
+type TextQuery struct {
+	Since   time.Time
+	Until   time.Time
+	From    string
+	For     string
+	Channel string
+	Text    string
+	Limit   int // only the most recent records, 0 for all
+}
+
+// TextHistory keeps text messages in memory, indexed by word, and in an
+// append-only JSON lines file
+type TextHistory struct {
+	sync.Mutex
+	fileName  string
+	file      *os.File
+	retention time.Duration
+	nextID    uint64
+	records   []TextRecord
+	index     map[string][]uint64
+}
+
+// OpenTextHistory loads the history kept in fileName, dropping records
+// older than retention. An empty fileName keeps the history in memory
+// only and a zero retention keeps records for ever.
+func OpenTextHistory(fileName string, retention time.Duration) (*TextHistory, error) {
+	h := &TextHistory{
+		fileName:  fileName,
+		retention: retention,
+		nextID:    1,
+		index:     make(map[string][]uint64),
+	}
+	if fileName == "" {
+		return h, nil
+	}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	if err := h.Prune(time.Now()); err != nil {
+		return nil, err
+	}
+	if h.file == nil {
+		f, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Annotate(err, "open text history file")
+		}
+		h.file = f
+	}
+	return h, nil
+}
+
+func (h *TextHistory) load() error {
+	f, err := os.Open(h.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Annotate(err, "open text history file")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var r TextRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return errors.Annotatef(err, "text history file %s line %d", h.fileName, line)
+		}
+		h.insert(r)
+	}
+	return errors.Annotate(scanner.Err(), "read text history file")
+}
+
+// insert a record already holding its ID
+func (h *TextHistory) insert(r TextRecord) {
+	h.records = append(h.records, r)
+	for _, word := range words(r.Text) {
+		ids := h.index[word]
+		if n := len(ids); n == 0 || ids[n-1] != r.ID {
+			h.index[word] = append(ids, r.ID)
+		}
+	}
+	if r.ID >= h.nextID {
+		h.nextID = r.ID + 1
+	}
+}
+
+// Add a record, giving it the next ID, and write it to the file
+func (h *TextHistory) Add(r TextRecord) (TextRecord, error) {
+	h.Lock()
+	defer h.Unlock()
+	r.ID = h.nextID
+	r.Time = r.Time.UTC()
+	h.insert(r)
+	if h.file == nil {
+		return r, nil
+	}
+	buff, err := json.Marshal(r)
+	if err != nil {
+		return r, errors.Annotate(err, "marshal text record")
+	}
+	_, err = h.file.Write(append(buff, '\n'))
+	return r, errors.Annotate(err, "write text history file")
+}
+
+// Prune drops records older than the retention period and rewrites the
+// file without them
+func (h *TextHistory) Prune(now time.Time) error {
+	if h.retention <= 0 {
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	cutoff := now.Add(-h.retention)
+	keep := sort.Search(len(h.records), func(i int) bool {
+		return !h.records[i].Time.Before(cutoff)
+	})
+	if keep == 0 {
+		return nil
+	}
+	records := h.records[keep:]
+	h.records = nil
+	h.index = make(map[string][]uint64)
+	for _, r := range records {
+		h.insert(r)
+	}
+	if h.fileName == "" {
+		return nil
+	}
+	return h.rewrite()
+}
+
+// rewrite the file from the records in memory
+func (h *TextHistory) rewrite() error {
+	temp := h.fileName + ".tmp"
+	f, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Annotate(err, "rewrite text history file")
+	}
+	if err := WriteJSONL(f, h.records); err != nil {
+		f.Close()
+		return errors.Annotate(err, "rewrite text history file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Annotate(err, "rewrite text history file")
+	}
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+	if err := os.Rename(temp, h.fileName); err != nil {
+		return errors.Annotate(err, "rewrite text history file")
+	}
+	h.file, err = os.OpenFile(h.fileName, os.O_APPEND|os.O_WRONLY, 0600)
+	return errors.Annotate(err, "open text history file")
+}
+
+// Query returns the matching records, oldest first
+func (h *TextHistory) Query(q TextQuery) []TextRecord {
+	h.Lock()
+	defer h.Unlock()
+
+	candidates := h.records
+	if terms := words(q.Text); len(terms) > 0 {
+		candidates = nil
+		for _, id := range h.search(terms) {
+			i := sort.Search(len(h.records), func(i int) bool { return h.records[i].ID >= id })
+			if i < len(h.records) && h.records[i].ID == id {
+				candidates = append(candidates, h.records[i])
+			}
+		}
+	}
+
+	var records []TextRecord
+	for _, r := range candidates {
+		switch {
+		case !q.Since.IsZero() && r.Time.Before(q.Since):
+		case !q.Until.IsZero() && !r.Time.Before(q.Until):
+		case q.From != "" && !strings.EqualFold(q.From, r.From):
+		case q.For != "" && !strings.EqualFold(q.For, r.For):
+		case q.Channel != "" && !strings.EqualFold(q.Channel, r.Channel):
+		default:
+			records = append(records, r)
+		}
+	}
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[len(records)-q.Limit:]
+	}
+	return records
+}
+
+// search returns the IDs, in order, of records containing every term
+func (h *TextHistory) search(terms []string) []uint64 {
+	var result []uint64
+	for i, term := range terms {
+		var ids []uint64
+		if prefix := strings.TrimSuffix(term, "*"); prefix != term {
+			for word, wordIDs := range h.index {
+				if strings.HasPrefix(word, prefix) {
+					ids = union(ids, wordIDs)
+				}
+			}
+		} else {
+			ids = h.index[term]
+		}
+		if i == 0 {
+			result = ids
+		} else {
+			result = intersect(result, ids)
+		}
+		if len(result) == 0 {
+			return nil
+		}
+	}
+	return result
+}
+
+// Close the history file
+func (h *TextHistory) Close() error {
+	h.Lock()
+	defer h.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// words splits text into lower case words for the index. A trailing '*'
+// is kept so queries can ask for a prefix.
+func words(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '*'
+	})
+	var result []string
+	for _, f := range fields {
+		f = strings.TrimLeft(f, "*")
+		if i := strings.IndexByte(f, '*'); i >= 0 {
+			f = f[:i+1]
+		}
+		if f != "" && f != "*" {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func intersect(a []uint64, b []uint64) []uint64 {
+	var result []uint64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+func union(a []uint64, b []uint64) []uint64 {
+	result := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+// WriteJSONL writes records as one JSON object per line
+func WriteJSONL(w io.Writer, records []TextRecord) error {
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var textCSVHeader = []string{"id", "time", "direction", "channel", "from", "for", "message_id", "text"}
+
+// WriteCSV writes records with a header row
+func WriteCSV(w io.Writer, records []TextRecord) error {
+	c := csv.NewWriter(w)
+	if err := c.Write(textCSVHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := []string{
+			strconv.FormatUint(r.ID, 10),
+			r.Time.Format(time.RFC3339),
+			r.Direction,
+			r.Channel,
+			r.From,
+			r.For,
+			strconv.Itoa(r.MessageID),
+			r.Text,
+		}
+		if err := c.Write(row); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
@@ -0,0 +1,363 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package texts
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+)
+
+// minTextLength leaves room for a part marker and some text in each part
+const minTextLength = 32
+
+// Queued tells the sender where its message went in the outbound queue.
+// Result receives nil once every part has been handed to Zello, or the
+// error that made the rest of the message be dropped.
+type Queued struct {
+	Position int           // of the first part, 1 is next to be sent
+	Parts    int           // the message was split into
+	Wait     time.Duration // estimated until the last part is sent
+	Result   <-chan error
+}
+
+// Part of a queued message
+type Part struct {
+	protocolapp.InternalTextMessageRequest
+	Attempts int // failed attempts to send it
+	message  *queuedMessage
+}
+
+// queuedMessage tracks the parts of a message still to be sent
+type queuedMessage struct {
+	unsent int
+	result chan error
+}
+
+// finish reports the result of the message, once
+func (m *queuedMessage) finish(err error) {
+	if m.result != nil {
+		m.result <- err
+		m.result = nil
+	}
+}
+
+// Outbox holds texts waiting to be sent. Messages longer than
+// texts.outbound.max_length bytes are split into numbered parts, and
+// parts are released no faster than a global token bucket and one for
+// each recipient allow. Parts for the same recipient keep their order;
+// one recipient that is being rate limited does not hold up the others.
+type Outbox struct {
+	sync.Mutex
+	maxLength     int
+	global        *tokenBucket
+	perRecipient  time.Duration
+	recipientSize int
+	recipients    map[string]*tokenBucket
+	queue         []Part
+	now           func() time.Time
+}
+
+// NewOutbox create an empty Outbox that neither splits nor limits
+// until configured
+func NewOutbox() *Outbox {
+	return &Outbox{
+		global:     newTokenBucket(0, 0, time.Time{}),
+		recipients: make(map[string]*tokenBucket),
+		now:        time.Now,
+	}
+}
+
+// Configure the outbox from the 'texts.outbound' config section
+func (o *Outbox) Configure() error {
+	maxLength := viper.GetInt("texts.outbound.max_length")
+	interval := viper.GetDuration("texts.outbound.interval")
+	burst := viper.GetInt("texts.outbound.burst")
+	perRecipient := viper.GetDuration("texts.outbound.recipient_interval")
+	recipientBurst := viper.GetInt("texts.outbound.recipient_burst")
+	if maxLength < minTextLength {
+		return errors.Errorf("texts.outbound.max_length %d must be at least %d", maxLength, minTextLength)
+	}
+	if interval < 0 || perRecipient < 0 {
+		return errors.New("texts.outbound intervals must not be negative")
+	}
+	if (interval > 0 && burst < 1) || (perRecipient > 0 && recipientBurst < 1) {
+		return errors.New("texts.outbound bursts must be at least 1")
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	now := o.now()
+	o.maxLength = maxLength
+	o.global = newTokenBucket(interval, burst, now)
+	o.perRecipient = perRecipient
+	o.recipientSize = recipientBurst
+	o.recipients = make(map[string]*tokenBucket)
+	return nil
+}
+
+// Add a message to the back of the queue, split into parts if it is too
+// long
+func (o *Outbox) Add(r protocolapp.InternalTextMessageRequest) Queued {
+	o.Lock()
+	defer o.Unlock()
+	parts := splitText(r.Message, o.maxLength)
+	m := &queuedMessage{unsent: len(parts), result: make(chan error, 1)}
+	q := Queued{Position: len(o.queue) + 1, Parts: len(parts), Result: m.result}
+	for _, part := range parts {
+		o.queue = append(o.queue, Part{
+			InternalTextMessageRequest: protocolapp.InternalTextMessageRequest{For: r.For, Message: part},
+			message:                    m,
+		})
+	}
+	q.Wait = o.estimate(len(o.queue) - 1)
+	return q
+}
+
+// Next removes and returns the next part that may be sent now. When
+// none may, it returns how long to wait before asking again, or 0 when
+// the queue is empty. The part must be passed back to Sent, Retry or
+// Drop.
+func (o *Outbox) Next() (*Part, time.Duration) {
+	o.Lock()
+	defer o.Unlock()
+	now := o.now()
+	i, wait := o.pick(now, o.queue, o.global, o.recipients)
+	if i < 0 {
+		return nil, wait
+	}
+	r := o.queue[i]
+	o.queue = append(o.queue[:i], o.queue[i+1:]...)
+	o.global.take(now)
+	o.recipient(o.recipients, r.For, now).take(now)
+	return &r, 0
+}
+
+// Sent records that a part has been handed to Zello
+func (o *Outbox) Sent(p *Part) {
+	o.Lock()
+	defer o.Unlock()
+	if p.message.unsent--; p.message.unsent <= 0 {
+		p.message.finish(nil)
+	}
+}
+
+// Retry puts a part that could not be sent back at the head of the queue,
+// ahead of the rest of its message
+func (o *Outbox) Retry(p *Part) {
+	o.Lock()
+	defer o.Unlock()
+	o.queue = append([]Part{*p}, o.queue...)
+}
+
+// Drop gives up on a part that could not be sent, with the rest of its
+// message, reporting err as the result. It returns the number of parts
+// dropped.
+func (o *Outbox) Drop(p *Part, err error) int {
+	o.Lock()
+	defer o.Unlock()
+	dropped := 1
+	keep := o.queue[:0]
+	for _, q := range o.queue {
+		if q.message == p.message {
+			dropped++
+			continue
+		}
+		keep = append(keep, q)
+	}
+	o.queue = keep
+	p.message.finish(err)
+	return dropped
+}
+
+// Len returns the number of parts waiting
+func (o *Outbox) Len() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.queue)
+}
+
+// Clear discards everything waiting, reporting err as the result of each
+// message, and returns how many parts there were
+func (o *Outbox) Clear(err error) int {
+	o.Lock()
+	defer o.Unlock()
+	n := len(o.queue)
+	for _, p := range o.queue {
+		p.message.finish(err)
+	}
+	o.queue = nil
+	return n
+}
+
+// pick returns the index of the first part in queue that may be sent at
+// now, or -1 and the shortest wait until one may
+func (o *Outbox) pick(now time.Time, queue []Part, global *tokenBucket, recipients map[string]*tokenBucket) (int, time.Duration) {
+	if len(queue) == 0 {
+		return -1, 0
+	}
+	if wait := global.wait(now); wait > 0 {
+		return -1, wait
+	}
+	var shortest time.Duration
+	blocked := make(map[string]bool)
+	for i, r := range queue {
+		key := strings.ToLower(r.For)
+		if blocked[key] {
+			continue
+		}
+		wait := o.recipient(recipients, r.For, now).wait(now)
+		if wait == 0 {
+			return i, 0
+		}
+		if shortest == 0 || wait < shortest {
+			shortest = wait
+		}
+		blocked[key] = true
+	}
+	return -1, shortest
+}
+
+// estimate how long until the part at index last is sent, by running
+// copies of the buckets over the queue
+func (o *Outbox) estimate(last int) time.Duration {
+	start := o.now()
+	now := start
+	global := *o.global
+	recipients := make(map[string]*tokenBucket, len(o.recipients))
+	for k, b := range o.recipients {
+		c := *b
+		recipients[k] = &c
+	}
+	queue := append([]Part(nil), o.queue[:last+1]...)
+	for {
+		i, wait := o.pick(now, queue, &global, recipients)
+		if i < 0 {
+			now = now.Add(wait)
+			continue
+		}
+		if i == last {
+			return now.Sub(start)
+		}
+		global.take(now)
+		o.recipient(recipients, queue[i].For, now).take(now)
+		queue = append(queue[:i], queue[i+1:]...)
+		last--
+	}
+}
+
+// recipient returns the bucket of a recipient, the channel being ""
+func (o *Outbox) recipient(recipients map[string]*tokenBucket, forUser string, now time.Time) *tokenBucket {
+	key := strings.ToLower(forUser)
+	b, ok := recipients[key]
+	if !ok {
+		b = newTokenBucket(o.perRecipient, o.recipientSize, now)
+		recipients[key] = b
+	}
+	return b
+}
+
+// tokenBucket allows a burst of size messages then one each interval.
+// An interval of 0 allows everything.
+type tokenBucket struct {
+	interval time.Duration
+	size     float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(interval time.Duration, size int, now time.Time) *tokenBucket {
+	return &tokenBucket{interval: interval, size: float64(size), tokens: float64(size), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+		if b.tokens > b.size {
+			b.tokens = b.size
+		}
+		b.last = now
+	}
+}
+
+// wait returns how long until a token is available
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if b.interval <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	wait := time.Duration((1 - b.tokens) * float64(b.interval))
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return wait
+}
+
+func (b *tokenBucket) take(now time.Time) {
+	if b.interval <= 0 {
+		return
+	}
+	b.refill(now)
+	b.tokens--
+}
+
+// splitText splits text into parts of no more than max bytes, each
+// starting with a marker such as '(2/3) '. Parts end at white space where
+// possible, otherwise at a UTF-8 character boundary. A max of 0, or text
+// that fits, returns the text unchanged.
+func splitText(text string, max int) []string {
+	if max <= 0 || len(text) <= max {
+		return []string{text}
+	}
+	// The marker length depends on the number of parts, so split again
+	// if the count gains a digit
+	for guess := 9; ; guess = guess*10 + 9 {
+		budget := max - len(partMarker(guess, guess))
+		chunks := chunkText(text, budget)
+		if len(chunks) <= guess {
+			parts := make([]string, len(chunks))
+			for i, c := range chunks {
+				parts[i] = partMarker(i+1, len(chunks)) + c
+			}
+			return parts
+		}
+	}
+}
+
+func partMarker(part int, parts int) string {
+	return fmt.Sprintf("(%d/%d) ", part, parts)
+}
+
+// chunkText cuts text into pieces of no more than budget bytes
+func chunkText(text string, budget int) []string {
+	var chunks []string
+	for {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if len(text) <= budget {
+			if text != "" {
+				chunks = append(chunks, text)
+			}
+			return chunks
+		}
+		cut := strings.LastIndexFunc(text[:budget+1], unicode.IsSpace)
+		if cut <= 0 {
+			cut = budget
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+		chunks = append(chunks, strings.TrimRightFunc(text[:cut], unicode.IsSpace))
+		text = text[cut:]
+	}
+}
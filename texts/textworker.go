@@ -6,18 +6,34 @@ package texts
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
+	"github.com/jcmurray/monitor/authenticate"
+	"github.com/jcmurray/monitor/capabilities"
 	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/heard"
 	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
 	"github.com/jcmurray/monitor/protocolapp"
 	"github.com/jcmurray/monitor/sequence"
 	"github.com/juju/errors"
 
 	"github.com/jcmurray/monitor/worker"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
-const ()
+const (
+	maxTextAttempts      = 3
+	historyPruneInterval = time.Hour
+	offlineRetryInterval = 5 * time.Second
+)
+
+// pendingText is a text message waiting for its response
+type pendingText struct {
+	request  protocolapp.InternalTextMessageRequest
+	attempts int
+}
 
 // TextMessageWorker stream worker
 type TextMessageWorker struct {
@@ -28,17 +44,36 @@ type TextMessageWorker struct {
 	label              string
 	workers            *worker.Workers
 	textMessageChannel chan []byte
+	policy             *network.ReconnectPolicy
+	pending            map[int]pendingText
+	deferred           []pendingText
+	resend             <-chan time.Time
+	outbox             *Outbox
+	release            <-chan time.Time
+	wake               chan struct{}
+	finalise           chan worker.FinaliseRequest
+	history            *TextHistory
+	commands           *CommandRouter
+	started            time.Time
 }
 
 // NewTextMessageWorker create a new TextMessageWorker
 func NewTextMessageWorker(workers *worker.Workers, id int, label string) *TextMessageWorker {
-	return &TextMessageWorker{
-		command: make(chan int),
-		id:      id,
-		label:   label,
-		log:     log.WithFields(log.Fields{"Label": label, "ID": id}),
-		workers: workers,
+	w := &TextMessageWorker{
+		command:  make(chan int, 10),
+		id:       id,
+		label:    label,
+		log:      log.WithFields(log.Fields{"Label": label, "ID": id}),
+		workers:  workers,
+		pending:  make(map[int]pendingText),
+		finalise: make(chan worker.FinaliseRequest),
+		commands: NewCommandRouter(),
+		outbox:   NewOutbox(),
+		wake:     make(chan struct{}, 1),
+		started:  time.Now(),
 	}
+	w.registerBuiltins()
+	return w
 }
 
 // Run is main function of this worker
@@ -46,6 +81,36 @@ func (w *TextMessageWorker) Run(wg *sync.WaitGroup, term *chan int) {
 	defer wg.Done()
 	w.log.Debugf("Worker Started")
 
+	policy, err := network.NewReconnectPolicy()
+	if err != nil {
+		w.log.Errorf("Reconnect configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+	w.policy = policy
+
+	if err := w.outbox.Configure(); err != nil {
+		w.log.Errorf("Outbound text configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+
+	if err := w.commands.Configure(); err != nil {
+		w.log.Errorf("Text command configuration error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+
+	history, err := OpenTextHistory(viper.GetString("texts.history.file"), viper.GetDuration("texts.history.retention"))
+	if err != nil {
+		w.log.Errorf("Text history error: %s - requesting application termination", err)
+		*term <- 1
+		return
+	}
+	w.Lock()
+	w.history = history
+	w.Unlock()
+
 	nw := w.findNetWorker()
 	textMessageChannel := nw.Subscribe(w.id, protocolapp.OnTextMessageEvent, w.label).Channel
 	responseChannel := nw.Subscribe(w.id, protocolapp.OnResponseEvent, w.label).Channel
@@ -53,12 +118,20 @@ func (w *TextMessageWorker) Run(wg *sync.WaitGroup, term *chan int) {
 
 	w.textMessageChannel = make(chan []byte, 2)
 
+	prune := time.NewTicker(historyPruneInterval)
+	defer prune.Stop()
+
 waitloop:
 	for {
 		w.log.Debugf("Entering Select")
 		select {
 		case errorMessage := <-errorChannel:
-			w.log.Debugf("Response: %s", errorcodes.Description(string(errorMessage.([]byte))))
+			e, err := errorcodes.FromMessage(errorMessage.([]byte))
+			if err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			w.log.Debugf("Error: %s (%s)", e.Description, e.Category)
 
 		case textMessage := <-textMessageChannel:
 
@@ -69,6 +142,15 @@ waitloop:
 				continue
 			}
 
+			heard.Heard(c.From, c.Channel, heard.KindText)
+			w.record(TextRecord{Direction: DirectionReceived, Channel: c.Channel, From: c.From, For: c.For, MessageID: c.MessageID, Text: c.Text})
+			w.runCommand(c)
+
+			if private.IsPrivate(c.For) {
+				private.Log(w.log, "Private message id %d - from '%s' on '%s' for '%s': %s", c.MessageID, c.From, c.Channel, c.For, c.Text)
+				private.Notify(nw, private.Received(private.KindText, c.Channel, c.From, c.For, c.MessageID, c.Text))
+				continue
+			}
 			w.log.Infof("Message id %d Started - from '%s' on '%s' for '%s': %s", c.MessageID, c.From, c.Channel, c.For, c.Text)
 
 		case sendTextMessage, more := <-w.textMessageChannel:
@@ -83,17 +165,26 @@ waitloop:
 				}
 				w.log.Debugf("For %s, Message: %s", message.For, message.Message)
 
-				err = w.doSendTextMessage(message.For, message.Message)
-				if err != nil {
-					w.log.Errorf("Error on sending text message to network %s", err)
-					continue
-				}
-				w.log.Infof("Text message for '%s' sent: '%s'", message.For, message.Message)
+				w.queued(message, w.outbox.Add(message))
+				w.drain()
 			} else {
 				w.log.Info("Channel closed")
 				break waitloop
 			}
 
+		case r := <-w.finalise:
+			if n := len(w.pending) + len(w.deferred); n > 0 {
+				w.log.Warnf("Abandoning %d text messages awaiting a response: %s", n, r.Reason)
+			}
+			if n := w.outbox.Clear(errors.Errorf("abandoned: %s", r.Reason)); n > 0 {
+				w.log.Warnf("Abandoning %d queued text messages: %s", n, r.Reason)
+			}
+			w.pending = make(map[int]pendingText)
+			w.deferred = nil
+			w.resend = nil
+			w.release = nil
+			close(r.Done)
+
 		case textMessageCommand, more := <-w.command:
 			if more {
 				w.log.Debugf("Received command %d", textMessageCommand)
@@ -116,18 +207,45 @@ waitloop:
 				w.log.Errorf("Unmarshal error: %s", err)
 				continue
 			}
-			if resp.Success && sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.TextMessageSendRequest) {
-				sequence.RemoveEntry(w.id, resp.Seq)
-				w.log.Tracef("Response: %s", string(response.([]byte)))
-				w.log.Infof("Successful response to Text Message")
+			if !sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.TextMessageSendRequest) {
 				continue
 			}
-			if !resp.Success && sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.TextMessageSendRequest) {
-				sequence.RemoveEntry(w.id, resp.Seq)
-				w.log.Tracef("Response: %s", string(response.([]byte)))
-				w.log.Infof("Error response to Text Message: %s", resp.Error)
+			sequence.RemoveEntry(w.id, resp.Seq)
+			w.log.Tracef("Response: %s", string(response.([]byte)))
+			p := w.pending[resp.Seq]
+			delete(w.pending, resp.Seq)
+
+			if err := errorcodes.FromResponse(resp, protocolapp.TextMessageSendRequest); err != nil {
+				w.sendFailed(p, err, term)
 				continue
 			}
+			w.log.Infof("Successful response to Text Message")
+			w.record(TextRecord{Direction: DirectionSent, Channel: capabilities.Get().Channel, From: viper.GetString("logon.username"), For: p.request.For, Text: p.request.Message})
+			if private.IsPrivate(p.request.For) {
+				private.Notify(nw, private.Sent(private.KindText, capabilities.Get().Channel, p.request.For, 0, p.request.Message))
+			}
+
+		case <-prune.C:
+			if err := history.Prune(time.Now()); err != nil {
+				w.log.Errorf("Text history error: %s", err)
+			}
+
+		case <-w.wake:
+			w.drain()
+
+		case <-w.release:
+			w.release = nil
+			w.drain()
+
+		case <-w.resend:
+			w.resend = nil
+			deferred := w.deferred
+			w.deferred = nil
+			for _, p := range deferred {
+				if err := w.send(p); err != nil {
+					w.log.Errorf("Error on resending text message to network %s", err)
+				}
+			}
 		}
 	}
 
@@ -135,6 +253,10 @@ waitloop:
 	nw.UnSubscribe(w.id, protocolapp.OnResponseEvent)
 	nw.UnSubscribe(w.id, protocolapp.OnTextMessageEvent)
 
+	if err := history.Close(); err != nil {
+		w.log.Errorf("Text history error: %s", err)
+	}
+
 	w.log.Debug("Finished")
 }
 
@@ -154,6 +276,123 @@ func (w *TextMessageWorker) TextMessageEvent(message []byte) {
 	w.textMessageChannel <- message
 }
 
+// Enqueue queues a text message to send, split into parts if it is too
+// long, and returns where it went in the queue
+func (w *TextMessageWorker) Enqueue(r protocolapp.InternalTextMessageRequest) Queued {
+	q := w.outbox.Add(r)
+	w.queued(r, q)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return q
+}
+
+// queued logs a message that has to wait or was split
+func (w *TextMessageWorker) queued(r protocolapp.InternalTextMessageRequest, q Queued) {
+	if q.Parts > 1 {
+		w.log.Infof("Text message for '%s' split into %d parts", r.For, q.Parts)
+	}
+	if q.Wait > 0 {
+		w.log.Infof("Text message for '%s' queued at position %d, sending in about %s", r.For, q.Position, q.Wait.Round(time.Second))
+	}
+}
+
+// drain sends the queued messages the rate limits allow now, and sets a
+// timer for the rest. Messages are held while the channel is offline.
+func (w *TextMessageWorker) drain() {
+	w.release = nil
+	for {
+		if err := capabilities.CheckText(); err != nil {
+			if e, ok := err.(*capabilities.Error); ok && (e.Kind == capabilities.KindUnknown || e.Kind == capabilities.KindOffline) {
+				if w.outbox.Len() > 0 {
+					w.log.Debugf("Holding %d queued text messages: %s", w.outbox.Len(), err)
+					w.release = time.After(offlineRetryInterval)
+				}
+				return
+			}
+		}
+		p, wait := w.outbox.Next()
+		if p == nil {
+			if wait > 0 {
+				w.release = time.After(wait)
+			}
+			return
+		}
+		if err := w.send(pendingText{request: p.InternalTextMessageRequest}); err != nil {
+			if w.retryPart(p, err) {
+				return
+			}
+			continue
+		}
+		w.outbox.Sent(p)
+		w.log.Infof("Text message for '%s' sent: '%s'", p.For, p.Message)
+	}
+}
+
+// retryPart puts a part that could not be sent back at the head of the
+// queue and sets a timer to try again. A part that has failed
+// maxTextAttempts times, other than for the channel being offline, is
+// dropped with the rest of its message. It returns true if the part was
+// put back.
+func (w *TextMessageWorker) retryPart(p *Part, err error) bool {
+	delay := offlineRetryInterval
+	if e, ok := err.(*capabilities.Error); !ok || (e.Kind != capabilities.KindUnknown && e.Kind != capabilities.KindOffline) {
+		p.Attempts++
+		if p.Attempts >= maxTextAttempts {
+			n := w.outbox.Drop(p, err)
+			w.log.Errorf("Text message for '%s' not sent after %d attempts, dropping %d parts: %s", p.For, p.Attempts, n, err)
+			return false
+		}
+		delay = w.policy.Interval(p.Attempts - 1)
+	}
+	w.outbox.Retry(p)
+	w.log.Warnf("Text message for '%s' not sent (%s), trying again in %s", p.For, err, delay)
+	w.release = time.After(delay)
+	return true
+}
+
+// Finalise abandons text messages awaiting a response before the channel changes
+func (w *TextMessageWorker) Finalise(reason string) bool {
+	return worker.Finalise(w.finalise, reason, worker.FinaliseTimeout)
+}
+
+// runCommand answers a text message holding a chat command
+func (w *TextMessageWorker) runCommand(c *protocolapp.OnTextMessage) {
+	reply, err := w.commands.Dispatch(c)
+	if err != nil {
+		w.log.Warnf("Text command refused: %s", err)
+		return
+	}
+	if reply == nil {
+		return
+	}
+	w.log.Infof("Text command reply for '%s': '%s'", reply.For, reply.Message)
+	w.queued(*reply, w.outbox.Add(*reply))
+	w.drain()
+}
+
+// RegisterCommand adds a chat command, answered when texts.commands.enabled is set
+func (w *TextMessageWorker) RegisterCommand(c Command) {
+	w.commands.Register(c)
+}
+
+// record a text message in the history
+func (w *TextMessageWorker) record(r TextRecord) {
+	r.Time = time.Now()
+	if _, err := w.history.Add(r); err != nil {
+		w.log.Errorf("Text history error: %s", err)
+	}
+}
+
+// TextHistory returns the text message history, nil until the worker
+// has started
+func (w *TextMessageWorker) TextHistory() *TextHistory {
+	w.Lock()
+	defer w.Unlock()
+	return w.history
+}
+
 // FindNetWorker find Net worker
 func (w *TextMessageWorker) findNetWorker() *network.Networker {
 	for i := range *w.workers {
@@ -175,7 +414,60 @@ func (w *TextMessageWorker) ID() int {
 	return w.id
 }
 
-func (w *TextMessageWorker) doSendTextMessage(forUser string, text string) error {
+// send a text message and remember it until its response arrives
+func (w *TextMessageWorker) send(p pendingText) error {
+	if err := capabilities.CheckText(); err != nil {
+		return err
+	}
+	seq, err := w.doSendTextMessage(p.request.For, p.request.Message)
+	if err != nil {
+		return err
+	}
+	p.attempts++
+	w.pending[seq] = p
+	return nil
+}
+
+// sendFailed reacts to a failed text message according to the category
+// of the error
+func (w *TextMessageWorker) sendFailed(p pendingText, err error, term *chan int) {
+	e, _ := errorcodes.As(err)
+	switch e.Action {
+	case errorcodes.ActionBackoff, errorcodes.ActionRelogon:
+		if p.attempts == 0 || p.attempts >= maxTextAttempts {
+			w.log.Errorf("Error response to Text Message: %s (%s) - giving up", e.Code, e.Description)
+			return
+		}
+		if e.Action == errorcodes.ActionRelogon {
+			w.relogon()
+		}
+		delay := w.policy.Interval(p.attempts - 1)
+		w.log.Warnf("Error response to Text Message: %s (%s) - sending again in %s", e.Code, e.Category, delay)
+		w.deferred = append(w.deferred, p)
+		if w.resend == nil {
+			w.resend = time.After(delay)
+		}
+	case errorcodes.ActionTerminate:
+		w.log.Errorf("Error response to Text Message: %s (%s) - requesting application termination", e.Code, e.Description)
+		*term <- 1
+	case errorcodes.ActionIgnore:
+		w.log.Debugf("Error response to Text Message: %s", e.Code)
+	default:
+		w.log.Errorf("Error response to Text Message: %s (%s, %s)", e.Code, e.Category, e.Description)
+	}
+}
+
+// relogon asks the Auth worker to log on again
+func (w *TextMessageWorker) relogon() {
+	for i := range *w.workers {
+		if aw, ok := (*w.workers)[i].(*authenticate.AuthWorker); ok {
+			aw.Command(worker.Relogon)
+			return
+		}
+	}
+}
+
+func (w *TextMessageWorker) doSendTextMessage(forUser string, text string) (int, error) {
 
 	textMessage := protocolapp.NewSendTextMessage()
 	textMessage.Seq = sequence.GetNextSequenceNumber(w.id, protocolapp.TextMessageSendRequest)
@@ -185,14 +477,14 @@ func (w *TextMessageWorker) doSendTextMessage(forUser string, text string) error
 	buff, err := json.Marshal(textMessage)
 	if err != nil {
 		w.log.Errorf("Marshal error: %s", err)
-		return errors.Annotate(err, "Marshal failure for Zello send text message request")
+		return 0, errors.Annotate(err, "Marshal failure for Zello send text message request")
 	}
 
 	w.log.Tracef("Sending: %s", buff)
 
 	nw := w.findNetWorker()
 	nw.Data([]byte(string(buff)))
-	return nil
+	return textMessage.Seq, nil
 }
 
 // Subscriptions return a copy of current scubscriptions
@@ -0,0 +1,111 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package texts_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/channelstatus"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/private"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/texts"
+	"github.com/jcmurray/monitor/zellotest"
+	"github.com/spf13/viper"
+)
+
+const testChannel = "Test Channel"
+
+// nextPrivate waits for a private message published in the given direction
+func nextPrivate(t *testing.T, c chan interface{}, outgoing bool) private.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-c:
+			var p private.Message
+			if err := json.Unmarshal(m.([]byte), &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Outgoing == outgoing {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("no private message with outgoing %t published", outgoing)
+			return private.Message{}
+		}
+	}
+}
+
+func TestTextsExchanged(t *testing.T) {
+	s := zellotest.NewServer()
+	defer s.Close()
+	s.Configure(testChannel, "monitor", "secret", "token")
+	dir := t.TempDir()
+	viper.Set("logon.state_file", filepath.Join(dir, "state.json"))
+	viper.Set("texts.history.file", filepath.Join(dir, "text-history.jsonl"))
+	viper.Set("texts.history.retention", "1h")
+	viper.Set("texts.commands.enabled", true)
+	viper.Set("texts.commands.prefix", "!")
+	viper.Set("texts.commands.reply", texts.ReplySame)
+	viper.Set("texts.outbound.max_length", 1000)
+	t.Cleanup(func() { viper.Set("texts.commands.enabled", false) })
+
+	c := s.NewClient()
+	tw := texts.NewTextMessageWorker(&c.Workers, zellotest.FirstWorkerID, "Text Worker")
+	tw.RegisterCommand(texts.Command{Name: "ping", Summary: "test", Handler: func(r texts.CommandRequest) (string, error) {
+		return "pong " + r.From, nil
+	}})
+	// Texts are held until the status worker reports the channel online
+	c.Add(channelstatus.NewStatusWorker(&c.Workers, zellotest.FirstWorkerID+1, "Status Worker"))
+	c.Add(tw)
+	messages := c.Network.Subscribe(zellotest.FirstWorkerID+2, private.SubscriptionTypePrivateMessage, "Test").Channel
+	c.Start(t)
+	s.ExpectLogon(t, testChannel)
+	c.WaitForState(t, network.StateOnline)
+
+	// A private command is answered privately
+	id, err := s.SendText(testChannel, "alice", "monitor", "!ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := nextPrivate(t, messages, false); p.ID != id || p.Text != "!ping" {
+		t.Errorf("received %+v", p)
+	}
+	s.ExpectText(t, "alice", "pong alice")
+	if p := nextPrivate(t, messages, true); p.Peer != "alice" || p.Text != "pong alice" {
+		t.Errorf("sent %+v", p)
+	}
+
+	q := tw.Enqueue(protocolapp.InternalTextMessageRequest{For: "bob", Message: "hello bob"})
+	s.ExpectText(t, "bob", "hello bob")
+	select {
+	case err := <-q.Result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result for the queued text")
+	}
+	nextPrivate(t, messages, true)
+
+	// Both directions are kept in the history
+	records := tw.TextHistory().Query(texts.TextQuery{})
+	if len(records) != 3 {
+		t.Fatalf("history %+v", records)
+	}
+	for i, want := range []texts.TextRecord{
+		{Direction: texts.DirectionReceived, From: "alice", For: "monitor", MessageID: id, Text: "!ping"},
+		{Direction: texts.DirectionSent, From: "monitor", For: "alice", Text: "pong alice"},
+		{Direction: texts.DirectionSent, From: "monitor", For: "bob", Text: "hello bob"},
+	} {
+		r := records[i]
+		if r.Direction != want.Direction || r.From != want.From || r.For != want.For || r.MessageID != want.MessageID || r.Text != want.Text {
+			t.Errorf("record %d is %+v, expected %+v", i, r, want)
+		}
+	}
+}
@@ -23,6 +23,123 @@ const (
 	DefaultRPCServerPort    = 9998
 )
 
+// Reconnection policy defaults
+const (
+	DefaultReconnectInitialInterval = "2s"
+	DefaultReconnectMaxInterval     = "5m"
+	DefaultReconnectMultiplier      = 2.0
+	DefaultReconnectJitter          = 0.2
+	DefaultReconnectMaxAttempts     = 0
+	DefaultReconnectResetAfter      = "1m"
+	DefaultCloseCodeAction          = "retry"
+	DefaultHeartbeatInterval        = "30s"
+	DefaultHeartbeatTimeout         = "90s"
+)
+
+// Self-issued auth token defaults. No refresh token is saved unless a
+// state file is set.
+const (
+	DefaultTokenLifetime      = "1h"
+	DefaultTokenRefreshBefore = "10m"
+	DefaultStateFile          = ""
+)
+
+// Session capture and replay defaults
+const (
+	DefaultCaptureFormat = "jsonl"
+	DefaultReplaySpeed   = 1.0
+)
+
+// Channel policy defaults, one of terminate, relogon or alert
+const (
+	DefaultChannelClosedAction  = "relogon"
+	DefaultChannelBlockedAction = "terminate"
+	DefaultChannelErrorAction   = "alert"
+)
+
+// DefaultChannelHistorySize is the number of channel status changes kept in memory
+const DefaultChannelHistorySize = 1000
+
+// DefaultSecretsKeyFile holds the key for enc: config values
+const DefaultSecretsKeyFile = "monitor.key"
+
+// Text history defaults. Messages are kept in memory only unless a file
+// is set; a retention of 0 keeps them for ever.
+const (
+	DefaultTextHistoryFile      = ""
+	DefaultTextHistoryRetention = "720h"
+)
+
+// Chat command defaults. Commands are answered only when enabled.
+const (
+	DefaultTextCommandsEnabled    = false
+	DefaultTextCommandsPrefix     = "!"
+	DefaultTextCommandsReply      = "same"
+	DefaultTextCommandsRateLimit  = 5
+	DefaultTextCommandsRateWindow = "1m"
+)
+
+// Outbound text defaults. Longer texts are split into parts; an interval
+// of 0 removes that limit.
+const (
+	DefaultTextOutboundMaxLength         = 1000
+	DefaultTextOutboundInterval          = "1s"
+	DefaultTextOutboundBurst             = 5
+	DefaultTextOutboundRecipientInterval = "3s"
+	DefaultTextOutboundRecipientBurst    = 3
+)
+
+// DefaultLocationBeaconInterval sends no fixed position unless set
+const DefaultLocationBeaconInterval = "0s"
+
+// Location track defaults. Fixes are kept in memory only unless a file is
+// set; a retention of 0 keeps them for ever.
+const (
+	DefaultLocationTracksFile      = ""
+	DefaultLocationTracksRetention = "720h"
+)
+
+// Location geocoder defaults. The geocoder is chosen by name; empty falls
+// back to the location.what3words setting.
+const (
+	DefaultLocationOffline           = false
+	DefaultLocationGeocoder          = ""
+	DefaultLocationGeocoderCacheSize = 1000
+	DefaultLocationGeocoderCacheTTL  = "24h"
+)
+
+// DefaultLocationGeofenceHysteresis in metres is added to the accuracy of
+// a fix before it moves a user in or out of a geofence
+const DefaultLocationGeofenceHysteresis = 10
+
+// Alert defaults. There is no journal unless one is set.
+const (
+	DefaultAlertJournal        = ""
+	DefaultAlertDedupWindow    = "5m"
+	DefaultAlertWebhookTimeout = "10s"
+)
+
+// Schedule defaults. Announcements are kept in memory only unless a file
+// is set.
+const (
+	DefaultScheduleFile = ""
+)
+
+// Voice transmission defaults
+const (
+	DefaultVoiceQuietPeriod = "5s"
+	DefaultVoiceMaxWait     = "10m"
+)
+
+// Private message defaults
+const (
+	DefaultPrivateLogLevel       = "warning"
+	DefaultPrivateImageDirectory = ""
+	DefaultPrivatePlayAudio      = true
+	DefaultPrivateConversations  = 50
+	DefaultPrivateAlertSeverity  = "warning"
+)
+
 // LogLevelStrings for config file
 var LogLevelStrings = make([]string, logLevelTraceEndMarker)
 
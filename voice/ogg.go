@@ -0,0 +1,186 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package voice
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	oggPageHeaderLength = 27
+	oggContinued        = 0x01
+	opusHeadLength      = 19
+)
+
+// zelloSampleRates Opus sample rates a Zello codec header may give
+var zelloSampleRates = map[int]bool{8000: true, 12000: true, 16000: true, 24000: true, 48000: true}
+
+// Recording is an Ogg Opus file ready to send as a Zello stream. Every
+// packet must hold the same number of frames of the same duration.
+type Recording struct {
+	SampleRate      int
+	FramesPerPacket int
+	FrameSizeMs     int
+	Packets         [][]byte
+}
+
+// ReadOpusFile reads the Opus packets of an Ogg Opus file
+func ReadOpusFile(name string) (*Recording, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Annotate(err, "open audio")
+	}
+	defer f.Close()
+	packets, err := readOggPackets(f)
+	if err != nil {
+		return nil, errors.Annotatef(err, "read %s", name)
+	}
+	r, err := newRecording(packets)
+	if err != nil {
+		return nil, errors.Annotatef(err, "%s", name)
+	}
+	return r, nil
+}
+
+// readOggPackets returns the packets of the first logical stream
+func readOggPackets(r io.Reader) ([][]byte, error) {
+	var (
+		packets [][]byte
+		partial []byte
+		serial  uint32
+		first   = true
+		header  = make([]byte, oggPageHeaderLength)
+	)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Annotate(err, "Ogg page header")
+		}
+		if !bytes.Equal(header[0:4], []byte("OggS")) {
+			return nil, errors.New("not an Ogg file")
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return nil, errors.Annotate(err, "Ogg segment table")
+		}
+		size := 0
+		for _, s := range segments {
+			size += int(s)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, errors.Annotate(err, "Ogg page")
+		}
+		pageSerial := binary.LittleEndian.Uint32(header[14:18])
+		if first {
+			serial = pageSerial
+			first = false
+		}
+		if pageSerial != serial {
+			continue
+		}
+		if header[5]&oggContinued == 0 {
+			partial = nil
+		}
+		offset := 0
+		for _, s := range segments {
+			partial = append(partial, body[offset:offset+int(s)]...)
+			offset += int(s)
+			if s < 255 {
+				packets = append(packets, partial)
+				partial = nil
+			}
+		}
+	}
+	return packets, nil
+}
+
+func newRecording(packets [][]byte) (*Recording, error) {
+	if len(packets) < 2 || len(packets[0]) < opusHeadLength || !bytes.HasPrefix(packets[0], []byte("OpusHead")) {
+		return nil, errors.New("not an Ogg Opus file")
+	}
+	r := &Recording{SampleRate: int(binary.LittleEndian.Uint32(packets[0][12:16]))}
+	if !zelloSampleRates[r.SampleRate] {
+		r.SampleRate = 48000
+	}
+	// packets[1] is OpusTags
+	for i, p := range packets[2:] {
+		if len(p) == 0 {
+			continue
+		}
+		frames, frameSize, err := opusPacketFrames(p)
+		if err != nil {
+			return nil, errors.Annotatef(err, "packet %d", i)
+		}
+		if r.FramesPerPacket == 0 {
+			r.FramesPerPacket = frames
+			r.FrameSizeMs = frameSize
+		} else if frames != r.FramesPerPacket || frameSize != r.FrameSizeMs {
+			return nil, errors.Errorf("packet %d has %d frames of %dms, earlier packets %d of %dms; re-encode with a fixed frame size",
+				i, frames, frameSize, r.FramesPerPacket, r.FrameSizeMs)
+		}
+		r.Packets = append(r.Packets, p)
+	}
+	if len(r.Packets) == 0 {
+		return nil, errors.New("no audio")
+	}
+	return r, nil
+}
+
+// opusPacketFrames returns the number of frames in an Opus packet and
+// their duration in whole milliseconds, from its TOC byte
+func opusPacketFrames(p []byte) (int, int, error) {
+	config := int(p[0] >> 3)
+	var frameSize int
+	switch {
+	case config < 12:
+		frameSize = []int{10, 20, 40, 60}[config%4]
+	case config < 16:
+		frameSize = []int{10, 20}[config%2]
+	default:
+		frameSize = []int{0, 5, 10, 20}[config%4]
+	}
+	if frameSize == 0 {
+		return 0, 0, errors.New("2.5ms frames cannot be sent to Zello")
+	}
+	switch p[0] & 0x03 {
+	case 0:
+		return 1, frameSize, nil
+	case 1, 2:
+		return 2, frameSize, nil
+	}
+	if len(p) < 2 {
+		return 0, 0, errors.New("truncated Opus packet")
+	}
+	return int(p[1] & 0x3f), frameSize, nil
+}
+
+// CodecHeader for the start_stream request: sample rate, frames per
+// packet and frame size
+func (r *Recording) CodecHeader() string {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint16(header[0:2], uint16(r.SampleRate))
+	header[2] = byte(r.FramesPerPacket)
+	header[3] = byte(r.FrameSizeMs)
+	return base64.StdEncoding.EncodeToString(header)
+}
+
+// PacketDuration of each packet
+func (r *Recording) PacketDuration() time.Duration {
+	return time.Duration(r.FramesPerPacket*r.FrameSizeMs) * time.Millisecond
+}
+
+// Duration of the whole recording
+func (r *Recording) Duration() time.Duration {
+	return time.Duration(len(r.Packets)) * r.PacketDuration()
+}
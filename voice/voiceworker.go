@@ -0,0 +1,340 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package voice transmits pre-recorded Ogg Opus audio files to the
+// channel as Zello streams, waiting for the channel to be quiet first.
+package voice
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jcmurray/monitor/capabilities"
+	"github.com/jcmurray/monitor/errorcodes"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/sequence"
+	"github.com/jcmurray/monitor/streams"
+	"github.com/jcmurray/monitor/worker"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	streamDataPrefix  = 0x01
+	quietPollInterval = 250 * time.Millisecond
+	responseTimeout   = 10 * time.Second
+)
+
+// ErrBusy the channel did not go quiet within voice.max_wait
+var ErrBusy = errors.New("channel did not go quiet")
+
+// VoiceWorker voice transmission worker
+type VoiceWorker struct {
+	sync.Mutex
+	command  chan int
+	log      *log.Entry
+	id       int
+	label    string
+	workers  *worker.Workers
+	sending  sync.Mutex
+	pending  map[int]chan *protocolapp.Response
+	cancel   context.CancelFunc
+	finalise chan worker.FinaliseRequest
+	done     chan struct{}
+}
+
+// NewVoiceWorker create a new VoiceWorker
+func NewVoiceWorker(workers *worker.Workers, id int, label string) *VoiceWorker {
+	return &VoiceWorker{
+		command:  make(chan int, 10),
+		id:       id,
+		label:    label,
+		log:      log.WithFields(log.Fields{"Label": label, "ID": id}),
+		workers:  workers,
+		pending:  make(map[int]chan *protocolapp.Response),
+		finalise: make(chan worker.FinaliseRequest),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run is main function of this worker
+func (w *VoiceWorker) Run(wg *sync.WaitGroup, term *chan int) {
+	defer wg.Done()
+	w.log.Debugf("Worker Started")
+
+	nw := w.findNetWorker()
+	responseChannel := nw.Subscribe(w.id, protocolapp.OnResponseEvent, w.label).Channel
+
+waitloop:
+	for {
+		w.log.Debugf("Entering Select")
+		select {
+		case response := <-responseChannel:
+			resp := protocolapp.NewResponse()
+			if err := json.Unmarshal(response.([]byte), resp); err != nil {
+				w.log.Errorf("Unmarshal error: %s", err)
+				continue
+			}
+			if !sequence.IsCommandSeqExpected(w.id, resp.Seq, protocolapp.StreamStartRequest) {
+				continue
+			}
+			sequence.RemoveEntry(w.id, resp.Seq)
+			w.Lock()
+			if c, ok := w.pending[resp.Seq]; ok {
+				c <- resp
+				delete(w.pending, resp.Seq)
+			}
+			w.Unlock()
+
+		case r := <-w.finalise:
+			w.Lock()
+			if w.cancel != nil {
+				w.log.Infof("Abandoning voice transmission: %s", r.Reason)
+				w.cancel()
+			}
+			w.Unlock()
+			close(r.Done)
+
+		case voiceCommand, more := <-w.command:
+			if more {
+				w.log.Debugf("Received command %d", voiceCommand)
+				switch voiceCommand {
+				case worker.Terminate:
+					w.log.Debugf("Terminating")
+					break waitloop
+				default:
+					continue
+				}
+			} else {
+				w.log.Info("Channel closed")
+				break waitloop
+			}
+		}
+	}
+
+	close(w.done)
+	w.Lock()
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.Unlock()
+	nw.UnSubscribe(w.id, protocolapp.OnResponseEvent)
+
+	w.log.Debug("Finished")
+}
+
+// Transmit sends an Ogg Opus file to the channel, or privately to
+// forUser, once no stream has been heard for voice.quiet_period. It
+// returns when the whole file has been sent. One file is sent at a
+// time; later calls wait their turn.
+func (w *VoiceWorker) Transmit(file string, forUser string) error {
+	recording, err := ReadOpusFile(file)
+	if err != nil {
+		return err
+	}
+
+	w.sending.Lock()
+	defer w.sending.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.Lock()
+	w.cancel = cancel
+	w.Unlock()
+	defer func() {
+		w.Lock()
+		w.cancel = nil
+		w.Unlock()
+	}()
+	select {
+	case <-w.done:
+		return errors.New("voice worker has stopped")
+	default:
+	}
+
+	if err := w.waitForQuiet(ctx); err != nil {
+		return err
+	}
+	if err := capabilities.CheckVoice(); err != nil {
+		return err
+	}
+	streamID, err := w.startStream(ctx, recording, forUser)
+	if err != nil {
+		return err
+	}
+	w.log.Infof("Stream id %d transmitting '%s' (%s) for '%s'", streamID, file, recording.Duration(), forUser)
+	err = w.sendPackets(ctx, streamID, recording)
+	w.stopStream(streamID)
+	if err != nil {
+		return errors.Annotatef(err, "stream id %d", streamID)
+	}
+	w.log.Infof("Stream id %d transmitted", streamID)
+	return nil
+}
+
+// waitForQuiet waits, up to voice.max_wait, for no stream to have been
+// heard for voice.quiet_period
+func (w *VoiceWorker) waitForQuiet(ctx context.Context) error {
+	sw := w.findStreamWorker()
+	if sw == nil {
+		return nil
+	}
+	period := viper.GetDuration("voice.quiet_period")
+	maxWait := viper.GetDuration("voice.max_wait")
+	deadline := time.Now().Add(maxWait)
+	ticker := time.NewTicker(quietPollInterval)
+	defer ticker.Stop()
+	waiting := false
+	for {
+		now := time.Now()
+		if sw.Activity().Quiet(now, period) {
+			return nil
+		}
+		if now.After(deadline) {
+			return errors.Annotatef(ErrBusy, "within %s", maxWait)
+		}
+		if !waiting {
+			w.log.Infof("Waiting for the channel to be quiet for %s", period)
+			waiting = true
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.New("voice transmission abandoned")
+		}
+	}
+}
+
+// startStream asks Zello for a stream id
+func (w *VoiceWorker) startStream(ctx context.Context, r *Recording, forUser string) (int, error) {
+	start := protocolapp.NewStartStream()
+	start.Seq = sequence.GetNextSequenceNumber(w.id, protocolapp.StreamStartRequest)
+	start.CodecHeader = r.CodecHeader()
+	start.PacketDuration = int(r.PacketDuration() / time.Millisecond)
+	start.For = forUser
+
+	buff, err := json.Marshal(start)
+	if err != nil {
+		sequence.RemoveEntry(w.id, start.Seq)
+		return 0, errors.Annotate(err, "Marshal failure for Zello start stream request")
+	}
+
+	response := make(chan *protocolapp.Response, 1)
+	w.Lock()
+	w.pending[start.Seq] = response
+	w.Unlock()
+	defer func() {
+		w.Lock()
+		delete(w.pending, start.Seq)
+		w.Unlock()
+	}()
+
+	w.log.Tracef("Sending: %s", buff)
+	w.findNetWorker().Data(buff)
+
+	timeout := time.NewTimer(responseTimeout)
+	defer timeout.Stop()
+	select {
+	case resp := <-response:
+		if err := errorcodes.FromResponse(resp, protocolapp.StreamStartRequest); err != nil {
+			return 0, err
+		}
+		return resp.StreamID, nil
+	case <-timeout.C:
+		sequence.RemoveEntry(w.id, start.Seq)
+		return 0, errors.Errorf("no response to start stream within %s", responseTimeout)
+	case <-ctx.Done():
+		sequence.RemoveEntry(w.id, start.Seq)
+		return 0, errors.New("voice transmission abandoned")
+	}
+}
+
+// sendPackets sends the recording at the pace it plays
+func (w *VoiceWorker) sendPackets(ctx context.Context, streamID int, r *Recording) error {
+	nw := w.findNetWorker()
+	ticker := time.NewTicker(r.PacketDuration())
+	defer ticker.Stop()
+	for i, p := range r.Packets {
+		packet := make([]byte, 9+len(p))
+		packet[0] = streamDataPrefix
+		binary.BigEndian.PutUint32(packet[1:5], uint32(streamID))
+		copy(packet[9:], p)
+		if err := nw.BinaryData(packet); err != nil {
+			return errors.Annotatef(err, "packet %d", i)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Errorf("abandoned after %d of %d packets", i+1, len(r.Packets))
+		}
+	}
+	return nil
+}
+
+func (w *VoiceWorker) stopStream(streamID int) {
+	stop := protocolapp.NewStopStream()
+	stop.StreamID = streamID
+	buff, err := json.Marshal(stop)
+	if err != nil {
+		w.log.Errorf("Marshal error: %s", err)
+		return
+	}
+	w.findNetWorker().Data(buff)
+}
+
+// Command sent to this worker
+func (w *VoiceWorker) Command(c int) {
+	w.command <- c
+}
+
+// Terminate the worker
+func (w *VoiceWorker) Terminate() {
+	w.Command(worker.Terminate)
+}
+
+// Finalise abandons a transmission in progress before the channel changes
+func (w *VoiceWorker) Finalise(reason string) bool {
+	return worker.Finalise(w.finalise, reason, worker.FinaliseTimeout)
+}
+
+// FindNetWorker find Net worker
+func (w *VoiceWorker) findNetWorker() *network.Networker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *network.Networker:
+			return (*w.workers)[i].(*network.Networker)
+		}
+	}
+	return nil
+}
+
+// findStreamWorker find Stream worker
+func (w *VoiceWorker) findStreamWorker() *streams.StreamWorker {
+	for i := range *w.workers {
+		switch (*w.workers)[i].(type) {
+		case *streams.StreamWorker:
+			return (*w.workers)[i].(*streams.StreamWorker)
+		}
+	}
+	return nil
+}
+
+// Label return label of worker
+func (w *VoiceWorker) Label() string {
+	return w.label
+}
+
+// ID return label of worker
+func (w *VoiceWorker) ID() int {
+	return w.id
+}
+
+// Subscriptions return a copy of current scubscriptions
+func (w *VoiceWorker) Subscriptions() []*worker.Subscription {
+	return make([]*worker.Subscription, 0)
+}
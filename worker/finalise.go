@@ -0,0 +1,45 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package worker
+
+import (
+	"time"
+)
+
+// FinaliseTimeout is how long a worker is given to finalise
+const FinaliseTimeout = 5 * time.Second
+
+// Finaliser is implemented by workers holding per-channel state, such as
+// active streams, that must be completed or discarded before the channel
+// changes
+type Finaliser interface {
+	Label() string
+	Finalise(reason string) bool
+}
+
+// FinaliseRequest asks a worker to finalise its channel state. Done is
+// closed once it has.
+type FinaliseRequest struct {
+	Reason string
+	Done   chan struct{}
+}
+
+// Finalise sends a FinaliseRequest on c and waits for it to be handled. It
+// returns false if the worker does not respond within timeout.
+func Finalise(c chan<- FinaliseRequest, reason string, timeout time.Duration) bool {
+	r := FinaliseRequest{Reason: reason, Done: make(chan struct{})}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case c <- r:
+	case <-deadline.C:
+		return false
+	}
+	select {
+	case <-r.Done:
+		return true
+	case <-deadline.C:
+		return false
+	}
+}
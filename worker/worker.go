@@ -14,6 +14,7 @@ const (
 	Terminate
 	Logon
 	Logoff
+	Relogon
 )
 
 // IF Interface
@@ -30,12 +31,15 @@ type IF interface {
 // Workers object
 type Workers []interface{}
 
-// Subscription to a message on a channel
+// Subscription to a message on a channel. Done is closed when the
+// subscriber unsubscribes, so that a message on its way is not left
+// waiting for a reader that has gone.
 type Subscription struct {
 	ID      int              `json:"id,omitempty"`
 	Type    string           `json:"type,omitempty"`
 	Label   string           `json:"label,omitempty"`
 	Channel chan interface{} `json:"-"`
+	Done    chan struct{}    `json:"-"`
 	Next    *Subscription    `json:"-"`
 }
 
@@ -46,6 +50,7 @@ func NewSubscription(sID int, sType string, label string) *Subscription {
 		Type:    sType,
 		Label:   label,
 		Channel: make(chan interface{}, 2),
+		Done:    make(chan struct{}),
 		Next:    nil,
 	}
 }
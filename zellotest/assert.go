@@ -0,0 +1,88 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package zellotest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/jcmurray/monitor/util"
+	"github.com/spf13/viper"
+)
+
+// Configure points the global configuration at this server and fills in
+// the defaults main would otherwise set, so workers can be run directly
+func (s *Server) Configure(channel string, username string, password string, authToken string) {
+	viper.Set("server.url", s.URL())
+	viper.Set("logon.channel", channel)
+	viper.Set("logon.username", username)
+	viper.Set("logon.password", password)
+	viper.Set("logon.auth_token", authToken)
+	viper.SetDefault("network.reconnect.initial_interval", "100ms")
+	viper.SetDefault("network.reconnect.max_interval", "1s")
+	viper.SetDefault("network.reconnect.multiplier", util.DefaultReconnectMultiplier)
+	viper.SetDefault("network.reconnect.reset_after", util.DefaultReconnectResetAfter)
+	viper.SetDefault("network.closecodes.default", util.DefaultCloseCodeAction)
+	viper.SetDefault("network.heartbeat.interval", util.DefaultHeartbeatInterval)
+	viper.SetDefault("network.heartbeat.timeout", util.DefaultHeartbeatTimeout)
+	viper.SetDefault("network.capture.format", util.DefaultCaptureFormat)
+	viper.SetDefault("channel.policy.closed", util.DefaultChannelClosedAction)
+	viper.SetDefault("channel.policy.blocked", util.DefaultChannelBlockedAction)
+	viper.SetDefault("channel.policy.error", util.DefaultChannelErrorAction)
+	viper.SetDefault("channel.history.size", util.DefaultChannelHistorySize)
+}
+
+// ExpectCommand fails the test unless the client sends command within the default timeout
+func (s *Server) ExpectCommand(t testing.TB, command string) Received {
+	t.Helper()
+	r, err := s.WaitForCommand(command, defaultWaitTimeout)
+	if err != nil {
+		t.Fatalf("zellotest: %s", err)
+	}
+	return r
+}
+
+// ExpectLogon fails the test unless the client logs on to channel
+func (s *Server) ExpectLogon(t testing.TB, channel string) *protocolapp.Logon {
+	t.Helper()
+	r := s.ExpectCommand(t, protocolapp.LogonRequest)
+	logon := &protocolapp.Logon{}
+	if err := r.Decode(logon); err != nil {
+		t.Fatalf("zellotest: invalid logon: %s", err)
+	}
+	if logon.Channel != channel {
+		t.Fatalf("zellotest: logon to channel '%s', expected '%s'", logon.Channel, channel)
+	}
+	return logon
+}
+
+// ExpectText fails the test unless the client sends text to forUser
+func (s *Server) ExpectText(t testing.TB, forUser string, text string) {
+	t.Helper()
+	r := s.ExpectCommand(t, protocolapp.TextMessageSendRequest)
+	message := &protocolapp.SendTextMessage{}
+	if err := r.Decode(message); err != nil {
+		t.Fatalf("zellotest: invalid send_text_message: %s", err)
+	}
+	if message.For != forUser || message.Text != text {
+		t.Fatalf("zellotest: text %s, expected %s", describeText(message.For, message.Text), describeText(forUser, text))
+	}
+}
+
+// ExpectNoCommand fails the test if the client sends command within wait
+func (s *Server) ExpectNoCommand(t testing.TB, command string, wait time.Duration) {
+	t.Helper()
+	if r, err := s.WaitForCommand(command, wait); err == nil {
+		t.Fatalf("zellotest: unexpected '%s': %s", command, string(r.Raw))
+	}
+}
+
+func describeText(forUser string, text string) string {
+	if forUser == "" {
+		return fmt.Sprintf("'%s' to the channel", text)
+	}
+	return fmt.Sprintf("'%s' for '%s'", text, forUser)
+}
@@ -0,0 +1,140 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package zellotest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmurray/monitor/authenticate"
+	"github.com/jcmurray/monitor/network"
+	"github.com/jcmurray/monitor/worker"
+)
+
+// Worker ids used by a Client. Workers under test use ids from
+// FirstWorkerID.
+const (
+	NetworkWorkerID = 1
+	AuthWorkerID    = 2
+	FirstWorkerID   = 3
+	clientID        = 99
+)
+
+// Runner is a worker a Client runs
+type Runner interface {
+	Run(wg *sync.WaitGroup, term *chan int)
+	Terminate()
+}
+
+// Client runs the network and auth workers, and any workers under test,
+// against a Server
+type Client struct {
+	sync.Mutex
+	Workers worker.Workers
+	Network *network.Networker
+	Auth    *authenticate.AuthWorker
+	runners []Runner
+	term    chan int
+	wg      sync.WaitGroup
+	changed *sync.Cond
+}
+
+// NewClient makes the network and auth workers. Workers under test are
+// made with &c.Workers and added with Add before Start.
+func (s *Server) NewClient() *Client {
+	c := &Client{term: make(chan int, 10)}
+	c.changed = sync.NewCond(&c.Mutex)
+	c.Network = network.NewNetworker(&c.Workers, NetworkWorkerID, "Network Worker")
+	c.Auth = authenticate.NewAuthWorker(&c.Workers, AuthWorkerID, "Auth Worker")
+	c.Workers = append(c.Workers, c.Network, c.Auth)
+	return c
+}
+
+// Add a worker to run
+func (c *Client) Add(w Runner) {
+	c.Workers = append(c.Workers, w)
+	c.runners = append(c.runners, w)
+}
+
+// Start runs every worker and connects. The workers are stopped when the
+// test ends.
+func (c *Client) Start(t testing.TB) {
+	t.Helper()
+	states := c.Network.Subscribe(clientID, network.SubscriptionTypeState, "zellotest")
+	go func() {
+		for {
+			select {
+			case <-states.Channel:
+				c.Lock()
+				c.changed.Broadcast()
+				c.Unlock()
+			case <-states.Done:
+				return
+			}
+		}
+	}()
+
+	c.wg.Add(2 + len(c.runners))
+	go c.Network.Run(&c.wg, &c.term)
+	go c.Auth.Run(&c.wg, &c.term)
+	for _, r := range c.runners {
+		go r.Run(&c.wg, &c.term)
+	}
+	t.Cleanup(func() {
+		for i := len(c.runners) - 1; i >= 0; i-- {
+			c.runners[i].Terminate()
+		}
+		c.Auth.Terminate()
+		c.Network.UnSubscribe(clientID, network.SubscriptionTypeState)
+		c.Network.Terminate()
+		c.wg.Wait()
+	})
+	c.Network.Command(worker.Connect)
+}
+
+// WaitForState waits for the connection to reach state, failing the test
+// if it does not within the default timeout
+func (c *Client) WaitForState(t testing.TB, state network.ConnectionState) {
+	t.Helper()
+	deadline := time.Now().Add(defaultWaitTimeout)
+	timer := time.AfterFunc(defaultWaitTimeout, func() {
+		c.Lock()
+		c.changed.Broadcast()
+		c.Unlock()
+	})
+	defer timer.Stop()
+
+	c.Lock()
+	defer c.Unlock()
+	for c.Network.ConnectionState() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("zellotest: connection %s, waited for %s", c.Network.ConnectionState(), state)
+		}
+		c.changed.Wait()
+	}
+}
+
+// PassedThrough reports whether the connection has moved to state since
+// the transition at index from of the state history
+func (c *Client) PassedThrough(from int, state network.ConnectionState) bool {
+	transitions := c.Network.StateSnapshot().Transitions
+	for i := from; i < len(transitions); i++ {
+		if transitions[i].To == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminated reports whether a worker has requested application
+// termination
+func (c *Client) Terminated() bool {
+	select {
+	case <-c.term:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,67 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package zellotest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Logs collects log messages so that a test can wait for a worker to
+// reach a point that publishes no event
+type Logs struct {
+	entries chan string
+}
+
+// Fire is called by logrus for each entry
+func (l *Logs) Fire(e *log.Entry) error {
+	select {
+	case l.entries <- e.Message:
+	default:
+	}
+	return nil
+}
+
+// Levels logs are collected at
+func (l *Logs) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// WatchLogs collects debug and higher log messages until the test ends
+func WatchLogs(t testing.TB) *Logs {
+	l := &Logs{entries: make(chan string, 1024)}
+	level := log.GetLevel()
+	hooks := make(log.LevelHooks)
+	for k, v := range log.StandardLogger().Hooks {
+		hooks[k] = v
+	}
+	log.SetLevel(log.DebugLevel)
+	log.AddHook(l)
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(hooks)
+		log.SetLevel(level)
+	})
+	return l
+}
+
+// Expect waits for a log message containing text, failing the test if
+// none is logged within the default timeout
+func (l *Logs) Expect(t testing.TB, text string) string {
+	t.Helper()
+	timeout := time.After(defaultWaitTimeout)
+	for {
+		select {
+		case m := <-l.entries:
+			if strings.Contains(m, text) {
+				return m
+			}
+		case <-timeout:
+			t.Fatalf("zellotest: nothing logged containing %q", text)
+			return ""
+		}
+	}
+}
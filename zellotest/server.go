@@ -0,0 +1,498 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+// Package zellotest provides an in-process WebSocket server that speaks
+// enough of the Zello channel API to exercise the monitor's workers
+// without a connection to zello.io.
+package zellotest
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jcmurray/monitor/protocolapp"
+	"github.com/juju/errors"
+)
+
+const (
+	defaultRefreshToken = "zellotest-refresh-token"
+	defaultWaitTimeout  = 5 * time.Second
+	streamDataPrefix    = 0x01
+	imageDataPrefix     = 0x02
+	imageTypeFull       = 1
+	imageTypeThumbnail  = 2
+)
+
+// Received is a message sent to the server by the client
+type Received struct {
+	Command string
+	Seq     int
+	Raw     []byte
+	Binary  bool
+	At      time.Time
+}
+
+// Decode unmarshals the message into v
+func (r Received) Decode(v interface{}) error {
+	return json.Unmarshal(r.Raw, v)
+}
+
+// ResponseFunc returns the response to a command, or nil for no response
+type ResponseFunc func(Received) *protocolapp.Response
+
+// Server is a fake Zello channel API server
+type Server struct {
+	sync.Mutex
+	httpServer   *httptest.Server
+	upgrader     websocket.Upgrader
+	conn         *websocket.Conn
+	writeLock    sync.Mutex
+	connected    chan struct{}
+	disconnected chan struct{}
+	received     []Received
+	taken        []bool
+	receivedCond *sync.Cond
+	handlers     map[string]ResponseFunc
+	username     string
+	password     string
+	authToken    string
+	refreshToken string
+	logonError   string
+	autoStatus   bool
+	nextStreamID int
+	nextImageID  int
+	nextMessage  int
+	logons       int
+}
+
+// NewServer starts a new Server listening on a local port
+func NewServer() *Server {
+	s := &Server{
+		connected:    make(chan struct{}, 1),
+		disconnected: make(chan struct{}, 1),
+		handlers:     make(map[string]ResponseFunc),
+		refreshToken: defaultRefreshToken,
+		autoStatus:   true,
+		nextStreamID: 1000,
+		nextImageID:  2000,
+		nextMessage:  3000,
+	}
+	s.receivedCond = sync.NewCond(&s.Mutex)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.serveWebSocket)
+	s.httpServer = httptest.NewServer(mux)
+	return s
+}
+
+// URL of the WebSocket endpoint, suitable for server.url
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.httpServer.URL, "http") + "/ws"
+}
+
+// Close the client connection and stop the server
+func (s *Server) Close() {
+	s.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.receivedCond.Broadcast()
+	s.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	s.httpServer.Close()
+}
+
+// SetCredentials makes logon require these values; empty values match anything
+func (s *Server) SetCredentials(username string, password string, authToken string) {
+	s.Lock()
+	defer s.Unlock()
+	s.username = username
+	s.password = password
+	s.authToken = authToken
+}
+
+// SetRefreshToken sets the refresh token returned by a successful logon
+func (s *Server) SetRefreshToken(token string) {
+	s.Lock()
+	defer s.Unlock()
+	s.refreshToken = token
+}
+
+// RejectLogon makes every logon fail with the given error, e.g. "not authorized".
+// An empty string accepts logons again.
+func (s *Server) RejectLogon(zelloError string) {
+	s.Lock()
+	defer s.Unlock()
+	s.logonError = zelloError
+}
+
+// SetAutoStatus controls whether an online on_channel_status follows a successful logon
+func (s *Server) SetAutoStatus(enabled bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.autoStatus = enabled
+}
+
+// Handle sets the response to a command, replacing the default success response
+func (s *Server) Handle(command string, f ResponseFunc) {
+	s.Lock()
+	defer s.Unlock()
+	s.handlers[command] = f
+}
+
+// Logons returns the number of logon requests received
+func (s *Server) Logons() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.logons
+}
+
+// WaitForConnection waits for the client to connect
+func (s *Server) WaitForConnection(timeout time.Duration) error {
+	select {
+	case <-s.connected:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for client connection")
+	}
+}
+
+// WaitForDisconnection waits for the client to close its connection
+func (s *Server) WaitForDisconnection(timeout time.Duration) error {
+	select {
+	case <-s.disconnected:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for the client to disconnect")
+	}
+}
+
+// Received returns a copy of every message the client has sent
+func (s *Server) Received() []Received {
+	s.Lock()
+	defer s.Unlock()
+	received := make([]Received, len(s.received))
+	copy(received, s.received)
+	return received
+}
+
+// WaitForCommand waits for the client to send a command, returning the
+// first one not already returned by an earlier call
+func (s *Server) WaitForCommand(command string, timeout time.Duration) (Received, error) {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.Lock()
+		s.receivedCond.Broadcast()
+		s.Unlock()
+	})
+	defer timer.Stop()
+
+	s.Lock()
+	defer s.Unlock()
+	for {
+		for i := range s.received {
+			if !s.taken[i] && s.received[i].Command == command {
+				s.taken[i] = true
+				return s.received[i], nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return Received{}, errors.Errorf("timed out waiting for '%s'", command)
+		}
+		s.receivedCond.Wait()
+	}
+}
+
+// SendChannelStatus sends an on_channel_status event
+func (s *Server) SendChannelStatus(status *protocolapp.OnChannelStatus) error {
+	status.Command = protocolapp.OnChannelStatusEvent
+	return s.SendJSON(status)
+}
+
+// SendOnline sends an online on_channel_status supporting images, texts and locations
+func (s *Server) SendOnline(channel string, usersOnline int) error {
+	return s.SendChannelStatus(&protocolapp.OnChannelStatus{
+		Channel:            channel,
+		Status:             "online",
+		UsersOnline:        usersOnline,
+		ImagesSupported:    true,
+		TextingSupported:   true,
+		LocationsSupported: true,
+	})
+}
+
+// SendError sends an on_error event
+func (s *Server) SendError(zelloError string) error {
+	return s.SendJSON(map[string]string{
+		"command": protocolapp.OnErrorEvent,
+		"error":   zelloError,
+	})
+}
+
+// SendText sends an on_text_message event and returns its message id
+func (s *Server) SendText(channel string, from string, forUser string, text string) (int, error) {
+	id := s.nextID(&s.nextMessage)
+	return id, s.SendJSON(&protocolapp.OnTextMessage{
+		Command:   protocolapp.OnTextMessageEvent,
+		Channel:   channel,
+		From:      from,
+		For:       forUser,
+		MessageID: id,
+		Text:      text,
+	})
+}
+
+// SendLocation sends an on_location event and returns its message id
+func (s *Server) SendLocation(channel string, from string, forUser string, latitude float64, longitude float64, accuracy float64, address string) (int, error) {
+	id := s.nextID(&s.nextMessage)
+	return id, s.SendJSON(&protocolapp.OnLocation{
+		Command:          protocolapp.OnLocationEvent,
+		Channel:          channel,
+		From:             from,
+		For:              forUser,
+		MessageID:        id,
+		Latitude:         latitude,
+		Longitude:        longitude,
+		Accuracy:         accuracy,
+		FormattedAddress: address,
+	})
+}
+
+// SendStream sends on_stream_start, one binary frame per Opus packet and
+// on_stream_stop, returning the stream id
+func (s *Server) SendStream(channel string, from string, forUser string, packets [][]byte) (int, error) {
+	id := s.nextID(&s.nextStreamID)
+
+	// Codec header: sample rate (little endian), frames per packet, frame size in ms
+	header := []byte{0, 0, 2, 60}
+	binary.LittleEndian.PutUint16(header[0:2], 16000)
+
+	err := s.SendJSON(&protocolapp.OnStreamStart{
+		Command:        protocolapp.OnStreamStartEvent,
+		Type:           "audio",
+		Codec:          "opus",
+		PacketDuration: 120,
+		StreamID:       id,
+		Channel:        channel,
+		From:           from,
+		For:            forUser,
+		CodecHeader:    base64.StdEncoding.EncodeToString(header),
+	})
+	if err != nil {
+		return id, err
+	}
+	for i, packet := range packets {
+		data := make([]byte, 9, 9+len(packet))
+		data[0] = streamDataPrefix
+		binary.BigEndian.PutUint32(data[1:5], uint32(id))
+		binary.BigEndian.PutUint32(data[5:9], uint32(i))
+		if err := s.SendBinary(append(data, packet...)); err != nil {
+			return id, err
+		}
+	}
+	return id, s.SendJSON(&protocolapp.OnStreamStop{
+		Command:  protocolapp.OnStreamStopEvent,
+		StreamID: id,
+	})
+}
+
+// SendImage sends on_image followed by the thumbnail and full image
+// binary frames, returning the image id
+func (s *Server) SendImage(channel string, from string, forUser string, thumbnail []byte, full []byte) (int, error) {
+	id := s.nextID(&s.nextImageID)
+	err := s.SendJSON(&protocolapp.OnImage{
+		Command:   protocolapp.OnImageEvent,
+		Channel:   channel,
+		From:      from,
+		For:       forUser,
+		MessageID: id,
+		Type:      "jpeg",
+		Source:    "camera",
+	})
+	if err != nil {
+		return id, err
+	}
+	for _, part := range []struct {
+		imageType uint32
+		data      []byte
+	}{{imageTypeThumbnail, thumbnail}, {imageTypeFull, full}} {
+		if part.data == nil {
+			continue
+		}
+		data := make([]byte, 9, 9+len(part.data))
+		data[0] = imageDataPrefix
+		binary.BigEndian.PutUint32(data[1:5], uint32(id))
+		binary.BigEndian.PutUint32(data[5:9], part.imageType)
+		if err := s.SendBinary(append(data, part.data...)); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// SendJSON sends any value as a text frame
+func (s *Server) SendJSON(v interface{}) error {
+	buff, err := json.Marshal(v)
+	if err != nil {
+		return errors.Annotate(err, "marshal")
+	}
+	return s.write(websocket.TextMessage, buff)
+}
+
+// SendBinary sends a binary frame
+func (s *Server) SendBinary(data []byte) error {
+	return s.write(websocket.BinaryMessage, data)
+}
+
+// CloseConnection closes the client connection with a WebSocket close code,
+// e.g. 3001 or 3002
+func (s *Server) CloseConnection(code int, text string) error {
+	s.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.Unlock()
+	if conn == nil {
+		return errors.New("no client connected")
+	}
+	s.writeLock.Lock()
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+	s.writeLock.Unlock()
+	conn.Close()
+	return err
+}
+
+func (s *Server) write(messageType int, data []byte) error {
+	s.Lock()
+	conn := s.conn
+	s.Unlock()
+	if conn == nil {
+		return errors.New("no client connected")
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return conn.WriteMessage(messageType, data)
+}
+
+func (s *Server) nextID(counter *int) int {
+	s.Lock()
+	defer s.Unlock()
+	*counter++
+	return *counter
+}
+
+func (s *Server) serveWebSocket(rw http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		return
+	}
+	s.Lock()
+	previous := s.conn
+	s.conn = conn
+	s.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	select {
+	case s.connected <- struct{}{}:
+	default:
+	}
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			s.Lock()
+			current := s.conn == conn
+			if current {
+				s.conn = nil
+			}
+			s.Unlock()
+			if current {
+				select {
+				case s.disconnected <- struct{}{}:
+				default:
+				}
+			}
+			return
+		}
+		r := Received{Raw: message, Binary: messageType == websocket.BinaryMessage, At: time.Now()}
+		if !r.Binary {
+			var header struct {
+				Command string `json:"command"`
+				Seq     int    `json:"seq"`
+			}
+			json.Unmarshal(message, &header)
+			r.Command = header.Command
+			r.Seq = header.Seq
+		}
+
+		s.Lock()
+		s.received = append(s.received, r)
+		s.taken = append(s.taken, false)
+		s.receivedCond.Broadcast()
+		s.Unlock()
+
+		if r.Command != "" {
+			s.respond(r)
+		}
+	}
+}
+
+func (s *Server) respond(r Received) {
+	s.Lock()
+	handler, ok := s.handlers[r.Command]
+	s.Unlock()
+
+	var response *protocolapp.Response
+	switch {
+	case ok:
+		response = handler(r)
+	case r.Command == protocolapp.LogonRequest:
+		s.logon(r)
+		return
+	default:
+		response = &protocolapp.Response{Success: true}
+	}
+	if response != nil {
+		response.Seq = r.Seq
+		s.SendJSON(response)
+	}
+}
+
+func (s *Server) logon(r Received) {
+	logon := protocolapp.Logon{}
+	r.Decode(&logon)
+
+	s.Lock()
+	s.logons++
+	zelloError := s.logonError
+	switch {
+	case zelloError != "":
+	case s.username != "" && logon.Username != s.username:
+		zelloError = "invalid username"
+	case s.password != "" && logon.Password != s.password:
+		zelloError = "invalid password"
+	case logon.RefreshToken != "" && logon.RefreshToken != s.refreshToken:
+		zelloError = "not authorized"
+	case logon.RefreshToken == "" && s.authToken != "" && logon.AuthToken != s.authToken:
+		zelloError = "not authorized"
+	}
+	refreshToken := s.refreshToken
+	autoStatus := s.autoStatus
+	s.Unlock()
+
+	if zelloError != "" {
+		s.SendJSON(&protocolapp.Response{Seq: r.Seq, Error: zelloError})
+		return
+	}
+	s.SendJSON(&protocolapp.Response{Seq: r.Seq, Success: true, RefreshToken: refreshToken})
+	if autoStatus {
+		s.SendOnline(logon.Channel, 1)
+	}
+}
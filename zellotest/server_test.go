@@ -0,0 +1,216 @@
+// cSpell.language:en-GB
+// cSpell:disable
+
+package zellotest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jcmurray/monitor/protocolapp"
+)
+
+// dial connects a bare WebSocket client to the server
+func dial(t *testing.T, s *Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(s.URL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := s.WaitForConnection(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, v interface{}) {
+	t.Helper()
+	if err := conn.WriteJSON(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next reads the next frame, decoding text frames into v
+func next(t *testing.T, conn *websocket.Conn, v interface{}) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	messageType, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType == websocket.TextMessage && v != nil {
+		if err := json.Unmarshal(message, v); err != nil {
+			t.Fatalf("%s: %s", message, err)
+		}
+	}
+	return message
+}
+
+func logon(seq int, password string, authToken string, refreshToken string) *protocolapp.Logon {
+	return &protocolapp.Logon{
+		Command:      protocolapp.LogonRequest,
+		Seq:          seq,
+		Channel:      "Test Channel",
+		Username:     "monitor",
+		Password:     password,
+		AuthToken:    authToken,
+		RefreshToken: refreshToken,
+	}
+}
+
+func TestServerLogon(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetCredentials("monitor", "secret", "token")
+	s.SetRefreshToken("refresh")
+	conn := dial(t, s)
+
+	send(t, conn, logon(1, "wrong", "token", ""))
+	resp := &protocolapp.Response{}
+	next(t, conn, resp)
+	if resp.Seq != 1 || resp.Success || resp.Error != "invalid password" {
+		t.Fatalf("bad password answered with %+v", resp)
+	}
+
+	send(t, conn, logon(2, "secret", "", "stale"))
+	resp = &protocolapp.Response{}
+	next(t, conn, resp)
+	if resp.Success || resp.Error != "not authorized" {
+		t.Fatalf("stale refresh token answered with %+v", resp)
+	}
+
+	send(t, conn, logon(3, "secret", "token", ""))
+	resp = &protocolapp.Response{}
+	next(t, conn, resp)
+	if resp.Seq != 3 || !resp.Success || resp.RefreshToken != "refresh" {
+		t.Fatalf("logon answered with %+v", resp)
+	}
+	status := &protocolapp.OnChannelStatus{}
+	next(t, conn, status)
+	if status.Command != protocolapp.OnChannelStatusEvent || status.Status != "online" || status.Channel != "Test Channel" {
+		t.Fatalf("logon followed by %+v", status)
+	}
+
+	send(t, conn, logon(4, "secret", "", "refresh"))
+	resp = &protocolapp.Response{}
+	next(t, conn, resp)
+	if !resp.Success {
+		t.Fatalf("refresh token logon answered with %+v", resp)
+	}
+	if s.Logons() != 4 {
+		t.Errorf("%d logons counted", s.Logons())
+	}
+}
+
+func TestServerRejectLogon(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RejectLogon("not authorized")
+	s.SetAutoStatus(false)
+	conn := dial(t, s)
+	send(t, conn, logon(1, "", "", ""))
+	resp := &protocolapp.Response{}
+	next(t, conn, resp)
+	if resp.Success || resp.Error != "not authorized" {
+		t.Fatalf("rejected logon answered with %+v", resp)
+	}
+
+	s.RejectLogon("")
+	send(t, conn, logon(2, "", "", ""))
+	resp = &protocolapp.Response{}
+	next(t, conn, resp)
+	if !resp.Success {
+		t.Fatalf("logon answered with %+v", resp)
+	}
+	// No status follows when automatic status is off
+	s.SendError("channel is not ready")
+	e := protocolapp.NewOnError()
+	next(t, conn, e)
+	if e.Command != protocolapp.OnErrorEvent {
+		t.Fatalf("logon followed by %+v", e)
+	}
+}
+
+func TestServerCommands(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Handle(protocolapp.TextMessageSendRequest, func(r Received) *protocolapp.Response {
+		return &protocolapp.Response{Error: "listen only connection"}
+	})
+	conn := dial(t, s)
+
+	send(t, conn, &protocolapp.SendTextMessage{Command: protocolapp.TextMessageSendRequest, Seq: 7, For: "alice", Text: "hello"})
+	resp := &protocolapp.Response{}
+	next(t, conn, resp)
+	if resp.Seq != 7 || resp.Error != "listen only connection" {
+		t.Fatalf("handled command answered with %+v", resp)
+	}
+	send(t, conn, map[string]interface{}{"command": "start_stream", "seq": 8})
+	resp = &protocolapp.Response{}
+	next(t, conn, resp)
+	if resp.Seq != 8 || !resp.Success {
+		t.Fatalf("command answered with %+v", resp)
+	}
+
+	s.ExpectText(t, "alice", "hello")
+	r := s.ExpectCommand(t, "start_stream")
+	if r.Seq != 8 {
+		t.Errorf("start_stream seq %d", r.Seq)
+	}
+	// Each command is returned once
+	s.ExpectNoCommand(t, protocolapp.TextMessageSendRequest, 50*time.Millisecond)
+	if len(s.Received()) != 2 {
+		t.Errorf("%d messages received", len(s.Received()))
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	conn := dial(t, s)
+
+	id, err := s.SendText("Test Channel", "alice", "monitor", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := protocolapp.NewOnTextMessage()
+	next(t, conn, text)
+	if text.MessageID != id || text.From != "alice" || text.For != "monitor" || text.Text != "hello" {
+		t.Errorf("text %+v", text)
+	}
+
+	id, err = s.SendStream("Test Channel", "alice", "", [][]byte{{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := protocolapp.NewOnStreamStart()
+	next(t, conn, start)
+	if start.StreamID != id || start.Codec != "opus" {
+		t.Errorf("stream start %+v", start)
+	}
+	packet := next(t, conn, nil)
+	if packet[0] != streamDataPrefix || binary.BigEndian.Uint32(packet[1:5]) != uint32(id) || string(packet[9:]) != "\x01\x02\x03" {
+		t.Errorf("stream packet %v", packet)
+	}
+	stop := protocolapp.NewOnStreamStop()
+	next(t, conn, stop)
+	if stop.StreamID != id {
+		t.Errorf("stream stop %+v", stop)
+	}
+
+	if err := s.CloseConnection(3001, "channel closed"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, 3001) {
+		t.Errorf("closed with %v", err)
+	}
+	if err := s.SendError("not logged in"); err == nil {
+		t.Error("sent with no client connected")
+	}
+}